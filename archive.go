@@ -0,0 +1,231 @@
+package plist
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// keyedArchiverEpoch is the reference date NSDate measures its time intervals from.
+var keyedArchiverEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Unarchive parses the NSKeyedArchiver property list in data, in any format, and returns the object graph it
+// describes with its UID references resolved, as a generic tree of the types Unmarshal produces for an interface{}.
+//
+// The collection and value classes Foundation archives are turned into the Go values they represent:
+// NSArray, NSSet and NSOrderedSet become []interface{}, NSDictionary becomes map[string]interface{},
+// and NSString, NSData and NSDate become string, []byte and time.Time. Objects of any other class become a
+// map[string]interface{} of their archived fields, with the class name under "$class". The "$null" object is nil.
+//
+// If $top holds only the conventional "root" key, Unarchive returns the root object; otherwise it returns a
+// map[string]interface{} of all the top-level objects. An object shared by several others is resolved once and
+// shared in the result. A reference back to an object that is still being resolved, which would make the result
+// cyclic, is replaced with nil.
+func Unarchive(data []byte) (interface{}, error) {
+	pval, err := NewDecoder(bytes.NewReader(data)).parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	if pval == nil {
+		// An empty document, such as <plist></plist>, holds no object at all.
+		return nil, fmt.Errorf("plist: not an NSKeyedArchiver archive")
+	}
+	if pval.kind != Dictionary {
+		return nil, fmt.Errorf("plist: expected a keyed archive, found %s", plistKindNames[pval.kind])
+	}
+
+	archive := pval.value.(*dictionary).m
+	if archiver := archive["$archiver"]; archiver == nil || archiver.kind != String || archiver.value.(string) != "NSKeyedArchiver" {
+		return nil, fmt.Errorf("plist: not an NSKeyedArchiver archive")
+	}
+	objects, top := archive["$objects"], archive["$top"]
+	if objects == nil || objects.kind != Array {
+		return nil, fmt.Errorf("plist: keyed archive has no $objects array")
+	}
+	if top == nil || top.kind != Dictionary {
+		return nil, fmt.Errorf("plist: keyed archive has no $top dictionary")
+	}
+
+	u := &unarchiver{
+		objects:   objects.value.([]*Value),
+		resolved:  make(map[UID]interface{}),
+		resolving: make(map[UID]bool),
+	}
+	topObjects := top.value.(*dictionary).m
+	if root, ok := topObjects["root"]; ok && len(topObjects) == 1 {
+		return u.resolve(root)
+	}
+	return u.resolveMap(topObjects)
+}
+
+// unarchiver resolves the UID references in a keyed archive against its $objects.
+type unarchiver struct {
+	objects   []*Value
+	resolved  map[UID]interface{}
+	resolving map[UID]bool
+}
+
+// resolve returns the generic value of pval, following any UID references it contains.
+func (u *unarchiver) resolve(pval *Value) (interface{}, error) {
+	if pval == nil {
+		return nil, nil
+	}
+	if uid, ok := referenceUID(pval); ok {
+		return u.resolveUID(uid)
+	}
+
+	switch pval.kind {
+	case Array, CFSet:
+		values := pval.value.([]*Value)
+		out := make([]interface{}, 0, len(values))
+		for _, subval := range values {
+			v, err := u.resolve(subval)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case Dictionary:
+		dict := pval.value.(*dictionary).m
+		if class, ok := dict["$class"]; ok {
+			return u.resolveObject(class, dict)
+		}
+		return u.resolveMap(dict)
+	}
+	return (&Decoder{}).valueInterface(pval), nil
+}
+
+// referenceUID returns the object reference pval holds: a UID, or a CF$UID dictionary in formats without UIDs.
+func referenceUID(pval *Value) (UID, bool) {
+	if pval.kind == Dictionary {
+		if uid := uidFromDictionary(pval.value.(*dictionary).m); uid != nil {
+			pval = uid
+		}
+	}
+	if pval.kind == CFUID {
+		return pval.value.(UID), true
+	}
+	return 0, false
+}
+
+// resolveUID returns the generic value of the object uid refers to.
+func (u *unarchiver) resolveUID(uid UID) (interface{}, error) {
+	if uint64(uid) >= uint64(len(u.objects)) {
+		return nil, fmt.Errorf("plist: keyed archive object reference %d out of range for %d objects", uid, len(u.objects))
+	}
+	if v, ok := u.resolved[uid]; ok {
+		return v, nil
+	}
+	if u.resolving[uid] {
+		// Break the cycle.
+		return nil, nil
+	}
+
+	obj := u.objects[uid]
+	if obj != nil && obj.kind == String && obj.value.(string) == "$null" {
+		return nil, nil
+	}
+
+	u.resolving[uid] = true
+	v, err := u.resolve(obj)
+	delete(u.resolving, uid)
+	if err != nil {
+		return nil, err
+	}
+	u.resolved[uid] = v
+	return v, nil
+}
+
+// resolveMap returns the generic value of a dictionary that is not an archived object.
+func (u *unarchiver) resolveMap(dict map[string]*Value) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(dict))
+	for k, subval := range dict {
+		v, err := u.resolve(subval)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// className returns the name recorded in the class description a $class reference refers to.
+func (u *unarchiver) className(class *Value) (string, error) {
+	if uid, ok := referenceUID(class); ok && uint64(uid) < uint64(len(u.objects)) {
+		if desc := u.objects[uid]; desc != nil && desc.kind == Dictionary {
+			if name := desc.value.(*dictionary).m["$classname"]; name != nil && name.kind == String {
+				return name.value.(string), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("plist: keyed archive object has an invalid $class")
+}
+
+// resolveObject returns the generic value of an archived object of the class described by class.
+func (u *unarchiver) resolveObject(class *Value, fields map[string]*Value) (interface{}, error) {
+	name, err := u.className(class)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "NSArray", "NSMutableArray", "NSSet", "NSMutableSet", "NSOrderedSet", "NSMutableOrderedSet":
+		return u.resolve(fields["NS.objects"])
+	case "NSDictionary", "NSMutableDictionary":
+		keys, err := u.resolve(fields["NS.keys"])
+		if err != nil {
+			return nil, err
+		}
+		values, err := u.resolve(fields["NS.objects"])
+		if err != nil {
+			return nil, err
+		}
+		keyList, _ := keys.([]interface{})
+		valueList, _ := values.([]interface{})
+		if len(keyList) != len(valueList) {
+			return nil, fmt.Errorf("plist: keyed archive %s has %d keys but %d values", name, len(keyList), len(valueList))
+		}
+		out := make(map[string]interface{}, len(keyList))
+		for i, k := range keyList {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("plist: keyed archive %s has a key of type %T; only string keys are supported", name, k)
+			}
+			out[key] = valueList[i]
+		}
+		return out, nil
+	case "NSString", "NSMutableString":
+		return u.resolve(fields["NS.string"])
+	case "NSData", "NSMutableData":
+		return u.resolve(fields["NS.data"])
+	case "NSDate":
+		var seconds float64
+		switch t := fields["NS.time"]; {
+		case t != nil && t.kind == Real:
+			seconds = t.Float()
+		case t != nil && t.kind == Integer:
+			seconds = float64(t.Int())
+		default:
+			return nil, fmt.Errorf("plist: keyed archive NSDate has no NS.time")
+		}
+		// A time.Duration only reaches 292 years, which falls short of distantPast and distantFuture.
+		sec, frac := math.Modf(seconds)
+		return time.Unix(keyedArchiverEpoch.Unix()+int64(sec), int64(frac*1e9)).In(time.UTC), nil
+	}
+
+	out := make(map[string]interface{}, len(fields))
+	for k, subval := range fields {
+		if k == "$class" {
+			out[k] = name
+			continue
+		}
+		v, err := u.resolve(subval)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,209 @@
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// keyedArchive is what NSKeyedArchiver produces for a dictionary holding a string, a date, a set and an array,
+// where the array holds a custom object and the object refers back to the dictionary.
+const keyedArchive = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>$archiver</key>
+	<string>NSKeyedArchiver</string>
+	<key>$objects</key>
+	<array>
+		<string>$null</string>
+		<dict>
+			<key>$class</key>
+			<dict><key>CF$UID</key><integer>12</integer></dict>
+			<key>NS.keys</key>
+			<array>
+				<dict><key>CF$UID</key><integer>2</integer></dict>
+				<dict><key>CF$UID</key><integer>3</integer></dict>
+				<dict><key>CF$UID</key><integer>4</integer></dict>
+				<dict><key>CF$UID</key><integer>5</integer></dict>
+			</array>
+			<key>NS.objects</key>
+			<array>
+				<dict><key>CF$UID</key><integer>6</integer></dict>
+				<dict><key>CF$UID</key><integer>7</integer></dict>
+				<dict><key>CF$UID</key><integer>9</integer></dict>
+				<dict><key>CF$UID</key><integer>14</integer></dict>
+			</array>
+		</dict>
+		<string>name</string>
+		<string>created</string>
+		<string>items</string>
+		<string>tags</string>
+		<string>Example</string>
+		<dict>
+			<key>$class</key>
+			<dict><key>CF$UID</key><integer>8</integer></dict>
+			<key>NS.time</key>
+			<real>600000000</real>
+		</dict>
+		<dict>
+			<key>$classname</key>
+			<string>NSDate</string>
+			<key>$classes</key>
+			<array><string>NSDate</string><string>NSObject</string></array>
+		</dict>
+		<dict>
+			<key>$class</key>
+			<dict><key>CF$UID</key><integer>13</integer></dict>
+			<key>NS.objects</key>
+			<array>
+				<dict><key>CF$UID</key><integer>10</integer></dict>
+				<dict><key>CF$UID</key><integer>10</integer></dict>
+			</array>
+		</dict>
+		<dict>
+			<key>$class</key>
+			<dict><key>CF$UID</key><integer>11</integer></dict>
+			<key>title</key>
+			<dict><key>CF$UID</key><integer>6</integer></dict>
+			<key>count</key>
+			<integer>3</integer>
+			<key>owner</key>
+			<dict><key>CF$UID</key><integer>1</integer></dict>
+			<key>note</key>
+			<dict><key>CF$UID</key><integer>0</integer></dict>
+		</dict>
+		<dict>
+			<key>$classname</key>
+			<string>ExampleItem</string>
+			<key>$classes</key>
+			<array><string>ExampleItem</string><string>NSObject</string></array>
+		</dict>
+		<dict>
+			<key>$classname</key>
+			<string>NSMutableDictionary</string>
+			<key>$classes</key>
+			<array><string>NSMutableDictionary</string><string>NSDictionary</string><string>NSObject</string></array>
+		</dict>
+		<dict>
+			<key>$classname</key>
+			<string>NSArray</string>
+			<key>$classes</key>
+			<array><string>NSArray</string><string>NSObject</string></array>
+		</dict>
+		<dict>
+			<key>$class</key>
+			<dict><key>CF$UID</key><integer>15</integer></dict>
+			<key>NS.objects</key>
+			<array>
+				<dict><key>CF$UID</key><integer>16</integer></dict>
+			</array>
+		</dict>
+		<dict>
+			<key>$classname</key>
+			<string>NSSet</string>
+			<key>$classes</key>
+			<array><string>NSSet</string><string>NSObject</string></array>
+		</dict>
+		<string>blue</string>
+	</array>
+	<key>$top</key>
+	<dict>
+		<key>root</key>
+		<dict><key>CF$UID</key><integer>1</integer></dict>
+	</dict>
+	<key>$version</key>
+	<integer>100000</integer>
+</dict>
+</plist>`
+
+func TestUnarchive(t *testing.T) {
+	item := map[string]interface{}{
+		"$class": "ExampleItem",
+		"title":  "Example",
+		"count":  uint64(3),
+		"owner":  nil, // refers back to the root, which is still being resolved
+		"note":   nil,
+	}
+	expected := map[string]interface{}{
+		"name":    "Example",
+		"created": time.Date(2020, time.January, 6, 10, 40, 0, 0, time.UTC),
+		"items":   []interface{}{item, item},
+		"tags":    []interface{}{"blue"},
+	}
+
+	// The archive decodes the same whichever format it is stored in.
+	pval, err := NewDecoder(bytes.NewReader([]byte(keyedArchive))).DecodeValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := Marshal(pval, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{[]byte(keyedArchive), binary} {
+		root, err := Unarchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(root, expected) {
+			t.Errorf("Expected %#v, received %#v", expected, root)
+		}
+
+		// An object referenced twice is resolved once.
+		items := root.(map[string]interface{})["items"].([]interface{})
+		if reflect.ValueOf(items[0]).Pointer() != reflect.ValueOf(items[1]).Pointer() {
+			t.Error("Expected both references to share the same object")
+		}
+	}
+}
+
+func TestUnarchiveDates(t *testing.T) {
+	dates := []struct {
+		time     string
+		expected time.Time
+	}{
+		{"<real>600000000.5</real>", time.Date(2020, time.January, 6, 10, 40, 0, 500000000, time.UTC)},
+		{"<real>-0.25</real>", time.Date(2000, time.December, 31, 23, 59, 59, 750000000, time.UTC)},
+		// distantFuture and distantPast lie further from the reference date than a time.Duration can measure.
+		{"<real>63113904000</real>", time.Date(4001, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// Foundation's calendar is Julian before 1582, in which distantPast is 1 January 1; Go's is Gregorian throughout.
+		{"<real>-63114076800</real>", time.Date(0, time.December, 30, 0, 0, 0, 0, time.UTC)},
+		{"<integer>63113904000</integer>", time.Date(4001, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, date := range dates {
+		archive := `<plist><dict><key>$archiver</key><string>NSKeyedArchiver</string><key>$objects</key><array><string>$null</string>` +
+			`<dict><key>$class</key><dict><key>CF$UID</key><integer>2</integer></dict><key>NS.time</key>` + date.time + `</dict>` +
+			`<dict><key>$classname</key><string>NSDate</string></dict></array>` +
+			`<key>$top</key><dict><key>root</key><dict><key>CF$UID</key><integer>1</integer></dict></dict></dict></plist>`
+		v, err := Unarchive([]byte(archive))
+		if err != nil || !reflect.DeepEqual(v, date.expected) {
+			t.Errorf("%s: expected %v, received %v (%v)", date.time, date.expected, v, err)
+		}
+	}
+}
+
+func TestUnarchiveErrors(t *testing.T) {
+	archives := []string{
+		`<plist></plist>`,
+		`<plist><array/></plist>`,
+		`<plist><dict><key>$archiver</key><string>NSArchiver</string></dict></plist>`,
+		`<plist><dict><key>$archiver</key><string>NSKeyedArchiver</string><key>$top</key><dict/></dict></plist>`,
+		`<plist><dict><key>$archiver</key><string>NSKeyedArchiver</string><key>$objects</key><array/><key>$top</key><dict><key>root</key><dict><key>CF$UID</key><integer>1</integer></dict></dict></dict></plist>`,
+		`<plist><dict><key>$archiver</key><string>NSKeyedArchiver</string><key>$objects</key><array><string>$null</string><dict><key>$class</key><dict><key>CF$UID</key><integer>0</integer></dict></dict></array><key>$top</key><dict><key>root</key><dict><key>CF$UID</key><integer>1</integer></dict></dict></dict></plist>`,
+	}
+	for _, archive := range archives {
+		if v, err := Unarchive([]byte(archive)); err == nil {
+			t.Errorf("%s: expected an error, received %#v", archive, v)
+		}
+	}
+
+	// Archives with several top-level objects unarchive to a map of them.
+	multiple := `<plist><dict><key>$archiver</key><string>NSKeyedArchiver</string><key>$objects</key><array><string>$null</string><string>a</string></array><key>$top</key><dict><key>first</key><dict><key>CF$UID</key><integer>1</integer></dict><key>second</key><dict><key>CF$UID</key><integer>0</integer></dict></dict></dict></plist>`
+	v, err := Unarchive([]byte(multiple))
+	if expected := map[string]interface{}{"first": "a", "second": nil}; err != nil || !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %#v, received %#v (%v)", expected, v, err)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"math"
+	"math/big"
 	"runtime"
 	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type bplistTrailer struct {
@@ -23,6 +24,41 @@ type bplistTrailer struct {
 	OffsetTableOffset uint64
 }
 
+// BinaryInfo describes the layout of a binary property list, as recorded in its header, trailer and offset table.
+type BinaryInfo struct {
+	// Version is the format version given in the header: 0 for "bplist00".
+	Version int
+	// OffsetIntSize and ObjectRefSize are the sizes, in bytes, of the offset table's entries and of object references.
+	OffsetIntSize, ObjectRefSize int
+	// NumObjects is the number of objects in the property list, and TopObject the index of its root object.
+	NumObjects, TopObject uint64
+	// OffsetTableOffset is the position of the offset table in the file.
+	OffsetTableOffset uint64
+	// Offsets is the offset table: the position of each object in the file, indexed by object number.
+	Offsets []uint64
+}
+
+// RootOffset returns the position of the root object in the file.
+func (b *BinaryInfo) RootOffset() uint64 {
+	return b.Offsets[b.TopObject]
+}
+
+// info describes the layout of the document, once its trailer has been read.
+func (p *bplistParser) info() *BinaryInfo {
+	if p.offtable == nil {
+		return nil
+	}
+	return &BinaryInfo{
+		Version:           p.version,
+		OffsetIntSize:     int(p.trailer.OffsetIntSize),
+		ObjectRefSize:     int(p.trailer.ObjectRefSize),
+		NumObjects:        p.trailer.NumObjects,
+		TopObject:         p.trailer.TopObject,
+		OffsetTableOffset: p.trailer.OffsetTableOffset,
+		Offsets:           append([]uint64(nil), p.offtable...),
+	}
+}
+
 const (
 	bpTagNull        uint8 = 0x00
 	bpTagBoolFalse         = 0x08
@@ -35,42 +71,65 @@ const (
 	bpTagUTF16String       = 0x60
 	bpTagUID               = 0x80
 	bpTagArray             = 0xA0
+	bpTagSet               = 0xC0
 	bpTagDictionary        = 0xD0
 )
 
 type bplistGenerator struct {
 	writer   *countedWriter
 	uniqmap  map[interface{}]uint64
-	objmap   map[*plistValue]uint64
-	objtable []*plistValue
+	objmap   map[*Value]uint64
+	objtable []*Value
 	nobjects uint64
 	trailer  bplistTrailer
 }
 
-func (p *bplistGenerator) flattenPlistValue(pval *plistValue) {
+// uniqueFloat and uniqueData are used as deduplication keys so that they can't collide with other kinds' values.
+type uniqueFloat struct {
+	bits uint64
+	size int
+}
+
+type uniqueData string
+
+type uniqueBigInt string
+
+// uniqueDate is a date's instant; UnixNano can't tell apart dates 2^64 nanoseconds apart.
+type uniqueDate struct {
+	sec  int64
+	nsec int
+}
+
+// uniqueKey returns the key by which pval is deduplicated, or false if values of its kind are never shared.
+func uniqueKey(pval *Value) (interface{}, bool) {
 	switch pval.kind {
-	case String, Integer, Real:
-		if _, ok := p.uniqmap[pval.value]; ok {
-			return
+	case String, Integer, CFUID:
+		if n, ok := pval.value.(*big.Int); ok {
+			// Big integers are pointers, so equal ones are uniqued by their digits.
+			return uniqueBigInt(n.String()), true
 		}
-		p.uniqmap[pval.value] = p.nobjects
+		return pval.value, true
+	case Real:
+		// NaN is not equal to itself, so reals are uniqued by their bit patterns.
+		f := pval.value.(sizedFloat)
+		return uniqueFloat{math.Float64bits(f.value), f.bits}, true
 	case Date:
-		k := pval.value.(time.Time).UnixNano()
-		if _, ok := p.uniqmap[k]; ok {
-			return
-		}
-		p.uniqmap[k] = p.nobjects
+		t := pval.value.(time.Time)
+		return uniqueDate{t.Unix(), t.Nanosecond()}, true
 	case Data:
-		// Data are uniqued by their checksums.
-		// The wonderful difference between uint64 (which we use for numbers)
-		// and uint32 makes this possible.
-		// Todo: Look at calculating this only once and storing it somewhere;
-		// crc32 is fairly quick, however.
-		uniqkey := crc32.ChecksumIEEE(pval.value.([]byte))
-		if _, ok := p.uniqmap[uniqkey]; ok {
+		return uniqueData(pval.value.([]byte)), true
+	case CFNull:
+		return Null{}, true
+	}
+	return nil, false
+}
+
+func (p *bplistGenerator) flattenPlistValue(pval *Value) {
+	if key, ok := uniqueKey(pval); ok {
+		if _, ok := p.uniqmap[key]; ok {
 			return
 		}
-		p.uniqmap[uniqkey] = p.nobjects
+		p.uniqmap[key] = p.nobjects
 	}
 
 	p.objtable = append(p.objtable, pval)
@@ -82,39 +141,31 @@ func (p *bplistGenerator) flattenPlistValue(pval *plistValue) {
 		dict := pval.value.(*dictionary)
 		dict.populateArrays()
 		for _, k := range dict.keys {
-			p.flattenPlistValue(&plistValue{String, k})
+			p.flattenPlistValue(&Value{String, k})
 		}
 		for _, v := range dict.values {
 			p.flattenPlistValue(v)
 		}
-	case Array:
-		subvalues := pval.value.([]*plistValue)
-		for _, v := range subvalues {
+	case Array, CFSet:
+		for _, v := range nonNilValues(pval.value.([]*Value)) {
 			p.flattenPlistValue(v)
 		}
 	}
 }
 
-func (p *bplistGenerator) indexForPlistValue(pval *plistValue) (uint64, bool) {
-	var v uint64
-	var ok bool
-	switch pval.kind {
-	case String, Integer, Real:
-		v, ok = p.uniqmap[pval.value]
-	case Date:
-		v, ok = p.uniqmap[pval.value.(time.Time).UnixNano()]
-	case Data:
-		v, ok = p.uniqmap[crc32.ChecksumIEEE(pval.value.([]byte))]
-	default:
-		v, ok = p.objmap[pval]
+func (p *bplistGenerator) indexForPlistValue(pval *Value) (uint64, bool) {
+	if key, ok := uniqueKey(pval); ok {
+		v, ok := p.uniqmap[key]
+		return v, ok
 	}
+	v, ok := p.objmap[pval]
 	return v, ok
 }
 
-func (p *bplistGenerator) generateDocument(rootpval *plistValue) {
-	p.objtable = make([]*plistValue, 0, 15)
+func (p *bplistGenerator) generateDocument(rootpval *Value) {
+	p.objtable = make([]*Value, 0, 15)
 	p.uniqmap = make(map[interface{}]uint64)
-	p.objmap = make(map[*plistValue]uint64)
+	p.objmap = make(map[*Value]uint64)
 	p.flattenPlistValue(rootpval)
 
 	p.trailer.NumObjects = uint64(len(p.objtable))
@@ -139,7 +190,7 @@ func (p *bplistGenerator) generateDocument(rootpval *plistValue) {
 	binary.Write(p.writer, binary.BigEndian, p.trailer)
 }
 
-func (p *bplistGenerator) writePlistValue(pval *plistValue) {
+func (p *bplistGenerator) writePlistValue(pval *Value) {
 	if pval == nil {
 		return
 	}
@@ -148,11 +199,17 @@ func (p *bplistGenerator) writePlistValue(pval *plistValue) {
 	case Dictionary:
 		p.writeDictionaryTag(pval.value.(*dictionary))
 	case Array:
-		p.writeArrayTag(pval.value.([]*plistValue))
+		p.writeArrayTag(bpTagArray, pval.value.([]*Value))
+	case CFSet:
+		p.writeArrayTag(bpTagSet, pval.value.([]*Value))
 	case String:
 		p.writeStringTag(pval.value.(string))
 	case Integer:
-		p.writeIntTag(pval.value.(signedInt).value)
+		if n, ok := pval.value.(*big.Int); ok {
+			p.writeBigIntTag(n)
+			break
+		}
+		p.writeIntTag(pval.value.(signedInt).value, pval.value.(signedInt).signed)
 	case Real:
 		p.writeRealTag(pval.value.(sizedFloat).value, pval.value.(sizedFloat).bits)
 	case Boolean:
@@ -161,6 +218,10 @@ func (p *bplistGenerator) writePlistValue(pval *plistValue) {
 		p.writeDataTag(pval.value.([]byte))
 	case Date:
 		p.writeDateTag(pval.value.(time.Time))
+	case CFUID:
+		p.writeUIDTag(pval.value.(UID))
+	case CFNull:
+		binary.Write(p.writer, binary.BigEndian, bpTagNull)
 	}
 }
 
@@ -175,7 +236,6 @@ func minimumSizeForInt(n uint64) int {
 	default:
 		return 8
 	}
-	panic(errors.New("illegal integer size"))
 }
 
 func (p *bplistGenerator) writeSizedInt(n uint64, nbytes int) {
@@ -203,10 +263,15 @@ func (p *bplistGenerator) writeBoolTag(v bool) {
 	binary.Write(p.writer, binary.BigEndian, tag)
 }
 
-func (p *bplistGenerator) writeIntTag(n uint64) {
+// writeIntTag writes n in the smallest form that preserves it. Integers of 8 bytes are signed,
+// so unsigned values that don't fit in an int64 are written as 16-byte integers.
+func (p *bplistGenerator) writeIntTag(n uint64, signed bool) {
 	var tag uint8
 	var val interface{}
 	switch {
+	case !signed && n > math.MaxInt64:
+		val = [2]uint64{0, n}
+		tag = bpTagInteger | 0x4
 	case n <= uint64(0xff):
 		val = uint8(n)
 		tag = bpTagInteger | 0x0
@@ -225,6 +290,25 @@ func (p *bplistGenerator) writeIntTag(n uint64) {
 	binary.Write(p.writer, binary.BigEndian, val)
 }
 
+// writeBigIntTag writes an integer that needs more than 64 bits, which only a 128-bit integer read from
+// a binary property list can, as a 16-byte two's complement integer.
+func (p *bplistGenerator) writeBigIntTag(n *big.Int) {
+	var buf [16]byte
+	b := new(big.Int).And(n, maxUint128).Bytes()
+	copy(buf[len(buf)-len(b):], b)
+
+	binary.Write(p.writer, binary.BigEndian, uint8(bpTagInteger|0x4))
+	p.writer.Write(buf[:])
+}
+
+func (p *bplistGenerator) writeUIDTag(u UID) {
+	nbytes := minimumSizeForInt(uint64(u))
+	tag := uint8(bpTagUID | (nbytes - 1))
+
+	binary.Write(p.writer, binary.BigEndian, tag)
+	p.writeSizedInt(uint64(u), nbytes)
+}
+
 func (p *bplistGenerator) writeRealTag(n float64, bits int) {
 	var tag uint8 = bpTagReal | 0x3
 	var val interface{} = n
@@ -239,8 +323,8 @@ func (p *bplistGenerator) writeRealTag(n float64, bits int) {
 
 func (p *bplistGenerator) writeDateTag(t time.Time) {
 	tag := uint8(bpTagDate) | 0x3
-	val := float64(t.In(time.UTC).UnixNano()) / float64(time.Second)
-	val -= 978307200 // Adjust to Apple Epoch
+	// UnixNano overflows outside the years 1678 to 2262, so the seconds and nanoseconds are taken apart.
+	val := float64(t.Unix()-978307200) + float64(t.Nanosecond())/float64(time.Second) // Adjust to Apple Epoch
 
 	binary.Write(p.writer, binary.BigEndian, tag)
 	binary.Write(p.writer, binary.BigEndian, val)
@@ -257,7 +341,7 @@ func (p *bplistGenerator) writeCountedTag(tag uint8, count uint64) {
 	binary.Write(p.writer, binary.BigEndian, marker)
 
 	if count >= 0xF {
-		p.writeIntTag(count)
+		p.writeIntTag(count, false)
 	}
 }
 
@@ -267,8 +351,9 @@ func (p *bplistGenerator) writeDataTag(data []byte) {
 }
 
 func (p *bplistGenerator) writeStringTag(str string) {
+	// As in CoreFoundation, strings that are entirely ASCII are stored one byte per character, and all others as UTF-16.
 	for _, r := range str {
-		if r > 0xFF {
+		if r >= utf8.RuneSelf {
 			utf16Runes := utf16.Encode([]rune(str))
 			p.writeCountedTag(bpTagUTF16String, uint64(len(utf16Runes)))
 			binary.Write(p.writer, binary.BigEndian, utf16Runes)
@@ -304,8 +389,10 @@ func (p *bplistGenerator) writeDictionaryTag(dict *dictionary) {
 	}
 }
 
-func (p *bplistGenerator) writeArrayTag(arr []*plistValue) {
-	p.writeCountedTag(bpTagArray, uint64(len(arr)))
+// writeArrayTag writes an array or a set, according to tag.
+func (p *bplistGenerator) writeArrayTag(tag uint8, arr []*Value) {
+	arr = nonNilValues(arr)
+	p.writeCountedTag(tag, uint64(len(arr)))
 	for _, v := range arr {
 		objIdx, ok := p.indexForPlistValue(v)
 		if !ok {
@@ -327,30 +414,160 @@ func newBplistGenerator(w io.Writer) *bplistGenerator {
 }
 
 type bplistParser struct {
+	depthTracker
+	cancelChecker
+
 	reader        io.ReadSeeker
 	version       int
 	buf           []byte
-	objrefs       map[uint64]*plistValue
+	objrefs       map[uint64]*Value
 	offtable      []uint64
 	trailer       bplistTrailer
 	trailerOffset int64
+
+	// maxObjects, if nonzero, is the largest object count the trailer may declare.
+	maxObjects uint64
+	// lazyData, if set, leaves data objects in the reader as sectionData when it supports io.ReaderAt.
+	lazyData bool
+	// disallowDuplicateKeys makes a key that appears twice in a dictionary an error.
+	disallowDuplicateKeys bool
+	// strictUTF8 makes strings that are not well-formed ASCII or UTF-16 an error.
+	strictUTF8 bool
+
+	// The position of the next object reference in the top-level array, and the number remaining, when streaming.
+	streamOffset    int64
+	streamRemaining uint64
 }
 
-func (p *bplistParser) parseDocument() (pval *plistValue, parseError error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			if _, ok := r.(invalidPlistError); ok {
-				parseError = r.(error)
-			} else {
-				// Wrap all non-invalid-plist errors.
-				parseError = plistParseError{"binary", r.(error)}
-			}
+// recoverError turns a panic raised while parsing into an error; it must be deferred.
+func (p *bplistParser) recoverError(parseError *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); ok {
+			panic(r)
+		}
+		if err, ok := r.(contextError); ok {
+			*parseError = err.error
+			return
+		}
+		switch r.(type) {
+		case invalidPlistError, *UnsupportedVersionError:
+			*parseError = panicError(r)
+		default:
+			// Wrap all non-invalid-plist errors.
+			offset, _ := p.reader.Seek(0, io.SeekCurrent)
+			*parseError = &SyntaxError{Offset: offset, format: "binary", err: panicError(r)}
 		}
-	}()
+	}
+}
+
+func (p *bplistParser) parseDocument() (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
+	p.parseTrailer()
+
+	for _, off := range p.offtable {
+		p.valueAtOffset(off)
+	}
+
+	pval = p.valueAtOffset(p.offtable[p.trailer.TopObject])
+	return
+}
+
+// startArray reads the trailer and offset table, and positions the parser at the first element of the top-level array.
+// Elements are read from the file as they are requested.
+func (p *bplistParser) startArray() (parseError error) {
+	defer p.recoverError(&parseError)
+
+	p.parseTrailer()
+
+	off := int64(p.offtable[p.trailer.TopObject])
+	p.reader.Seek(off, 0)
+	tag := p.read(1)[0]
+	if tag&0xF0 != bpTagArray {
+		panic(errors.New("top-level object is not an array"))
+	}
+
+	cnt := p.countForTag(tag)
+	if cnt > uint64(p.trailerOffset-off)/uint64(p.trailer.ObjectRefSize) {
+		panic(fmt.Errorf("array at %x has more entries (%v) than fit in the file", off, cnt))
+	}
+	p.streamOffset, _ = p.reader.Seek(0, io.SeekCurrent)
+	p.streamRemaining = cnt
+	p.enter()
+	return
+}
+
+// nextElement returns the next element of the top-level array, or io.EOF after the last one.
+func (p *bplistParser) nextElement() (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
+	if p.streamRemaining == 0 {
+		return nil, io.EOF
+	}
+
+	p.reader.Seek(p.streamOffset, 0)
+	idx := p.readSizedInt(int(p.trailer.ObjectRefSize))
+	if idx >= p.trailer.NumObjects {
+		panic(fmt.Errorf("array contains invalid entry index %d (max %d)", idx, p.trailer.NumObjects))
+	}
+	if p.offtable[idx] == p.offtable[p.trailer.TopObject] {
+		panic(fmt.Errorf("array contains self-referential value %x", p.offtable[idx]))
+	}
+	p.streamOffset += int64(p.trailer.ObjectRefSize)
+	p.streamRemaining--
+
+	// Forget the objects decoded for earlier elements, so that memory use doesn't grow with the array.
+	p.objrefs = make(map[uint64]*Value)
+	pval = p.valueAtOffset(p.offtable[idx])
+	return
+}
+
+// parseKey reads the trailer and offset table, then reads the keys of the top-level dictionary
+// until it finds key. Only the value of that key is read.
+func (p *bplistParser) parseKey(key string) (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
 
+	p.parseTrailer()
+
+	off := int64(p.offtable[p.trailer.TopObject])
+	p.reader.Seek(off, 0)
+	tag := p.read(1)[0]
+	if tag&0xF0 != bpTagDictionary {
+		panic(errors.New("top-level object is not a dictionary"))
+	}
+
+	cnt := p.countForTag(tag)
+	if cnt > uint64(p.trailerOffset-off)/(2*uint64(p.trailer.ObjectRefSize)) {
+		panic(fmt.Errorf("dictionary at %x has more entries (%v) than fit in the file", off, cnt))
+	}
+	indices := make([]uint64, cnt*2)
+	for i := range indices {
+		indices[i] = p.readSizedInt(int(p.trailer.ObjectRefSize))
+		if indices[i] >= p.trailer.NumObjects {
+			panic(fmt.Errorf("dictionary contains invalid entry index %d (max %d)", indices[i], p.trailer.NumObjects))
+		}
+		if p.offtable[indices[i]] == uint64(off) {
+			panic(fmt.Errorf("dictionary contains self-referential entry %x (index %d)", off, i))
+		}
+	}
+
+	p.enter()
+	defer p.leave()
+	for i := uint64(0); i < cnt; i++ {
+		kval := p.valueAtOffset(p.offtable[indices[i]])
+		if kval == nil || kval.kind != String {
+			panic(fmt.Errorf("dictionary contains non-string key at index %d", i))
+		}
+		if kval.value.(string) == key {
+			pval = p.valueAtOffset(p.offtable[indices[i+cnt]])
+			return
+		}
+	}
+	return
+}
+
+// parseTrailer checks the header, then reads and validates the trailer and offset table.
+func (p *bplistParser) parseTrailer() {
 	magic := make([]byte, 6)
 	ver := make([]byte, 2)
 	p.reader.Seek(0, 0)
@@ -359,29 +576,58 @@ func (p *bplistParser) parseDocument() (pval *plistValue, parseError error) {
 		panic(invalidPlistError{"binary", errors.New("mismatched magic")})
 	}
 
-	_, err := p.reader.Read(ver)
+	_, err := io.ReadFull(p.reader, ver)
 	if err != nil {
 		panic(err)
 	}
 
+	// The version is two ASCII digits; ParseInt alone would also accept a sign.
+	if ver[0] < '0' || ver[0] > '9' || ver[1] < '0' || ver[1] > '9' {
+		panic(fmt.Errorf("malformed version %q", ver))
+	}
 	p.version = int(mustParseInt(string(ver), 10, 0))
 
-	if p.version > 1 {
-		panic(fmt.Errorf("unexpected version %d", p.version))
+	// Only bplist00 is read; bplist01 and later name formats that it would be wrong to read as bplist00.
+	if p.version != 0 {
+		panic(&UnsupportedVersionError{string(ver)})
 	}
 
-	p.objrefs = make(map[uint64]*plistValue)
+	p.objrefs = make(map[uint64]*Value)
+	end, err := p.reader.Seek(0, 2)
+	if err != nil {
+		panic(err)
+	}
+	if end < 8+32 {
+		panic(fmt.Errorf("binary property list is too short (%v bytes) to hold a trailer", end))
+	}
 	p.trailerOffset, err = p.reader.Seek(-32, 2)
-	if err != nil && err != io.EOF {
+	if err != nil {
 		panic(err)
 	}
 
 	err = binary.Read(p.reader, binary.BigEndian, &p.trailer)
-	if err != nil && err != io.EOF {
+	if err != nil {
 		panic(err)
 	}
 
-	if p.trailer.NumObjects > uint64(math.Pow(2, 8*float64(p.trailer.ObjectRefSize))) {
+	if p.trailer.OffsetIntSize < 1 || p.trailer.OffsetIntSize > 8 || p.trailer.ObjectRefSize < 1 || p.trailer.ObjectRefSize > 8 {
+		panic(fmt.Errorf("binary property list has invalid integer sizes (offsets: %v bytes, object refs: %v bytes)", p.trailer.OffsetIntSize, p.trailer.ObjectRefSize))
+	}
+
+	if p.trailer.OffsetTableOffset < 8 || p.trailer.OffsetTableOffset > uint64(p.trailerOffset) ||
+		p.trailer.NumObjects > (uint64(p.trailerOffset)-p.trailer.OffsetTableOffset)/uint64(p.trailer.OffsetIntSize) {
+		panic(fmt.Errorf("binary property list offset table (%v objects at %v) does not fit before its trailer (at %v)", p.trailer.NumObjects, p.trailer.OffsetTableOffset, p.trailerOffset))
+	}
+
+	if end := p.trailer.OffsetTableOffset + p.trailer.NumObjects*uint64(p.trailer.OffsetIntSize); end != uint64(p.trailerOffset) {
+		panic(fmt.Errorf("binary property list contains %v bytes of unexpected data between its offset table and its trailer", uint64(p.trailerOffset)-end))
+	}
+
+	if p.maxObjects > 0 && p.trailer.NumObjects > p.maxObjects {
+		panic(fmt.Errorf("binary property list contains more objects (%v) than the limit of %v", p.trailer.NumObjects, p.maxObjects))
+	}
+
+	if p.trailer.ObjectRefSize < 8 && p.trailer.NumObjects > uint64(1)<<(8*uint(p.trailer.ObjectRefSize)) {
 		panic(fmt.Errorf("binary property list contains more objects (%v) than its object ref size (%v bytes) can support", p.trailer.NumObjects, p.trailer.ObjectRefSize))
 	}
 
@@ -403,54 +649,75 @@ func (p *bplistParser) parseDocument() (pval *plistValue, parseError error) {
 		}
 		p.offtable[i] = off
 	}
+}
 
-	for _, off := range p.offtable {
-		p.valueAtOffset(off)
+// read reads exactly n bytes into a scratch buffer, which is only valid until the next call to read.
+// Reusing the buffer saves an allocation for every integer, real and string in the document.
+func (p *bplistParser) read(n int) []byte {
+	if cap(p.buf) < n {
+		p.buf = make([]byte, n)
 	}
-
-	pval = p.valueAtOffset(p.offtable[p.trailer.TopObject])
-	return
+	buf := p.buf[:n]
+	if _, err := io.ReadFull(p.reader, buf); err != nil {
+		panic(err)
+	}
+	return buf
 }
 
 func (p *bplistParser) readSizedInt(nbytes int) uint64 {
 	switch nbytes {
 	case 1:
-		var val uint8
-		binary.Read(p.reader, binary.BigEndian, &val)
-		return uint64(val)
+		return uint64(p.read(1)[0])
 	case 2:
-		var val uint16
-		binary.Read(p.reader, binary.BigEndian, &val)
-		return uint64(val)
+		return uint64(binary.BigEndian.Uint16(p.read(2)))
 	case 4:
-		var val uint32
-		binary.Read(p.reader, binary.BigEndian, &val)
-		return uint64(val)
+		return uint64(binary.BigEndian.Uint32(p.read(4)))
 	case 8:
-		var val uint64
-		binary.Read(p.reader, binary.BigEndian, &val)
-		return uint64(val)
+		return binary.BigEndian.Uint64(p.read(8))
 	case 16:
-		var high, low uint64
-		binary.Read(p.reader, binary.BigEndian, &high)
-		binary.Read(p.reader, binary.BigEndian, &low)
-		// TODO: int128 support (!)
-		return uint64(low)
+		// Counts and offsets never need the high half; integer objects are read by parseInteger128.
+		return binary.BigEndian.Uint64(p.read(16)[8:])
 	}
 	panic(errors.New("illegal integer size"))
 }
 
+// parseInteger128 reads a signed 128-bit integer. Values that fit in 64 bits are returned as a signedInt;
+// anything larger is returned as a *big.Int.
+func (p *bplistParser) parseInteger128() *Value {
+	buf := p.read(16)
+	high, low := binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])
+
+	switch {
+	case high == 0:
+		return &Value{Integer, signedInt{low, false}}
+	case high == math.MaxUint64 && int64(low) < 0:
+		return &Value{Integer, signedInt{low, true}}
+	}
+
+	b := new(big.Int).SetUint64(high)
+	b.Lsh(b, 64)
+	b.Or(b, new(big.Int).SetUint64(low))
+	if int64(high) < 0 {
+		// Two's complement: subtract 2^128.
+		b.Sub(b, new(big.Int).Lsh(big.NewInt(1), 128))
+	}
+	return &Value{Integer, b}
+}
+
 func (p *bplistParser) countForTag(tag uint8) uint64 {
 	cnt := uint64(tag & 0x0F)
 	if cnt == 0xF {
-		var intTag uint8
-		binary.Read(p.reader, binary.BigEndian, &intTag)
+		// Longer counts follow the tag as an integer object.
+		intTag := p.read(1)[0]
+		if intTag&0xF0 != bpTagInteger || intTag&0xF > 4 {
+			panic(fmt.Errorf("invalid count for tag %#02x: expected an integer of up to 16 bytes, found tag %#02x", tag, intTag))
+		}
 		cnt = p.readSizedInt(1 << (intTag & 0xF))
 	}
 	return cnt
 }
 
-func (p *bplistParser) valueAtOffset(off uint64) *plistValue {
+func (p *bplistParser) valueAtOffset(off uint64) *Value {
 	if pval, ok := p.objrefs[off]; ok {
 		return pval
 	}
@@ -459,43 +726,44 @@ func (p *bplistParser) valueAtOffset(off uint64) *plistValue {
 	return pval
 }
 
-func (p *bplistParser) parseTagAtOffset(off int64) *plistValue {
-	var tag uint8
+func (p *bplistParser) parseTagAtOffset(off int64) *Value {
+	p.checkCancel()
 	_, err := p.reader.Seek(off, 0)
 	if err != nil {
 		panic(err)
 	}
-	err = binary.Read(p.reader, binary.BigEndian, &tag)
-	if err != nil {
-		panic(err)
-	}
+	tag := p.read(1)[0]
 
 	switch tag & 0xF0 {
 	case bpTagNull:
-		switch tag & 0x0F {
+		switch tag {
+		case bpTagNull:
+			return &Value{CFNull, nil}
 		case bpTagBoolTrue, bpTagBoolFalse:
-			return &plistValue{Boolean, tag == bpTagBoolTrue}
+			return &Value{Boolean, tag == bpTagBoolTrue}
 		}
-		return nil
+		// The fill byte (0x0F) and the reserved markers are not objects.
 	case bpTagInteger:
-		val := p.readSizedInt(1 << (tag & 0xF))
-		return &plistValue{Integer, signedInt{val, false}}
+		nbytes := 1 << (tag & 0xF)
+		if nbytes == 16 {
+			return p.parseInteger128()
+		}
+		val := p.readSizedInt(nbytes)
+		// Integers of fewer than 8 bytes are unsigned; those of 8 bytes are signed.
+		return &Value{Integer, signedInt{val, nbytes == 8 && int64(val) < 0}}
 	case bpTagReal:
 		nbytes := 1 << (tag & 0x0F)
 		switch nbytes {
 		case 4:
-			var val float32
-			binary.Read(p.reader, binary.BigEndian, &val)
-			return &plistValue{Real, sizedFloat{float64(val), 32}}
+			val := math.Float32frombits(binary.BigEndian.Uint32(p.read(4)))
+			return &Value{Real, sizedFloat{float64(val), 32}}
 		case 8:
-			var val float64
-			binary.Read(p.reader, binary.BigEndian, &val)
-			return &plistValue{Real, sizedFloat{float64(val), 64}}
+			val := math.Float64frombits(binary.BigEndian.Uint64(p.read(8)))
+			return &Value{Real, sizedFloat{val, 64}}
 		}
 		panic(errors.New("illegal float size"))
 	case bpTagDate:
-		var val float64
-		binary.Read(p.reader, binary.BigEndian, &val)
+		val := math.Float64frombits(binary.BigEndian.Uint64(p.read(8)))
 
 		// Apple Epoch is 20110101000000Z
 		// Adjust for UNIX Time
@@ -503,39 +771,71 @@ func (p *bplistParser) parseTagAtOffset(off int64) *plistValue {
 
 		sec, fsec := math.Modf(val)
 		time := time.Unix(int64(sec), int64(fsec*float64(time.Second))).In(time.UTC)
-		return &plistValue{Date, time}
+		return &Value{Date, time}
 	case bpTagData:
 		cnt := p.countForTag(tag)
-		if int64(cnt) > p.trailerOffset-int64(off) {
-			panic(fmt.Errorf("data at %x longer than file (%v bytes, max is %v)", off, cnt, p.trailerOffset-int64(off)))
+		if max := uint64(p.trailerOffset - off); cnt > max {
+			panic(fmt.Errorf("data at %x longer than file (%v bytes, max is %v)", off, cnt, max))
+		}
+
+		if ra, ok := p.reader.(io.ReaderAt); ok && p.lazyData {
+			start, err := p.reader.Seek(0, io.SeekCurrent)
+			if err != nil {
+				panic(err)
+			}
+			return &Value{Data, sectionData{ra, start, int64(cnt)}}
 		}
 
+		// The data is handed to the caller, so it can't live in the scratch buffer.
 		bytes := make([]byte, cnt)
-		binary.Read(p.reader, binary.BigEndian, bytes)
-		return &plistValue{Data, bytes}
+		if _, err := io.ReadFull(p.reader, bytes); err != nil {
+			panic(err)
+		}
+		return &Value{Data, bytes}
 	case bpTagASCIIString, bpTagUTF16String:
 		cnt := p.countForTag(tag)
-		if int64(cnt) > p.trailerOffset-int64(off) {
-			panic(fmt.Errorf("string at %x longer than file (%v bytes, max is %v)", off, cnt, p.trailerOffset-int64(off)))
+		max := uint64(p.trailerOffset - off)
+		if tag&0xF0 == bpTagUTF16String {
+			// UTF-16 strings are counted in code units.
+			max /= 2
+		}
+		if cnt > max {
+			panic(fmt.Errorf("string at %x longer than file (%v characters, max is %v)", off, cnt, max))
 		}
 
 		if tag&0xF0 == bpTagASCIIString {
-			bytes := make([]byte, cnt)
-			binary.Read(p.reader, binary.BigEndian, bytes)
-			return &plistValue{String, string(bytes)}
+			str := p.read(int(cnt))
+			if p.strictUTF8 && !utf8.Valid(str) {
+				panic(fmt.Errorf("string at %x is not valid UTF-8", off))
+			}
+			return &Value{String, string(str)}
 		} else {
-			bytes := make([]uint16, cnt)
-			binary.Read(p.reader, binary.BigEndian, bytes)
-			runes := utf16.Decode(bytes)
-			return &plistValue{String, string(runes)}
+			buf := p.read(int(cnt) * 2)
+			units := make([]uint16, cnt)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(buf[2*i:])
+			}
+			if p.strictUTF8 {
+				if i := invalidUTF16Index(units); i >= 0 {
+					panic(fmt.Errorf("string at %x has an unpaired UTF-16 surrogate %#04x at index %d", off, units[i], i))
+				}
+			}
+			runes := utf16.Decode(units)
+			return &Value{String, string(runes)}
 		}
 	case bpTagUID: // Somehow different than int: low half is nbytes - 1 instead of log2(nbytes)
 		val := p.readSizedInt(int(tag&0xF) + 1)
-		return &plistValue{Integer, signedInt{val, false}}
+		return &Value{CFUID, UID(val)}
 	case bpTagDictionary:
+		p.enter()
+		defer p.leave()
+
 		cnt := p.countForTag(tag)
+		if cnt > uint64(p.trailerOffset-off)/(2*uint64(p.trailer.ObjectRefSize)) {
+			panic(fmt.Errorf("dictionary at %x has more entries (%v) than fit in the file", off, cnt))
+		}
 
-		subvalues := make(map[string]*plistValue)
+		dict := newDictionary()
 		indices := make([]uint64, cnt*2)
 		for i := uint64(0); i < cnt*2; i++ {
 			idx := p.readSizedInt(int(p.trailer.ObjectRefSize))
@@ -565,20 +865,32 @@ func (p *bplistParser) parseTagAtOffset(off int64) *plistValue {
 			if !ok {
 				panic(fmt.Errorf("string-type plist value contains non-string at index %d", i))
 			}
-			subvalues[key] = p.valueAtOffset(valueOffset)
+			dict.setParsed(key, p.valueAtOffset(valueOffset), p.disallowDuplicateKeys)
+		}
+
+		return &Value{Dictionary, dict}
+	case bpTagArray, bpTagSet:
+		p.enter()
+		defer p.leave()
+
+		// Sets are stored exactly as arrays are.
+		kind := Array
+		if tag&0xF0 == bpTagSet {
+			kind = CFSet
 		}
 
-		return &plistValue{Dictionary, &dictionary{m: subvalues}}
-	case bpTagArray:
 		cnt := p.countForTag(tag)
+		if cnt > uint64(p.trailerOffset-off)/uint64(p.trailer.ObjectRefSize) {
+			panic(fmt.Errorf("%s at %x has more entries (%v) than fit in the file", plistKindNames[kind], off, cnt))
+		}
 
-		arr := make([]*plistValue, cnt)
+		arr := make([]*Value, cnt)
 		indices := make([]uint64, cnt)
 		for i := uint64(0); i < cnt; i++ {
 			idx := p.readSizedInt(int(p.trailer.ObjectRefSize))
 
 			if idx >= p.trailer.NumObjects {
-				panic(fmt.Errorf("array contains invalid entry index %d (max %d)", idx, p.trailer.NumObjects))
+				panic(fmt.Errorf("%s contains invalid entry index %d (max %d)", plistKindNames[kind], idx, p.trailer.NumObjects))
 			}
 
 			indices[i] = idx
@@ -586,16 +898,32 @@ func (p *bplistParser) parseTagAtOffset(off int64) *plistValue {
 		for i := uint64(0); i < cnt; i++ {
 			valueOffset := p.offtable[indices[i]]
 			if valueOffset == uint64(off) {
-				panic(fmt.Errorf("array contains self-referential value %x (index %d)", off, i))
+				panic(fmt.Errorf("%s contains self-referential value %x (index %d)", plistKindNames[kind], off, i))
 			}
 			arr[i] = p.valueAtOffset(valueOffset)
 		}
 
-		return &plistValue{Array, arr}
+		return &Value{kind, arr}
 	}
 	panic(fmt.Errorf("unexpected atom 0x%2.02x at offset %d", tag, off))
 }
 
 func newBplistParser(r io.ReadSeeker) *bplistParser {
-	return &bplistParser{reader: r}
+	return &bplistParser{reader: r, depthTracker: depthTracker{maxDepth: defaultMaxDepth}}
+}
+
+// invalidUTF16Index returns the index of the first surrogate in units that is not part of a pair, or -1 if there is none.
+func invalidUTF16Index(units []uint16) int {
+	for i := 0; i < len(units); i++ {
+		switch u := units[i]; {
+		case u >= 0xD800 && u < 0xDC00:
+			if i+1 == len(units) || units[i+1] < 0xDC00 || units[i+1] >= 0xE000 {
+				return i
+			}
+			i++
+		case u >= 0xDC00 && u < 0xE000:
+			return i
+		}
+	}
+	return -1
 }
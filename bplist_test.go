@@ -2,8 +2,16 @@ package plist
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func BenchmarkBplistGenerate(b *testing.B) {
@@ -28,11 +36,151 @@ func BenchmarkBplistParse(b *testing.B) {
 func TestBplistInt128(t *testing.T) {
 	bplist := []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x19}
 	expected := uint64(0x090a0b0c0d0e0f10)
-	buf := bytes.NewReader(bplist)
-	d := newBplistParser(buf)
-	pval, _ := d.parseDocument()
-	if pval.kind != Integer || pval.value.(signedInt).value != expected {
-		t.Error("Expected", expected, "received", pval.value)
+	var i uint64
+	if _, err := Unmarshal(bplist, &i); err != nil || i != expected {
+		t.Error("Expected", expected, "received", i, err)
+	}
+}
+
+// int128Bplist returns a binary property list containing a single 128-bit integer.
+func int128Bplist(high, low uint64) []byte {
+	bplist := []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0x14}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], high)
+	bplist = append(bplist, b[:]...)
+	binary.BigEndian.PutUint64(b[:], low)
+	bplist = append(bplist, b[:]...)
+	return append(bplist, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x19)
+}
+
+func TestBplistBigIntegers(t *testing.T) {
+	large, _ := new(big.Int).SetString("0x8000000000000000ffffffffffffffff", 0)
+	large.Sub(large, new(big.Int).Lsh(big.NewInt(1), 128))
+	tests := []struct {
+		high, low uint64
+		truncated interface{}
+		expected  interface{}
+	}{
+		{0, math.MaxUint64, uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{math.MaxUint64, math.MaxUint64 - 1, int64(-2), int64(-2)},
+		{1, 2, uint64(2), new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(2))},
+		{0x8000000000000000, math.MaxUint64, uint64(math.MaxUint64), large},
+	}
+
+	for _, test := range tests {
+		bplist := int128Bplist(test.high, test.low)
+
+		var truncated interface{}
+		if _, err := Unmarshal(bplist, &truncated); err != nil || !reflect.DeepEqual(truncated, test.truncated) {
+			t.Errorf("%x%016x: expected %v, received %v (%v)", test.high, test.low, test.truncated, truncated, err)
+		}
+
+		var full interface{}
+		if err := NewDecoder(bytes.NewReader(bplist)).BigIntegers(true).Decode(&full); err != nil || !reflect.DeepEqual(full, test.expected) {
+			t.Errorf("%x%016x: expected %v, received %v (%v)", test.high, test.low, test.expected, full, err)
+		}
+
+		var b big.Int
+		if _, err := Unmarshal(bplist, &b); err != nil || b.String() != fmt.Sprint(test.expected) {
+			t.Errorf("%x%016x: expected big.Int %v, received %v (%v)", test.high, test.low, test.expected, &b, err)
+		}
+	}
+}
+
+func TestBplistBigIntegerOverflow(t *testing.T) {
+	bplist := int128Bplist(1, 2)
+
+	var i uint64
+	if err := NewDecoder(bytes.NewReader(bplist)).BigIntegers(true).Decode(&i); err == nil {
+		t.Error("Expected an overflow error decoding into uint64, received", i)
+	}
+
+	var small int8
+	data, _ := Marshal(300, BinaryFormat)
+	if err := NewDecoder(bytes.NewReader(data)).BigIntegers(true).Decode(&small); err == nil {
+		t.Error("Expected an overflow error decoding 300 into int8, received", small)
+	}
+
+	var unsigned uint
+	data, _ = Marshal(-1, XMLFormat)
+	if err := NewDecoder(bytes.NewReader(data)).BigIntegers(true).Decode(&unsigned); err == nil {
+		t.Error("Expected an overflow error decoding -1 into uint, received", unsigned)
+	}
+}
+
+func TestBplistBigIntegerRoundTrip(t *testing.T) {
+	tests := []struct {
+		high, low uint64
+		decimal   string
+	}{
+		{1, 2, "18446744073709551618"},
+		{0x8000000000000000, math.MaxUint64, "-170141183460469231713240559642174554113"},
+	}
+
+	for _, test := range tests {
+		bplist := int128Bplist(test.high, test.low)
+		pval, err := NewDecoder(bytes.NewReader(bplist)).DecodeValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := NewEncoderForFormat(&buf, BinaryFormat).EncodeValue(pval); err != nil {
+			t.Fatalf("%x%016x: %v", test.high, test.low, err)
+		}
+		if !bytes.Equal(buf.Bytes(), bplist) {
+			t.Errorf("%x%016x: expected the 16-byte integer to be written back as it was read\n% x\n% x", test.high, test.low, bplist, buf.Bytes())
+		}
+
+		doc, err := Load(bplist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := doc.Bytes(); err != nil || !bytes.Equal(data, bplist) {
+			t.Errorf("%x%016x: expected the document to be written back as it was read, received % x (%v)", test.high, test.low, data, err)
+		}
+
+		for _, format := range []int{XMLFormat, OpenStepFormat, GNUStepFormat} {
+			data, err := Marshal(pval, format)
+			if err != nil {
+				t.Errorf("%x%016x in %s: %v", test.high, test.low, FormatNames[format], err)
+				continue
+			}
+			if !bytes.Contains(data, []byte(test.decimal)) {
+				t.Errorf("%x%016x in %s: expected %s, received %s", test.high, test.low, FormatNames[format], test.decimal, data)
+			}
+		}
+	}
+}
+
+func TestBplistDistantDates(t *testing.T) {
+	dates := []time.Time{
+		time.Date(1600, time.March, 4, 5, 6, 7, 500000000, time.UTC),
+		time.Date(3000, time.October, 11, 12, 13, 14, 0, time.UTC),
+	}
+	data, err := Marshal(dates, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []time.Time
+	if _, err := Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, dates) {
+		t.Errorf("Expected %v, received %v (%v)", dates, decoded, err)
+	}
+
+	// Dates exactly 2^64 nanoseconds apart have the same UnixNano, but are still different objects.
+	later := dates[0]
+	for i := 0; i < 4; i++ {
+		later = later.Add(1 << 62)
+	}
+	dates = []time.Time{dates[0], later}
+	data, err = Marshal(dates, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded = nil
+	// Reals this far from the reference date only hold the time to the microsecond.
+	if _, err := Unmarshal(data, &decoded); err != nil || len(decoded) != 2 || !decoded[0].Equal(dates[0]) || !decoded[1].Round(time.Microsecond).Equal(later.Round(time.Microsecond)) {
+		t.Errorf("Expected %v, received %v (%v)", dates, decoded, err)
 	}
 }
 
@@ -58,3 +206,591 @@ func TestVariousIllegalBplists(t *testing.T) {
 		}
 	}
 }
+
+func TestBplistDeduplication(t *testing.T) {
+	repeated := make([]string, 16)
+	distinct := make([]string, 16)
+	for i := range repeated {
+		repeated[i] = "Hello, World!"
+		distinct[i] = fmt.Sprintf("Hello, World%x", i)
+	}
+
+	repeatedPlist, _ := Marshal(repeated, BinaryFormat)
+	distinctPlist, _ := Marshal(distinct, BinaryFormat)
+	if len(repeatedPlist) >= len(distinctPlist) {
+		t.Errorf("Expected repeated strings (%d bytes) to encode smaller than distinct strings (%d bytes)", len(repeatedPlist), len(distinctPlist))
+	}
+
+	// An array object plus one string object.
+	d := newBplistParser(bytes.NewReader(repeatedPlist))
+	d.parseDocument()
+	if d.trailer.NumObjects != 2 {
+		t.Errorf("Expected 2 objects, received %d", d.trailer.NumObjects)
+	}
+}
+
+func TestBplistDeduplicationIsValueBased(t *testing.T) {
+	// "plumless" and "buckeroo" share a CRC32 checksum.
+	data := []interface{}{
+		[]byte("plumless"),
+		[]byte("buckeroo"),
+		math.NaN(),
+		math.NaN(),
+		"plumless",
+	}
+
+	bplist, err := Marshal(data, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []interface{}
+	if _, err := Unmarshal(bplist, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(data) || !bytes.Equal(decoded[0].([]byte), data[0].([]byte)) || !bytes.Equal(decoded[1].([]byte), data[1].([]byte)) || decoded[4] != "plumless" {
+		t.Errorf("Expected %v, received %v", data, decoded)
+	}
+	if f, ok := decoded[3].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("Expected NaN, received %v", decoded[3])
+	}
+}
+
+func TestBplistObjectCountLimits(t *testing.T) {
+	data, err := Marshal([]string{"a", "b", "c"}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v interface{}
+	if err := NewDecoder(bytes.NewReader(data)).MaxObjects(4).Decode(&v); err != nil {
+		t.Errorf("Expected 4 objects to be accepted, received %v", err)
+	}
+
+	err = NewDecoder(bytes.NewReader(data)).MaxObjects(3).Decode(&v)
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Expected a *SyntaxError for 4 objects with a limit of 3, received %v", err)
+	}
+
+	for _, numObjects := range []uint64{5, 1 << 32, math.MaxUint64} {
+		lying := append([]byte(nil), data...)
+		binary.BigEndian.PutUint64(lying[len(lying)-24:], numObjects)
+
+		err := NewDecoder(bytes.NewReader(lying)).Decode(&v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%d objects: expected a *SyntaxError, received %v", numObjects, err)
+		}
+	}
+
+	// An array claiming 2^32-1 entries in a file far too small to hold them.
+	hugeArray := []byte("bplist00\xaf\x12\xff\xff\xff\xff\x08")
+	trailer := make([]byte, 32)
+	trailer[6], trailer[7] = 1, 1
+	binary.BigEndian.PutUint64(trailer[8:], 1)
+	binary.BigEndian.PutUint64(trailer[24:], 14)
+	err = NewDecoder(bytes.NewReader(append(hugeArray, trailer...))).Decode(&v)
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("Oversized array: expected a *SyntaxError, received %v", err)
+	}
+}
+
+var smallBplistValue = map[string]interface{}{
+	"name":  "value",
+	"utf16": "héllo, wörld",
+	"n":     uint64(42),
+	"pi":    3.14,
+	"list":  []interface{}{uint64(1), "two", float32(3), true, []byte("data"), []byte("more data")},
+	"date":  time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC),
+}
+
+func BenchmarkBplistDecodeSmall(b *testing.B) {
+	data, err := Marshal(smallBplistValue, BinaryFormat)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v interface{}
+		if _, err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBplistScratchBuffer(t *testing.T) {
+	data, err := Marshal(smallBplistValue, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if _, err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(smallBplistValue, decoded) {
+		t.Errorf("Expected %#v, received %#v", smallBplistValue, decoded)
+	}
+
+	// Data must not share memory with the parser's scratch buffer, or with other data.
+	list := decoded["list"].([]interface{})
+	first, second := list[4].([]byte), list[5].([]byte)
+	first[0] = 'D'
+	if string(second) != "more data" {
+		t.Errorf("Expected data objects to be independent, received %q", second)
+	}
+
+	p := newBplistParser(bytes.NewReader(make([]byte, 8)))
+	if allocs := testing.AllocsPerRun(100, func() {
+		p.reader.Seek(0, 0)
+		p.readSizedInt(8)
+	}); allocs != 0 {
+		t.Errorf("Expected reading an integer not to allocate, %v allocations", allocs)
+	}
+}
+
+func TestBplistIntegerSignedness(t *testing.T) {
+	for _, value := range []uint64{math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64} {
+		data, err := Marshal(value, BinaryFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var u uint64
+		if _, err := Unmarshal(data, &u); err != nil || u != value {
+			t.Errorf("%d: expected a faithful round trip, received %d (%v)", value, u, err)
+		}
+
+		var i interface{}
+		if _, err := Unmarshal(data, &i); err != nil || i != value {
+			t.Errorf("%d: expected uint64 in an interface, received %#v (%v)", value, i, err)
+		}
+
+		var s int64
+		_, err = Unmarshal(data, &s)
+		if value > math.MaxInt64 {
+			if err == nil {
+				t.Errorf("%d: expected an overflow error decoding into int64, received %d", value, s)
+			}
+			if err := NewDecoder(bytes.NewReader(data)).Lax(true).Decode(&s); err != nil || s != int64(value) {
+				t.Errorf("%d: expected truncation in lax mode, received %d (%v)", value, s, err)
+			}
+		} else if err != nil || s != int64(value) {
+			t.Errorf("%d: expected %d, received %d (%v)", value, value, s, err)
+		}
+	}
+
+	for _, value := range []int64{-1, math.MinInt64} {
+		data, err := Marshal(value, BinaryFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var i interface{}
+		if _, err := Unmarshal(data, &i); err != nil || i != value {
+			t.Errorf("%d: expected int64 in an interface, received %#v (%v)", value, i, err)
+		}
+
+		var u uint64
+		if _, err := Unmarshal(data, &u); err == nil {
+			t.Errorf("%d: expected an overflow error decoding into uint64, received %d", value, u)
+		}
+	}
+}
+
+func TestBplistTrailerValidation(t *testing.T) {
+	good, err := Marshal([]string{"a", "b"}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v []string
+	if _, err := Unmarshal(good, &v); err != nil || !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Fatalf("Expected [a b], received %v (%v)", v, err)
+	}
+
+	corrupt := func(f func(data, trailer []byte)) []byte {
+		data := append([]byte(nil), good...)
+		f(data, data[len(data)-32:])
+		return data
+	}
+	variants := map[string][]byte{
+		"offset size 0":            corrupt(func(_, tr []byte) { tr[6] = 0 }),
+		"offset size 9":            corrupt(func(_, tr []byte) { tr[6] = 9 }),
+		"object ref size 0":        corrupt(func(_, tr []byte) { tr[7] = 0 }),
+		"object ref size 16":       corrupt(func(_, tr []byte) { tr[7] = 16 }),
+		"no objects":               corrupt(func(_, tr []byte) { binary.BigEndian.PutUint64(tr[8:], 0) }),
+		"top object out of range":  corrupt(func(_, tr []byte) { binary.BigEndian.PutUint64(tr[16:], 3) }),
+		"offset table in header":   corrupt(func(_, tr []byte) { binary.BigEndian.PutUint64(tr[24:], 4) }),
+		"offset table past end":    corrupt(func(d, tr []byte) { binary.BigEndian.PutUint64(tr[24:], uint64(len(d))) }),
+		"offset table in trailer":  corrupt(func(d, tr []byte) { binary.BigEndian.PutUint64(tr[24:], uint64(len(d)-33)) }),
+		"version -0":               corrupt(func(d, _ []byte) { d[6] = '-' }),
+		"version 0x":               corrupt(func(d, _ []byte) { d[7] = 'x' }),
+		"truncated before trailer": []byte("bplist00\xa0"),
+		"truncated trailer":        good[:len(good)-5],
+	}
+
+	for name, data := range variants {
+		_, err := Unmarshal(data, &v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected a *SyntaxError, received %v", name, err)
+		}
+	}
+}
+
+func TestBplistNull(t *testing.T) {
+	data, err := Marshal(map[string]interface{}{
+		"array": []interface{}{"a", Null{}, Null{}},
+		"null":  Null{},
+	}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data[8:len(data)-32], []byte{bpTagNull}) {
+		t.Errorf("Expected a null object in %x", data)
+	}
+
+	var generic map[string]interface{}
+	if _, err := Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"array": []interface{}{"a", Null{}, Null{}},
+		"null":  Null{},
+	}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Expected %v, received %v", expected, generic)
+	}
+
+	typed := struct {
+		Array []*string `plist:"array"`
+		Null  []int     `plist:"null"`
+	}{Null: []int{1}}
+	if _, err := Unmarshal(data, &typed); err != nil {
+		t.Fatal(err)
+	}
+	if len(typed.Array) != 3 || typed.Array[0] == nil || *typed.Array[0] != "a" || typed.Array[1] != nil || typed.Array[2] != nil || typed.Null != nil {
+		t.Errorf("Expected null objects to decode as nil, received %v", typed)
+	}
+
+	var s = "unchanged"
+	if _, err := Unmarshal([]byte("bplist00\x00\x08\x00\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x09"), &s); err != nil || s != "unchanged" {
+		t.Errorf("Expected a top-level null object to leave a string unchanged, received %q (%v)", s, err)
+	}
+
+	// Formats without null objects leave them out.
+	for _, format := range []int{XMLFormat, OpenStepFormat, GNUStepFormat} {
+		text, err := Marshal(generic, format)
+		if err != nil {
+			t.Errorf("%s: %v", FormatNames[format], err)
+			continue
+		}
+		var roundTripped map[string]interface{}
+		if _, err := Unmarshal(text, &roundTripped); err != nil {
+			t.Errorf("%s: %v", FormatNames[format], err)
+			continue
+		}
+		if array, _ := roundTripped["array"].([]interface{}); len(array) != 1 || len(roundTripped) != 1 {
+			t.Errorf("%s: expected the null objects to be omitted, received %v", FormatNames[format], roundTripped)
+		}
+	}
+
+	// Only 0x00 is null: the fill byte and the other reserved markers are errors.
+	var v interface{}
+	if _, err := Unmarshal(singleObjectBplist([]byte{bpTagNull}), &v); err != nil || v != (Null{}) {
+		t.Errorf("Expected a top-level null object, received %#v (%v)", v, err)
+	}
+	for _, marker := range []byte{0x01, 0x04, 0x07, 0x0A, 0x0C, 0x0E, 0x0F} {
+		_, err := Unmarshal(singleObjectBplist([]byte{marker}), &v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%#02x: expected a *SyntaxError, received %v", marker, err)
+		}
+	}
+}
+
+func TestBplistTrailingData(t *testing.T) {
+	good, err := Marshal([]string{"a", "b"}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer := len(good) - 32
+
+	variants := map[string][]byte{
+		"appended garbage":        append(append([]byte(nil), good...), "garbage"...),
+		"appended property list":  append(append([]byte(nil), good...), good...),
+		"data before the trailer": append(append(append([]byte(nil), good[:trailer]...), "garbage"...), good[trailer:]...),
+	}
+	for name, data := range variants {
+		var v []string
+		_, err := Unmarshal(data, &v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected a *SyntaxError, received %v (decoded %v)", name, err, v)
+		}
+	}
+}
+
+func TestBplistSet(t *testing.T) {
+	// A set of two strings and an integer, in a dictionary; CoreFoundation writes sets with the 0xC0 marker.
+	doc := []byte("bplist00" +
+		"\xd1\x01\x02" + // 8: {1: 2}
+		"\x53set" + // 11: "set"
+		"\xc3\x03\x04\x05" + // 15: set {3, 4, 5}
+		"\x51a" + // 19: "a"
+		"\x51b" + // 21: "b"
+		"\x10\x07" + // 23: 7
+		"\x08\x0b\x0f\x13\x15\x17" + // 25: offset table
+		"\x00\x00\x00\x00\x00\x00\x01\x01" +
+		"\x00\x00\x00\x00\x00\x00\x00\x06" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\x00\x19")
+
+	var generic map[string]interface{}
+	if _, err := Unmarshal(doc, &generic); err != nil {
+		t.Fatal(err)
+	}
+	expected := Set{"a", "b", uint64(7)}
+	if !reflect.DeepEqual(generic["set"], expected) {
+		t.Errorf("Expected %#v, received %#v", expected, generic["set"])
+	}
+
+	var typed struct {
+		Set []interface{} `plist:"set"`
+	}
+	if _, err := Unmarshal(doc, &typed); err != nil || !reflect.DeepEqual(typed.Set, []interface{}(expected)) {
+		t.Errorf("Expected a set to decode into a slice, received %#v (%v)", typed.Set, err)
+	}
+
+	// A Set encodes back into a set object.
+	data, err := Marshal(generic, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte{0xc3}) {
+		t.Errorf("Expected a set object in %x", data)
+	}
+	var roundTripped map[string]interface{}
+	if _, err := Unmarshal(data, &roundTripped); err != nil || !reflect.DeepEqual(roundTripped, generic) {
+		t.Errorf("Expected %#v, received %#v (%v)", generic, roundTripped, err)
+	}
+
+	// Other formats store sets as arrays.
+	for _, format := range []int{XMLFormat, GNUStepFormat} {
+		data, err := Marshal(Set{"a", 1}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded interface{}
+		if _, err := Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, []interface{}{"a", uint64(1)}) {
+			t.Errorf("%s: expected a set to decode as an array, received %#v (%v)", FormatNames[format], decoded, err)
+		}
+	}
+
+	if !Equal(Set{"a", "b"}, Set{"b", "a"}) || Equal(Set{"a", "b"}, []string{"a", "b"}) || Equal(Set{"a", "a"}, Set{"a", "b"}) {
+		t.Error("Expected sets to be compared without regard to order, and not to equal arrays")
+	}
+}
+
+func TestBinaryInfo(t *testing.T) {
+	doc := map[string]interface{}{"A": "a", "B": []interface{}{uint64(1), uint64(2)}}
+	data, err := Marshal(doc, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	if _, err := dec.BinaryInfo(); err == nil {
+		t.Error("Expected an error before anything was decoded")
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	info, err := dec.BinaryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The root dictionary, its keys "A" and "B", the string "a", the array and its two integers.
+	if info.NumObjects != 7 || len(info.Offsets) != 7 {
+		t.Errorf("Expected 7 objects, received %d with %d offsets", info.NumObjects, len(info.Offsets))
+	}
+	if info.Version != 0 || info.OffsetIntSize != 1 || info.ObjectRefSize != 1 {
+		t.Errorf("Unexpected version or sizes: %+v", info)
+	}
+	if info.OffsetTableOffset != uint64(len(data)-32-int(info.NumObjects)) {
+		t.Errorf("Expected the offset table just before the trailer, received %d", info.OffsetTableOffset)
+	}
+	if root := info.RootOffset(); data[root] != bpTagDictionary|2 {
+		t.Errorf("Expected a two-entry dictionary at offset %d, received %#x", root, data[root])
+	}
+	for i, off := range info.Offsets {
+		if off < 8 || off >= info.OffsetTableOffset {
+			t.Errorf("Expected object %d to lie between the header and the offset table, received %d", i, off)
+		}
+	}
+
+	dec.Reset(strings.NewReader(`<plist><string>a</string></plist>`))
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.BinaryInfo(); err == nil {
+		t.Error("Expected an error after decoding an XML property list")
+	}
+}
+
+func TestBplistStrictUTF8(t *testing.T) {
+	valid, err := Marshal([]string{"€", "a"}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replace the UTF-16 code unit for € with an unpaired high surrogate, and the ASCII a with a stray continuation byte.
+	surrogate := bytes.Replace(valid, []byte{0x61, 0x20, 0xAC}, []byte{0x61, 0xD8, 0x00}, 1)
+	ascii := bytes.Replace(valid, []byte{0x51, 'a'}, []byte{0x51, 0x80}, 1)
+	if bytes.Equal(surrogate, valid) || bytes.Equal(ascii, valid) {
+		t.Fatal("Failed to corrupt the strings")
+	}
+
+	for _, data := range [][]byte{surrogate, ascii} {
+		var lenient []string
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&lenient); err != nil {
+			t.Errorf("Expected lenient decoding to succeed, received %v", err)
+		}
+
+		var strict []string
+		err := NewDecoder(bytes.NewReader(data)).StrictUTF8(true).Decode(&strict)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Expected a *SyntaxError, received %#v", err)
+		} else if !strings.Contains(err.Error(), "string at") {
+			t.Errorf("Expected the error to name the string's offset, received %v", err)
+		}
+	}
+
+	var decoded []string
+	if err := NewDecoder(bytes.NewReader(valid)).StrictUTF8(true).Decode(&decoded); err != nil || !reflect.DeepEqual(decoded, []string{"€", "a"}) {
+		t.Errorf("Expected valid strings to decode, received %q (%v)", decoded, err)
+	}
+
+	// Strictness can be turned off again.
+	var lenient []string
+	if err := NewDecoder(bytes.NewReader(surrogate)).StrictUTF8(true).StrictUTF8(false).Decode(&lenient); err != nil {
+		t.Errorf("Expected decoding to succeed once StrictUTF8 is turned off, received %v", err)
+	}
+}
+
+// singleObjectBplist returns a binary property list whose only object is the encoded object obj.
+func singleObjectBplist(obj []byte) []byte {
+	bplist := append([]byte("bplist00"), obj...)
+	bplist = append(bplist, 0x08, 0, 0, 0, 0, 0, 0, 0x01, 0x01)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], 1)
+	bplist = append(bplist, b[:]...)
+	binary.BigEndian.PutUint64(b[:], 0)
+	bplist = append(bplist, b[:]...)
+	binary.BigEndian.PutUint64(b[:], uint64(8+len(obj)))
+	return append(bplist, b[:]...)
+}
+
+func TestBplistDataCountOverflow(t *testing.T) {
+	// A data object whose 8-byte count has the top bit set, which is negative as an int64.
+	bplist := singleObjectBplist([]byte{0x4F, 0x13, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	var data []byte
+	if _, err := Unmarshal(bplist, &data); err == nil {
+		t.Errorf("Expected an error decoding the data into []byte, received %d bytes", len(data))
+	}
+	var r DataReader
+	if _, err := Unmarshal(bplist, &r); err == nil {
+		t.Error("Expected an error decoding the data into a DataReader")
+	}
+}
+
+func TestBplistStringObjects(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	utf16Long := strings.Repeat("é€", 10)
+	var utf16LongUnits []byte
+	for _, r := range utf16Long {
+		utf16LongUnits = append(utf16LongUnits, byte(r>>8), byte(r))
+	}
+
+	tests := []struct {
+		name     string
+		obj      []byte
+		expected string
+	}{
+		{"short ASCII", []byte("\x55hello"), "hello"},
+		{"long ASCII", append([]byte{0x5F, 0x11, 0x01, 0x2C}, long...), long},
+		{"ASCII with a 4-byte count", []byte("\x5F\x12\x00\x00\x00\x05hello"), "hello"},
+		{"UTF-16 surrogate pair", []byte{0x62, 0xD8, 0x3D, 0xDE, 0x00}, "😀"},
+		{"long UTF-16", append([]byte{0x6F, 0x10, 0x14}, utf16LongUnits...), utf16Long},
+		{"UTF-16 with a 2-byte count", append([]byte{0x6F, 0x11, 0x00, 0x14}, utf16LongUnits...), utf16Long},
+	}
+	for _, test := range tests {
+		var s string
+		if _, err := Unmarshal(singleObjectBplist(test.obj), &s); err != nil || s != test.expected {
+			t.Errorf("%s: expected %q, received %q (%v)", test.name, test.expected, s, err)
+		}
+	}
+
+	// The extended count must be an integer object, and must fit in the file.
+	for _, obj := range [][]byte{
+		[]byte("\x5F\x25hello"),
+		[]byte("\x5F\x15\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05hello"),
+		[]byte("\x6F\x10\x06hello"),
+	} {
+		var s string
+		if _, err := Unmarshal(singleObjectBplist(obj), &s); err == nil {
+			t.Errorf("%q: expected an error, received %q", obj, s)
+		}
+	}
+}
+
+func TestBplistStringEncoding(t *testing.T) {
+	tests := []struct {
+		str    string
+		marker []byte
+	}{
+		{"hello", []byte{0x55}},
+		{strings.Repeat("x", 20), []byte{0x5F, 0x10, 0x14}},
+		{"café", []byte{0x64}},
+		{"😀", []byte{0x62, 0xD8, 0x3D, 0xDE, 0x00}},
+		{strings.Repeat("😀", 10), []byte{0x6F, 0x10, 0x14}},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.str, BinaryFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The only object immediately follows the header.
+		if !bytes.HasPrefix(data[8:], test.marker) {
+			t.Errorf("%q: expected the object to start % x, received % x", test.str, test.marker, data[8:8+len(test.marker)])
+		}
+
+		var s string
+		if _, err := Unmarshal(data, &s); err != nil || s != test.str {
+			t.Errorf("%q: expected the string to survive encoding, received %q (%v)", test.str, s, err)
+		}
+	}
+}
+
+func TestBplistUnsupportedVersion(t *testing.T) {
+	good, err := Marshal([]string{"a", "b"}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []string{"01", "02", "15", "16"} {
+		data := append([]byte("bplist"+version), good[8:]...)
+		var v []string
+		_, err := Unmarshal(data, &v)
+		if verr, ok := err.(*UnsupportedVersionError); !ok || verr.Version != version {
+			t.Errorf("%s: expected an *UnsupportedVersionError, received %v", version, err)
+		}
+	}
+
+	var v []string
+	if _, err := Unmarshal(good, &v); err != nil || !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("Expected bplist00 to decode, received %v (%v)", v, err)
+	}
+}
@@ -0,0 +1,52 @@
+package plist
+
+import "math/big"
+
+// Clone returns a deep copy of v, which may be any of the types that decoding into an interface{} produces.
+// Dictionaries (map[string]interface{}), arrays ([]interface{}), sets, data ([]byte), big integers and OrderedDicts are copied
+// along with everything they contain, so that the copy can be modified without affecting v. Other values, such as
+// strings, numbers, dates and UIDs, are returned unchanged, as are values of types this package does not produce.
+func Clone(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, subv := range v {
+			out[k] = Clone(subv)
+		}
+		return out
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		out := make([]interface{}, len(v))
+		for i, subv := range v {
+			out[i] = Clone(subv)
+		}
+		return out
+	case Set:
+		if v == nil {
+			return v
+		}
+		return Set(Clone([]interface{}(v)).([]interface{}))
+	case []byte:
+		if v == nil {
+			return v
+		}
+		return append(make([]byte, 0, len(v)), v...)
+	case *big.Int:
+		if v == nil {
+			return v
+		}
+		return new(big.Int).Set(v)
+	case OrderedDict:
+		out := OrderedDict{Values: Clone(v.Values).(map[string]interface{})}
+		if v.Keys != nil {
+			out.Keys = append(make([]string, 0, len(v.Keys)), v.Keys...)
+		}
+		return out
+	}
+	return v
+}
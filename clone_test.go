@@ -0,0 +1,58 @@
+package plist
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClone(t *testing.T) {
+	var original map[string]interface{}
+	_, err := Unmarshal([]byte(xmlPreamble+`<plist><dict>
+		<key>name</key><string>a</string>
+		<key>data</key><data>AQID</data>
+		<key>when</key><date>2020-01-02T03:04:05Z</date>
+		<key>list</key><array><integer>1</integer><array><data>BAU=</data></array><dict><key>x</key><true/></dict></array>
+		<key>nested</key><dict><key>inner</key><dict><key>n</key><real>1.5</real></dict></dict>
+	</dict></plist>`), &original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original["big"] = big.NewInt(7)
+	original["ordered"] = OrderedDict{Keys: []string{"k"}, Values: map[string]interface{}{"k": []interface{}{"v"}}}
+
+	snapshot := Clone(original)
+	clone := Clone(original).(map[string]interface{})
+	if !reflect.DeepEqual(clone, original) {
+		t.Fatalf("Expected the clone to equal the original, received %#v", clone)
+	}
+
+	// Mutate every level of the clone.
+	clone["name"] = "changed"
+	clone["data"].([]byte)[0] = 0xFF
+	list := clone["list"].([]interface{})
+	list[0] = "changed"
+	list[1].([]interface{})[0].([]byte)[0] = 0xFF
+	list[1].([]interface{})[0] = nil
+	list[2].(map[string]interface{})["x"] = false
+	clone["nested"].(map[string]interface{})["inner"].(map[string]interface{})["n"] = 2.5
+	clone["big"].(*big.Int).SetInt64(8)
+	ordered := clone["ordered"].(OrderedDict)
+	ordered.Keys[0] = "changed"
+	ordered.Values["k"].([]interface{})[0] = "changed"
+	delete(clone, "when")
+
+	if !reflect.DeepEqual(original, snapshot) {
+		t.Errorf("Expected the original to be unchanged, received %#v", original)
+	}
+	if original["data"].([]byte)[0] != 1 || original["when"].(time.Time).Year() != 2020 {
+		t.Errorf("Expected the original's data and date to be unchanged, received %#v", original)
+	}
+
+	for _, v := range []interface{}{nil, "s", uint64(1), int64(-1), 1.5, true, UID(3), Null{}, ([]byte)(nil), map[string]interface{}(nil)} {
+		if c := Clone(v); !reflect.DeepEqual(c, v) {
+			t.Errorf("Expected %#v to be returned unchanged, received %#v", v, c)
+		}
+	}
+}
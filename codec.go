@@ -0,0 +1,80 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// typeCodec holds the functions registered for a type with RegisterType.
+type typeCodec struct {
+	enc func(interface{}) (interface{}, error)
+	dec func(interface{}) (interface{}, error)
+}
+
+var (
+	codecMap  = make(map[reflect.Type]typeCodec)
+	codecLock sync.RWMutex
+)
+
+// RegisterType teaches Marshal and Unmarshal how to handle values of type t, for types that cannot implement
+// Marshaler and Unmarshaler themselves, such as those from other packages. Registered functions take precedence
+// over all of the interfaces a type implements.
+//
+// When encoding a value of type t, Marshal calls enc with the value and encodes the value enc returns in its place,
+// as it would for MarshalPlist. When decoding into a value of type t, Unmarshal decodes the property list object as it
+// would into an interface{}, calls dec with the result, and stores the value dec returns, which must be of type t or *t.
+// A nil dec result stores the zero value. Errors returned by either function are returned by Marshal or Unmarshal.
+//
+// Either function may be nil, in which case values of type t are encoded or decoded as usual. Registering t again
+// replaces its functions, and registering nil functions for both removes them. Pointers to t are followed as usual
+// before the registered functions are consulted, so t should not be a pointer type.
+// RegisterType is safe to call from multiple goroutines.
+//
+// Decoder.RegisterType, by contrast, chooses the concrete types that interface values are decoded into.
+func RegisterType(t reflect.Type, enc func(interface{}) (interface{}, error), dec func(interface{}) (interface{}, error)) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if enc == nil && dec == nil {
+		delete(codecMap, t)
+		return
+	}
+	codecMap[t] = typeCodec{enc, dec}
+}
+
+// registeredCodec returns the functions registered for t, if any.
+func registeredCodec(t reflect.Type) (typeCodec, bool) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	codec, ok := codecMap[t]
+	return codec, ok
+}
+
+// marshalCodec encodes val in the place of the value the function registered for its type returns.
+func (p *Encoder) marshalCodec(val reflect.Value, enc func(interface{}) (interface{}, error)) *Value {
+	value, err := enc(val.Interface())
+	if err != nil {
+		panic(err)
+	}
+	return p.marshal(reflect.ValueOf(value))
+}
+
+// unmarshalCodec stores in val the value the function registered for its type builds from pval.
+func (p *Decoder) unmarshalCodec(pval *Value, val reflect.Value, dec func(interface{}) (interface{}, error)) {
+	result, err := dec(p.valueInterface(pval))
+	if err != nil {
+		panic(err)
+	}
+
+	rv := reflect.ValueOf(result)
+	switch {
+	case !rv.IsValid():
+		val.Set(reflect.Zero(val.Type()))
+	case rv.Type() == val.Type():
+		val.Set(rv)
+	case rv.Type() == reflect.PtrTo(val.Type()) && !rv.IsNil():
+		val.Set(rv.Elem())
+	default:
+		panic(fmt.Errorf("plist: decoding function registered for %v returned a value of type %T", val.Type(), result))
+	}
+}
@@ -0,0 +1,103 @@
+package plist
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixedDecimal stands in for a type from another package: its fields are unexported, and it has no plist methods.
+type fixedDecimal struct {
+	units int64
+	scale int
+}
+
+func encodeFixedDecimal(v interface{}) (interface{}, error) {
+	d := v.(fixedDecimal)
+	if d.scale != 2 {
+		return nil, errors.New("only two decimal places are supported")
+	}
+	return fmt.Sprintf("%d.%02d", d.units/100, d.units%100), nil
+}
+
+func decodeFixedDecimal(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, received %T", v)
+	}
+	var whole, frac int64
+	if _, err := fmt.Sscanf(s, "%d.%02d", &whole, &frac); err != nil {
+		return nil, err
+	}
+	return &fixedDecimal{whole*100 + frac, 2}, nil
+}
+
+func TestRegisterTypeCodec(t *testing.T) {
+	typ := reflect.TypeOf(fixedDecimal{})
+	RegisterType(typ, encodeFixedDecimal, decodeFixedDecimal)
+	defer RegisterType(typ, nil, nil)
+
+	type invoice struct {
+		Total fixedDecimal
+		Lines []fixedDecimal
+		Tax   *fixedDecimal
+	}
+	expected := invoice{
+		Total: fixedDecimal{1234, 2},
+		Lines: []fixedDecimal{{1000, 2}, {234, 2}},
+		Tax:   &fixedDecimal{56, 2},
+	}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		data, err := Marshal(expected, format)
+		if err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if format == XMLFormat && !strings.Contains(string(data), "<string>12.34</string>") {
+			t.Errorf("Expected the total to be encoded as a string, received %s", data)
+		}
+
+		var decoded invoice
+		if _, err := Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: expected %+v, received %+v", FormatNames[format], expected, decoded)
+		}
+	}
+
+	// Errors from either function are returned.
+	if _, err := Marshal(fixedDecimal{1, 3}, XMLFormat); err == nil || !strings.Contains(err.Error(), "two decimal places") {
+		t.Errorf("Expected the encoding function's error, received %v", err)
+	}
+	data, _ := Marshal(map[string]int{"Total": 1}, XMLFormat)
+	var decoded invoice
+	if _, err := Unmarshal(data, &decoded); err == nil || !strings.Contains(err.Error(), "expected a string") {
+		t.Errorf("Expected the decoding function's error, received %v", err)
+	}
+
+	// Once removed, the type can't be encoded: it has no exported fields.
+	RegisterType(typ, nil, nil)
+	if data, err := Marshal(fixedDecimal{1234, 2}, XMLFormat); err != nil || strings.Contains(string(data), "12.34") {
+		t.Errorf("Expected the type to be encoded as an empty struct, received %s (%v)", data, err)
+	}
+}
+
+func TestRegisterTypeConcurrently(t *testing.T) {
+	typ := reflect.TypeOf(fixedDecimal{})
+	defer RegisterType(typ, nil, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			RegisterType(typ, encodeFixedDecimal, decodeFixedDecimal)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		Marshal(fixedDecimal{1234, 2}, XMLFormat)
+	}
+	<-done
+}
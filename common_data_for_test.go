@@ -250,6 +250,16 @@ var tests = []TestData{
 			BinaryFormat:   []byte{98, 112, 108, 105, 115, 116, 48, 48, 163, 1, 2, 3, 16, 104, 16, 105, 16, 33, 8, 12, 14, 16, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 18},
 		},
 	},
+	{
+		Name: "Byte Array",
+		Data: [4]byte{0xde, 0xad, 0xbe, 0xef},
+		Expected: map[int][]byte{
+			OpenStepFormat: []byte(`(222,173,190,239,)`),
+			GNUStepFormat:  []byte(`(<*I222>,<*I173>,<*I190>,<*I239>,)`),
+			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><array><integer>222</integer><integer>173</integer><integer>190</integer><integer>239</integer></array></plist>`),
+			BinaryFormat:   []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0xa4, 0x1, 0x2, 0x3, 0x4, 0x10, 0xde, 0x10, 0xad, 0x10, 0xbe, 0x10, 0xef, 0x8, 0xd, 0xf, 0x11, 0x13, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x15},
+		},
+	},
 	{
 		Name: "Unsigned Integers of Increasing Size",
 		Data: []uint64{0xff, 0xfff, 0xffff, 0xfffff, 0xffffff, 0xfffffff, 0xffffffff, 0xffffffffffffffff},
@@ -257,7 +267,7 @@ var tests = []TestData{
 			OpenStepFormat: []byte(`(255,4095,65535,1048575,16777215,268435455,4294967295,18446744073709551615,)`),
 			GNUStepFormat:  []byte(`(<*I255>,<*I4095>,<*I65535>,<*I1048575>,<*I16777215>,<*I268435455>,<*I4294967295>,<*I18446744073709551615>,)`),
 			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><array><integer>255</integer><integer>4095</integer><integer>65535</integer><integer>1048575</integer><integer>16777215</integer><integer>268435455</integer><integer>4294967295</integer><integer>18446744073709551615</integer></array></plist>`),
-			BinaryFormat:   []byte{98, 112, 108, 105, 115, 116, 48, 48, 168, 1, 2, 3, 4, 5, 6, 7, 8, 16, 255, 17, 15, 255, 17, 255, 255, 18, 0, 15, 255, 255, 18, 0, 255, 255, 255, 18, 15, 255, 255, 255, 18, 255, 255, 255, 255, 19, 255, 255, 255, 255, 255, 255, 255, 255, 8, 17, 19, 22, 25, 30, 35, 40, 45, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 54},
+			BinaryFormat:   []byte{98, 112, 108, 105, 115, 116, 48, 48, 168, 1, 2, 3, 4, 5, 6, 7, 8, 16, 255, 17, 15, 255, 17, 255, 255, 18, 0, 15, 255, 255, 18, 0, 255, 255, 255, 18, 15, 255, 255, 255, 18, 255, 255, 255, 255, 20, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 255, 255, 255, 255, 255, 255, 8, 17, 19, 22, 25, 30, 35, 40, 45, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 62},
 		},
 	},
 	{
@@ -289,9 +299,9 @@ var tests = []TestData{
 		Name: "Floats of Increasing Bitness",
 		Data: []interface{}{float32(math.MaxFloat32), float64(math.MaxFloat64)},
 		Expected: map[int][]byte{
-			OpenStepFormat: []byte(`(3.4028234663852886e+38,1.7976931348623157e+308,)`),
-			GNUStepFormat:  []byte(`(<*R3.4028234663852886e+38>,<*R1.7976931348623157e+308>,)`),
-			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><array><real>3.4028234663852886e+38</real><real>1.7976931348623157e+308</real></array></plist>`),
+			OpenStepFormat: []byte(`(3.4028235e+38,1.7976931348623157e+308,)`),
+			GNUStepFormat:  []byte(`(<*R3.4028235e+38>,<*R1.7976931348623157e+308>,)`),
+			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><array><real>3.4028235e+38</real><real>1.7976931348623157e+308</real></array></plist>`),
 			BinaryFormat:   []byte{98, 112, 108, 105, 115, 116, 48, 48, 162, 1, 2, 34, 127, 127, 255, 255, 35, 127, 239, 255, 255, 255, 255, 255, 255, 8, 11, 16, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 25},
 		},
 		// We can't store varying bitness in text formats.
@@ -391,8 +401,28 @@ var tests = []TestData{
 		SkipDecode: map[int]bool{OpenStepFormat: true},
 	},
 	{
-		Name:       "Map (integer keys) (expected to fail)",
-		Data:       map[int]string{1: "hi"},
+		Name: "Map (integer keys)",
+		Data: map[int]string{1: "hi", -20: "there"},
+		Expected: map[int][]byte{
+			OpenStepFormat: []byte(`{"-20"=there;1=hi;}`),
+			GNUStepFormat:  []byte(`{-20=there;1=hi;}`),
+			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><dict><key>-20</key><string>there</string><key>1</key><string>hi</string></dict></plist>`),
+			BinaryFormat:   []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0xd2, 0x1, 0x2, 0x3, 0x4, 0x53, 0x2d, 0x32, 0x30, 0x51, 0x31, 0x55, 0x74, 0x68, 0x65, 0x72, 0x65, 0x52, 0x68, 0x69, 0x8, 0xd, 0x11, 0x13, 0x19, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1c},
+		},
+	},
+	{
+		Name: "Map (unsigned integer keys)",
+		Data: map[uint8]bool{7: true, 255: false},
+		Expected: map[int][]byte{
+			OpenStepFormat: []byte(`{255=0;7=1;}`),
+			GNUStepFormat:  []byte(`{255=<*BN>;7=<*BY>;}`),
+			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><dict><key>255</key><false></false><key>7</key><true></true></dict></plist>`),
+			BinaryFormat:   []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0xd2, 0x1, 0x2, 0x3, 0x4, 0x53, 0x32, 0x35, 0x35, 0x51, 0x37, 0x8, 0x9, 0x8, 0xd, 0x11, 0x13, 0x14, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x15},
+		},
+	},
+	{
+		Name:       "Map (floating-point keys) (expected to fail)",
+		Data:       map[float64]string{1: "hi"},
 		ShouldFail: true,
 		// No types to decode, no need to add skips
 	},
@@ -571,6 +601,18 @@ var tests = []TestData{
 			BinaryFormat:   []byte{98, 112, 108, 105, 115, 116, 48, 48, 209, 1, 2, 80, 85, 72, 101, 108, 108, 111, 8, 11, 12, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 18},
 		},
 	},
+	{
+		Name: "UIDs",
+		Data: []UID{1, 0x1234},
+		Expected: map[int][]byte{
+			OpenStepFormat: []byte(`({CF$UID=1;},{CF$UID=4660;},)`),
+			GNUStepFormat:  []byte(`({CF$UID=<*I1>;},{CF$UID=<*I4660>;},)`),
+			XMLFormat:      []byte(xmlPreamble + `<plist version="1.0"><array><dict><key>CF$UID</key><integer>1</integer></dict><dict><key>CF$UID</key><integer>4660</integer></dict></array></plist>`),
+			BinaryFormat:   []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0xa2, 0x1, 0x2, 0x80, 0x1, 0x81, 0x12, 0x34, 0x8, 0xb, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10},
+		},
+		// OpenStep can't tell a CF$UID integer from a string.
+		SkipDecode: map[int]bool{OpenStepFormat: true},
+	},
 }
 
 type EverythingTestData struct {
@@ -590,7 +632,7 @@ var plistValueTreeRawData *EverythingTestData = &EverythingTestData{
 	Dat:      []byte{1, 2, 3, 4},
 	Date:     time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC),
 }
-var plistValueTree *plistValue
+var plistValueTree *Value
 var plistValueTreeAsBplist []byte = []byte{98, 112, 108, 105, 115, 116, 48, 48, 214, 1, 13, 17, 21, 25, 27, 2, 14, 18, 22, 26, 28, 88, 105, 110, 116, 97, 114, 114, 97, 121, 170, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 16, 1, 16, 8, 16, 16, 16, 32, 16, 64, 16, 2, 16, 9, 16, 17, 16, 33, 16, 65, 86, 102, 108, 111, 97, 116, 115, 162, 15, 16, 34, 66, 0, 0, 0, 35, 64, 80, 0, 0, 0, 0, 0, 0, 88, 98, 111, 111, 108, 101, 97, 110, 115, 162, 19, 20, 9, 8, 87, 115, 116, 114, 105, 110, 103, 115, 162, 23, 24, 92, 72, 101, 108, 108, 111, 44, 32, 65, 83, 67, 73, 73, 105, 0, 72, 0, 101, 0, 108, 0, 108, 0, 111, 0, 44, 0, 32, 78, 22, 117, 76, 84, 100, 97, 116, 97, 68, 1, 2, 3, 4, 84, 100, 97, 116, 101, 51, 65, 184, 69, 117, 120, 0, 0, 0, 8, 21, 30, 41, 43, 45, 47, 49, 51, 53, 55, 57, 59, 61, 68, 71, 76, 85, 94, 97, 98, 99, 107, 110, 123, 142, 147, 152, 157, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 29, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 166}
 var plistValueTreeAsXML string = xmlPreamble + `<plist version="1.0"><dict><key>intarray</key><array><integer>1</integer><integer>8</integer><integer>16</integer><integer>32</integer><integer>64</integer><integer>2</integer><integer>9</integer><integer>17</integer><integer>33</integer><integer>65</integer></array><key>floats</key><array><real>32</real><real>64</real></array><key>booleans</key><array><true></true><false></false></array><key>strings</key><array><string>Hello, ASCII</string><string>Hello, 世界</string></array><key>data</key><data>AQIDBA==</data><key>date</key><date>2013-11-27T00:34:00Z</date></dict></plist>`
 var plistValueTreeAsOpenStep string = `{booleans=(1,0,);data=<01020304>;date="2013-11-27 00:34:00 +0000";floats=(32,64,);intarray=(1,8,16,32,64,2,9,17,33,65,);strings=("Hello, ASCII","Hello, \U4e16\U754c",);}`
@@ -607,35 +649,35 @@ type LaxTestData struct {
 var laxTestData = LaxTestData{1, 2, 3.0, true, time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)}
 
 func setupPlistValues() {
-	plistValueTree = &plistValue{
+	plistValueTree = &Value{
 		Dictionary,
-		&dictionary{m: map[string]*plistValue{
-			"intarray": &plistValue{Array, []*plistValue{
-				&plistValue{Integer, signedInt{uint64(1), false}},
-				&plistValue{Integer, signedInt{uint64(8), false}},
-				&plistValue{Integer, signedInt{uint64(16), false}},
-				&plistValue{Integer, signedInt{uint64(32), false}},
-				&plistValue{Integer, signedInt{uint64(64), false}},
-				&plistValue{Integer, signedInt{uint64(2), false}},
-				&plistValue{Integer, signedInt{uint64(8), false}},
-				&plistValue{Integer, signedInt{uint64(17), false}},
-				&plistValue{Integer, signedInt{uint64(33), false}},
-				&plistValue{Integer, signedInt{uint64(65), false}},
+		&dictionary{m: map[string]*Value{
+			"intarray": &Value{Array, []*Value{
+				&Value{Integer, signedInt{uint64(1), false}},
+				&Value{Integer, signedInt{uint64(8), false}},
+				&Value{Integer, signedInt{uint64(16), false}},
+				&Value{Integer, signedInt{uint64(32), false}},
+				&Value{Integer, signedInt{uint64(64), false}},
+				&Value{Integer, signedInt{uint64(2), false}},
+				&Value{Integer, signedInt{uint64(8), false}},
+				&Value{Integer, signedInt{uint64(17), false}},
+				&Value{Integer, signedInt{uint64(33), false}},
+				&Value{Integer, signedInt{uint64(65), false}},
 			}},
-			"floats": &plistValue{Array, []*plistValue{
-				&plistValue{Real, sizedFloat{float64(32.0), 32}},
-				&plistValue{Real, sizedFloat{float64(64.0), 64}},
+			"floats": &Value{Array, []*Value{
+				&Value{Real, sizedFloat{float64(32.0), 32}},
+				&Value{Real, sizedFloat{float64(64.0), 64}},
 			}},
-			"booleans": &plistValue{Array, []*plistValue{
-				&plistValue{Boolean, true},
-				&plistValue{Boolean, false},
+			"booleans": &Value{Array, []*Value{
+				&Value{Boolean, true},
+				&Value{Boolean, false},
 			}},
-			"strings": &plistValue{Array, []*plistValue{
-				&plistValue{String, "Hello, ASCII"},
-				&plistValue{String, "Hello, 世界"},
+			"strings": &Value{Array, []*Value{
+				&Value{String, "Hello, ASCII"},
+				&Value{String, "Hello, 世界"},
 			}},
-			"data": &plistValue{Data, []byte{1, 2, 3, 4}},
-			"date": &plistValue{Date, time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)},
+			"data": &Value{Data, []byte{1, 2, 3, 4}},
+			"date": &Value{Date, time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)},
 		}},
 	}
 }
@@ -644,5 +686,5 @@ func init() {
 	setupPlistValues()
 
 	// Pre-warm the type info struct to remove it from benchmarking
-	getTypeInfo(reflect.ValueOf(plistValueTreeRawData).Type())
+	getTypeInfo(reflect.ValueOf(plistValueTreeRawData).Type(), "")
 }
@@ -1,14 +1,38 @@
 package plist
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"reflect"
 	"runtime"
+	"strings"
+	"unicode/utf8"
 )
 
 type parser interface {
-	parseDocument() (*plistValue, error)
+	parseDocument() (*Value, error)
+
+	// startArray positions the parser at the first element of the document's top-level array,
+	// and nextElement returns each element in turn, then io.EOF.
+	startArray() error
+	nextElement() (*Value, error)
+
+	// parseKey returns the value of key in the document's top-level dictionary, or nil if it has no such key.
+	parseKey(key string) (*Value, error)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal themselves from property list objects.
+// The UnmarshalPlist method receives a function that may be called to unmarshal the original property list value into
+// a field or variable of any type, in the same way that Unmarshal would.
+type Unmarshaler interface {
+	UnmarshalPlist(unmarshal func(interface{}) error) error
 }
 
 // A Decoder reads a property list from an input stream.
@@ -17,64 +41,495 @@ type Decoder struct {
 	Format int
 
 	reader io.ReadSeeker
+	stream *bufio.Reader
 	lax    bool
+
+	disallowUnknownFields bool
+	disallowDuplicateKeys bool
+	strictUTF8            bool
+	bigIntegers           bool
+	signedIntegers        bool
+	maxDepth              int
+	maxObjects            int
+	tagKey                string
+	keyNamer              func(string) string
+	renamed               map[tinfoKey]*typeInfo
+	dateLayout            string
+	registeredTypes       []reflect.Type
+
+	// ctx, if set, is checked for cancellation while a document is parsed.
+	ctx context.Context
+	// lazyData is set while decoding into a value that contains a DataReader.
+	lazyData bool
+	// binaryInfo describes the most recently decoded property list, if it was binary.
+	binaryInfo *BinaryInfo
+	// path leads from the value being decoded to the object currently being unmarshaled.
+	path []pathElement
 }
 
 // Decode works like Unmarshal, except it reads the decoder stream to find property list elements.
 //
 // After Decoding, the Decoder's Format field will be set to one of the plist format constants.
+// If the stream could not be parsed as a property list, Format will be InvalidFormat.
 func (p *Decoder) Decode(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if _, ok := r.(runtime.Error); ok {
 				panic(r)
 			}
-			err = r.(error)
+			err = p.annotateError(panicError(r))
 		}
 	}()
 
-	header := make([]byte, 6)
-	p.reader.Read(header)
-	p.reader.Seek(0, 0)
+	p.Format = InvalidFormat
+	p.path = p.path[:0]
 
-	var parser parser
-	var pval *plistValue
-	if bytes.Equal(header, []byte("bplist")) {
-		parser = newBplistParser(p.reader)
+	// Text property lists turn on lax mode for themselves; don't let that leak into the next Decode.
+	lax := p.lax
+	defer func() {
+		p.lax = lax
+	}()
+
+	// Data is only left in the document for the parser to hand to DataReaders, and never where a Value could keep it.
+	p.lazyData = containsType(reflect.TypeOf(v), dataReaderType, nil) && !containsType(reflect.TypeOf(v), valueType, nil)
+	defer func() {
+		p.lazyData = false
+	}()
+
+	pval, err := p.parseDocument()
+	if err != nil {
+		return err
+	}
+
+	// As in encoding/json, the document is read even if v can't hold it, so that Format is set.
+	if err := checkDecodeTarget(v); err != nil {
+		return err
+	}
+	p.unmarshal(pval, reflect.ValueOf(v))
+	return
+}
+
+// DecodeContext works like Decode, but gives up and returns ctx.Err() if ctx is cancelled or its deadline passes
+// before the property list has been parsed. The context is checked before decoding begins and periodically,
+// every thousand or so values, while the document is read.
+func (p *Decoder) DecodeContext(ctx context.Context, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.ctx = ctx
+	defer func() {
+		p.ctx = nil
+	}()
+	return p.Decode(v)
+}
+
+// DecodeValue works like Decode, but returns the property list's top-level object as a Value
+// rather than decoding it into a Go value.
+func (p *Decoder) DecodeValue() (*Value, error) {
+	p.Format = InvalidFormat
+	lax := p.lax
+	defer func() {
+		p.lax = lax
+	}()
+	return p.parseDocument()
+}
+
+// DecodeKey works like Decode, but decodes only the value of key in the property list's top-level dictionary into v.
+// It returns an error if the top-level value is not a dictionary or has no such key.
+// The key paths of decoding errors begin with key.
+//
+// Binary and XML property lists are read only as far as necessary to find the value: the other values in a binary
+// property list are not read at all, and those before the key in an XML property list are skipped without being
+// decoded. OpenStep and GNUStep property lists are parsed completely.
+func (p *Decoder) DecodeKey(key string, v interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = p.annotateError(panicError(r))
+		}
+	}()
+
+	p.Format = InvalidFormat
+	p.path = p.path[:0]
+
+	lax := p.lax
+	defer func() {
+		p.lax = lax
+	}()
+
+	var pval *Value
+	err = p.openDocument(func(parser parser) (err error) {
+		pval, err = parser.parseKey(key)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if pval == nil {
+		return fmt.Errorf("plist: key %q not found in the top-level dictionary", key)
+	}
+	if err := checkDecodeTarget(v); err != nil {
+		return err
+	}
+
+	p.path = append(p.path, pathElement{key: key})
+	p.unmarshal(pval, reflect.ValueOf(v))
+	return
+}
+
+// depthLimit returns the nesting depth limit to hand to the parsers.
+func (p *Decoder) depthLimit() int {
+	if p.maxDepth <= 0 {
+		return defaultMaxDepth
+	}
+	return p.maxDepth
+}
+
+// parseDocument detects the format of the stream and parses it into a Value tree,
+// setting Format (and lax mode, for OpenStep property lists) as it goes.
+func (p *Decoder) parseDocument() (pval *Value, err error) {
+	err = p.openDocument(func(parser parser) (err error) {
 		pval, err = parser.parseDocument()
+		return
+	})
+	return
+}
+
+// maxDecompressedSize is the most a gzipped property list is decompressed to. Anything beyond it is
+// never read, so a property list that decompresses to more fails to parse.
+var maxDecompressedSize int64 = 1 << 30
+
+var (
+	utf8BOM   = []byte{0xEF, 0xBB, 0xBF}
+	gzipMagic = []byte{0x1F, 0x8B}
+)
+
+// openDocument detects the format of the stream and calls parse with a parser for it,
+// setting Format (and lax mode, for OpenStep property lists) if parse succeeds.
+// parse is called a second time, with a text parser, if the XML parser rejects the stream as invalid.
+func (p *Decoder) openDocument(parse func(parser) error) error {
+	p.binaryInfo = nil
+
+	// The source is only replaced for this document, as by a decompressing reader below.
+	seeker, stream := p.reader, p.stream
+
+	var header []byte
+	if seeker != nil {
+		// Every document is read from the start, wherever the last one left the reader.
+		seeker.Seek(0, 0)
+		header = make([]byte, 6)
+		n, _ := io.ReadFull(seeker, header)
+		header = header[:n]
+		seeker.Seek(0, 0)
+	} else {
+		header, _ = stream.Peek(6)
+	}
+
+	if bytes.HasPrefix(header, gzipMagic) {
+		// Decompress gzipped property lists on the fly. The result can't be seeked, so it's read like any other stream,
+		// and is cut off at maxDecompressedSize so that a small file can't expand into more than we'd care to hold.
+		var source io.Reader = stream
+		if seeker != nil {
+			source = seeker
+		}
+		gz, err := gzip.NewReader(source)
 		if err != nil {
-			// Had a bplist header, but still got an error: we have to die here.
 			return err
 		}
-		p.Format = BinaryFormat
-	} else {
-		parser = newXMLPlistParser(p.reader)
-		pval, err = parser.parseDocument()
-		if _, ok := err.(invalidPlistError); ok {
-			// Rewind: the XML parser might have exhausted the file.
-			p.reader.Seek(0, 0)
-			// We don't use parser here because we want the textPlistParser type
-			tp := newTextPlistParser(p.reader)
-			pval, err = tp.parseDocument()
+		seeker = nil
+		stream = bufio.NewReader(io.LimitReader(gz, maxDecompressedSize))
+		header, _ = stream.Peek(6)
+	}
+
+	if bytes.Equal(header, []byte("bplist")) {
+		var reader io.ReadSeeker = seeker
+		if reader == nil {
+			// The binary parser needs random access, so we have to buffer the entire stream.
+			data, err := ioutil.ReadAll(stream)
 			if err != nil {
 				return err
 			}
-			p.Format = tp.format
-			if p.Format == OpenStepFormat {
-				// OpenStep property lists can only store strings,
-				// so we have to turn on lax mode here for the unmarshal step later.
-				p.lax = true
-			}
+			reader = bytes.NewReader(data)
+		}
+		bp := newBplistParser(reader)
+		bp.maxDepth = p.depthLimit()
+		bp.ctx = p.ctx
+		bp.lazyData = p.lazyData
+		bp.disallowDuplicateKeys = p.disallowDuplicateKeys
+		bp.strictUTF8 = p.strictUTF8
+		if p.maxObjects > 0 {
+			bp.maxObjects = uint64(p.maxObjects)
+		}
+		if err := parse(bp); err != nil {
+			// Had a bplist header, but still got an error: we have to die here.
+			return err
+		}
+		p.Format = BinaryFormat
+		p.binaryInfo = bp.info()
+		return nil
+	}
+
+	// XML and text property lists may begin with a byte order mark, which the parsers don't expect,
+	// and may be encoded in UTF-16, which they have to be given as UTF-8. Without a byte order mark, UTF-16 shows
+	// itself by the zero byte that pairs with the ASCII character the document opens with: usually the < of the
+	// XML declaration, but possibly whitespace, a comment or a shebang line, which the XML parser skips.
+	var start int64
+	var utf16Order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(header, utf8BOM):
+		start = int64(len(utf8BOM))
+	case bytes.HasPrefix(header, []byte{0xFF, 0xFE}):
+		start, utf16Order = 2, binary.LittleEndian
+	case bytes.HasPrefix(header, []byte{0xFE, 0xFF}):
+		start, utf16Order = 2, binary.BigEndian
+	case len(header) >= 2 && isASCII(header[0]) && header[1] == 0:
+		utf16Order = binary.LittleEndian
+	case len(header) >= 2 && header[0] == 0 && isASCII(header[1]):
+		utf16Order = binary.BigEndian
+	}
+	if start > 0 {
+		if seeker != nil {
+			seeker.Seek(start, 0)
 		} else {
-			if err != nil {
-				return err
-			}
-			p.Format = XMLFormat
+			stream.Discard(int(start))
 		}
 	}
+	transcode := func(r io.Reader) io.Reader {
+		if utf16Order != nil {
+			return newUTF16Reader(r, utf16Order)
+		}
+		return r
+	}
 
-	p.unmarshal(pval, reflect.ValueOf(v))
-	return
+	var reader io.Reader = seeker
+	var recorder *recordingReader
+	if reader == nil {
+		// We can't rewind a plain stream, so hold on to everything the XML parser
+		// reads in case we have to hand it to the text parser instead.
+		recorder = &recordingReader{Reader: stream, record: &bytes.Buffer{}}
+		reader = recorder
+	}
+	xp := newXMLPlistParser(transcode(reader))
+	xp.maxDepth = p.depthLimit()
+	xp.ctx = p.ctx
+	xp.lazyData = p.lazyData
+	xp.disallowDuplicateKeys = p.disallowDuplicateKeys
+	xp.dateLayout = p.dateLayout
+	err := parse(xp)
+	if _, ok := err.(invalidPlistError); ok {
+		if seeker != nil {
+			// Rewind: the XML parser might have exhausted the file.
+			seeker.Seek(start, 0)
+			reader = seeker
+		} else {
+			reader = io.MultiReader(recorder.record, stream)
+		}
+		tp := newTextPlistParser(transcode(reader))
+		tp.maxDepth = p.depthLimit()
+		tp.ctx = p.ctx
+		tp.disallowDuplicateKeys = p.disallowDuplicateKeys
+		if err := parse(tp); err != nil {
+			return err
+		}
+		p.Format = tp.format
+		if p.Format == OpenStepFormat {
+			// OpenStep property lists can only store strings,
+			// so we have to turn on lax mode here for the unmarshal step later.
+			p.lax = true
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if recorder != nil {
+		// This is definitely XML: there's no need to keep what the XML parser reads from now on.
+		recorder.record = nil
+	}
+	p.Format = XMLFormat
+	return nil
+}
+
+// isASCII reports whether b is a character other than NUL in the ASCII range.
+func isASCII(b byte) bool {
+	return b > 0 && b < utf8.RuneSelf
+}
+
+// recordingReader keeps a copy of everything read from its underlying reader in record, until record is set to nil.
+type recordingReader struct {
+	io.Reader
+	record *bytes.Buffer
+}
+
+func (r *recordingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if r.record != nil {
+		r.record.Write(b[:n])
+	}
+	return n, err
+}
+
+// Lax turns on relaxed type checking for subsequent calls to Decode.
+//
+// In lax mode, the Decoder will attempt to convert property list values into the
+// destination type instead of failing with a type mismatch: strings are parsed as integers (decimal or hexadecimal),
+// floating-point numbers, booleans (YES, NO, true, false, 1 or 0) and dates where necessary, the integers 0 and 1 may be
+// decoded into booleans, other integers may be decoded into floating-point values, and dates may be decoded into
+// integers and floating-point values as seconds since the Unix epoch
+// (the integer seconds are rounded down; floating-point values keep the fraction). Lax mode is always in effect when decoding OpenStep property lists,
+// as they can only store plain old data as strings.
+//
+// Lax returns the Decoder to allow chaining.
+func (p *Decoder) Lax(lax bool) *Decoder {
+	p.lax = lax
+	return p
+}
+
+// BigIntegers turns on support for integers that do not fit in 64 bits for subsequent calls to Decode.
+//
+// Binary property lists may contain 128-bit integers. With BigIntegers enabled, those that cannot be represented
+// in 64 bits are decoded as *big.Int values when the destination is an empty interface, and cause Decode to return an error
+// when the destination is any other integer type. Otherwise, such integers are truncated to their low 64 bits.
+// Integers can be decoded into big.Int destinations regardless of this setting.
+//
+// BigIntegers returns the Decoder to allow chaining.
+func (p *Decoder) BigIntegers(bigIntegers bool) *Decoder {
+	p.bigIntegers = bigIntegers
+	return p
+}
+
+// SignedIntegers makes subsequent calls to Decode yield int64 values for integers decoded into an empty interface.
+// By default, negative integers are decoded as int64 and all others as uint64.
+// With SignedIntegers enabled, only integers greater than math.MaxInt64 are still decoded as uint64.
+//
+// SignedIntegers returns the Decoder to allow chaining.
+func (p *Decoder) SignedIntegers(signed bool) *Decoder {
+	p.signedIntegers = signed
+	return p
+}
+
+// MaxDepth limits how deeply arrays and dictionaries may be nested in the property lists read by subsequent calls to Decode.
+// Documents that exceed the limit cause Decode to return a *SyntaxError.
+// A limit of zero or less selects the default of 10000 levels.
+//
+// MaxDepth returns the Decoder to allow chaining.
+func (p *Decoder) MaxDepth(n int) *Decoder {
+	p.maxDepth = n
+	return p
+}
+
+// MaxObjects limits the number of objects a binary property list read by subsequent calls to Decode may declare.
+// Documents that declare more objects are rejected with a *SyntaxError before any of them are read.
+// A limit of zero or less, the default, allows as many objects as fit in the document.
+//
+// MaxObjects returns the Decoder to allow chaining.
+func (p *Decoder) MaxObjects(n int) *Decoder {
+	p.maxObjects = n
+	return p
+}
+
+// DisallowUnknownFields causes subsequent calls to Decode to return an error when a dictionary
+// being decoded into a struct contains a key that does not match any of the struct's fields.
+// Dictionaries decoded into maps are not affected.
+func (p *Decoder) DisallowUnknownFields() {
+	p.disallowUnknownFields = true
+}
+
+// DisallowDuplicateKeys causes subsequent calls to Decode to return a *SyntaxError naming the key when a dictionary
+// in the property list contains the same key more than once. By default, as in CoreFoundation, the last of the key's
+// values is kept.
+func (p *Decoder) DisallowDuplicateKeys() {
+	p.disallowDuplicateKeys = true
+}
+
+// StrictUTF8 sets whether subsequent calls to Decode return a *SyntaxError naming the object's offset when a string in a
+// binary property list is not well formed: an ASCII string that is not valid UTF-8, or a UTF-16 string with an
+// unpaired surrogate. By default such strings are decoded as they are, with unpaired surrogates replaced by
+// U+FFFD. The XML parser always rejects invalid UTF-8.
+//
+// StrictUTF8 returns the Decoder to allow chaining.
+func (p *Decoder) StrictUTF8(strict bool) *Decoder {
+	p.strictUTF8 = strict
+	return p
+}
+
+// DateLayout makes subsequent calls to Decode parse the dates in XML property lists with layout, as understood by
+// time.Parse, before falling back to RFC 3339. Dates in layouts without a time zone are taken to be in UTC.
+//
+// DateLayout returns the Decoder to allow chaining.
+func (p *Decoder) DateLayout(layout string) *Decoder {
+	p.dateLayout = layout
+	return p
+}
+
+// TagKey makes subsequent calls to Decode read struct field names and flags from the struct tag under key,
+// such as "json", instead of "plist". Fields without a tag under key fall back to their plist tags.
+//
+// TagKey returns the Decoder to allow chaining.
+func (p *Decoder) TagKey(key string) *Decoder {
+	p.tagKey = key
+	return p
+}
+
+// KeyNamer makes subsequent calls to Decode match struct fields without a key in their tags to the key namer returns
+// for the field's name, as Encoder.KeyNamer does when encoding. Keys given by tags are used as they are.
+// A nil namer restores the default.
+//
+// KeyNamer returns the Decoder to allow chaining.
+func (p *Decoder) KeyNamer(namer func(fieldName string) string) *Decoder {
+	p.keyNamer = namer
+	p.renamed = nil
+	return p
+}
+
+// RegisterType makes subsequent calls to Decode use the type of v to decode values into interfaces that it satisfies.
+// When Decode meets a nil interface other than interface{}, such as the elements of an []io.Reader,
+// it allocates a value of the single registered type that implements the interface and decodes into that.
+// Register a pointer, such as (*T)(nil), for types whose methods have pointer receivers.
+//
+// Without a registered type, or with more than one that implements the interface, such values cannot be decoded.
+// Values of interface{} type always receive the generic representation described in Unmarshal.
+//
+// RegisterType returns the Decoder to allow chaining.
+func (p *Decoder) RegisterType(v interface{}) *Decoder {
+	p.registeredTypes = append(p.registeredTypes, reflect.TypeOf(v))
+	return p
+}
+
+// registeredType returns the single registered type that implements the interface type iface.
+func (p *Decoder) registeredType(iface reflect.Type) (reflect.Type, bool) {
+	var found reflect.Type
+	for _, typ := range p.registeredTypes {
+		if typ == nil || !typ.Implements(iface) || typ == found {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = typ
+	}
+	return found, found != nil
+}
+
+// BinaryInfo describes the layout of the most recently decoded property list.
+// It returns an error if that property list was not binary, or if nothing has been decoded successfully.
+func (p *Decoder) BinaryInfo() (*BinaryInfo, error) {
+	if p.binaryInfo == nil {
+		return nil, errors.New("plist: the most recently decoded property list was not a binary property list")
+	}
+	return p.binaryInfo, nil
+}
+
+// Reset discards the Decoder's state and makes it read property lists from r, as if it had been created by NewDecoder.
+// Options set by Lax, BigIntegers, SignedIntegers, MaxDepth, MaxObjects, TagKey, KeyNamer, DateLayout, StrictUTF8, DisallowUnknownFields and
+// DisallowDuplicateKeys are kept.
+func (p *Decoder) Reset(r io.ReadSeeker) {
+	p.Format = InvalidFormat
+	p.reader = r
+	p.stream = nil
+	p.binaryInfo = nil
 }
 
 // NewDecoder returns a Decoder that reads property list elements from a stream reader, r.
@@ -83,7 +538,25 @@ func NewDecoder(r io.ReadSeeker) *Decoder {
 	return &Decoder{Format: InvalidFormat, reader: r, lax: false}
 }
 
+// NewDecoderReader returns a Decoder that reads property list elements from r,
+// which need not support seeking.
+// Binary property lists require random access, so they will be read entirely into memory before being decoded.
+func NewDecoderReader(r io.Reader) *Decoder {
+	return &Decoder{Format: InvalidFormat, stream: bufio.NewReader(r), lax: false}
+}
+
+// Valid reports whether data is a well-formed property list, and if so, its format.
+// Valid parses the entire document but does not decode it into any Go value.
+func Valid(data []byte) (format int, ok bool) {
+	dec := NewDecoder(bytes.NewReader(data))
+	if _, err := dec.parseDocument(); err != nil {
+		return InvalidFormat, false
+	}
+	return dec.Format, true
+}
+
 // Unmarshal parses a property list document and stores the result in the value pointed to by v.
+// If v is nil or not a pointer, Unmarshal returns an InvalidUnmarshalError.
 //
 // Unmarshal uses the inverse of the type encodings that Marshal uses, allocating heap-borne types as necessary.
 //
@@ -93,21 +566,65 @@ func NewDecoder(r io.ReadSeeker) *Decoder {
 // in the interface value. If the interface value is nil, Unmarshal stores one of the following in the interface value:
 //
 //     string, bool, uint64, float64
+//     int64, for negative integers
+//     float32, for 32-bit reals in binary property lists
 //     []byte, for plist data
+//     time.Time, for plist dates
+//     UID, for plist UIDs
 //     []interface{}, for plist arrays
 //     map[string]interface{}, for plist dictionaries
+//     Set, for sets in binary property lists
+//     Null, for null objects in binary property lists
+//
+// The members of arrays, sets and dictionaries are themselves generic values, at any depth. The same is true of
+// the elements of a []interface{} or the values of a map[string]interface{} that a property list is decoded into.
+//
+// Only the empty interface can hold these generic values. Unmarshal cannot decode into a nil interface of any other type,
+// such as io.Reader; use a Decoder with RegisterType to nominate the concrete type to allocate for it.
+//
+// A null object sets a pointer, map, slice or interface value to nil, and leaves values of other types unchanged.
+//
+// Property list arrays decode into Go arrays only if their lengths match; in lax mode, extra values are dropped
+// and missing ones are left as zero values. Data may be decoded into a byte array in the same way.
+//
+// To preserve the order of a dictionary's keys, decode it into an OrderedDict.
+// To keep a property list object exactly as it appears in the property list, decode it into a Value.
+// To keep an integer or real exactly without choosing a Go type for it, decode it into a Number.
+// A time.Duration may be decoded from a string that time.ParseDuration accepts or from an integer of nanoseconds,
+// and a url.URL from a string that url.Parse accepts.
+//
+// Dictionaries decode into structs by matching their keys to the keys Marshal would use for the struct's fields.
+// As in encoding/json, a field with no exactly matching key takes the value of a key that differs only in case,
+// the last such key if there are several. Unlike encoding/json, an exactly matching key always wins.
+// Arrays decode into positional structs, as described for Marshal, by position: the N'th member into the N'th field.
+// The array must have exactly as many members as the struct has fields; in lax mode, extra members are dropped
+// and fields without a member are left unchanged.
+//
+// If a value implements Unmarshaler, Unmarshal calls its UnmarshalPlist method instead of decoding into it directly.
+// Otherwise, strings are decoded into values implementing encoding.TextUnmarshaler by calling UnmarshalText,
+// and data into values implementing encoding.BinaryUnmarshaler by calling UnmarshalBinary.
 //
 // If a property list value is not appropriate for a given value type, Unmarshal aborts immediately and returns an error.
+// An object of the wrong kind altogether, such as a dictionary decoded into a slice, is reported as a TypeMismatchError
+// that gives the key path of the object; other errors below the top level are wrapped in an UnmarshalError.
+// This includes integers that do not fit in their destination, such as negative integers decoded into unsigned types
+// and unsigned integers above math.MaxInt64 decoded into an int64; in lax mode these are truncated instead.
+// Likewise, a finite real too large for a float32 is an error, and becomes an infinity in lax mode.
 //
-// As Go does not support 128-bit types, and we don't want to pretend we're giving the user integer types (as opposed to
-// secretly passing them structs), Unmarshal will drop the high 64 bits of any 128-bit integers encoded in binary property lists.
-// (This is important because CoreFoundation serializes some large 64-bit values as 128-bit values with an empty high half.)
+// As Go does not support 128-bit types, Unmarshal will drop the high 64 bits of any 128-bit integers encoded in binary property lists
+// unless they are decoded into a big.Int. (CoreFoundation serializes some large 64-bit values as 128-bit values with an empty high half;
+// these always decode faithfully.) Use a Decoder with BigIntegers enabled to preserve the full value or report an error instead.
 //
 // When Unmarshal encounters an OpenStep property list, it will enter a relaxed parsing mode: OpenStep property lists can only store
 // plain old data as strings, so we will attempt to recover integer, floating-point, boolean and date values wherever they are necessary.
 // (for example, if Unmarshal attempts to unmarshal an OpenStep property list into a time.Time, it will try to parse the string it
 // receives as a time.)
 //
+// Property lists compressed with gzip, as .plist.gz files are, are decompressed transparently, to at most 1 GiB.
+//
+// XML and binary property lists must contain nothing after their top-level value but whitespace and, in XML, comments;
+// anything else, such as a second property list, is reported as a SyntaxError.
+//
 // Unmarshal returns the detected property list format and an error, if any.
 func Unmarshal(data []byte, v interface{}) (format int, err error) {
 	r := bytes.NewReader(data)
@@ -116,3 +633,11 @@ func Unmarshal(data []byte, v interface{}) (format int, err error) {
 	format = dec.Format
 	return
 }
+
+// UnmarshalString works like Unmarshal, but parses the property list document held in s.
+func UnmarshalString(s string, v interface{}) (format int, err error) {
+	dec := NewDecoder(strings.NewReader(s))
+	err = dec.Decode(v)
+	format = dec.Format
+	return
+}
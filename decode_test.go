@@ -2,9 +2,19 @@ package plist
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func BenchmarkXMLDecode(b *testing.B) {
@@ -49,6 +59,122 @@ func TestLaxDecode(t *testing.T) {
 	}
 }
 
+func TestLaxIntegerDecode(t *testing.T) {
+	plists := []struct {
+		pl string
+		f  float64
+	}{
+		{"<integer>3</integer>", 3.0},
+		{"<integer>-42</integer>", -42.0},
+	}
+
+	for _, plist := range plists {
+		var f float64
+		err := NewDecoder(bytes.NewReader([]byte(plist.pl))).Decode(&f)
+		if err == nil {
+			t.Errorf("Expected error decoding %s into float64 in strict mode, received nothing.", plist.pl)
+		}
+
+		err = NewDecoder(bytes.NewReader([]byte(plist.pl))).Lax(true).Decode(&f)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if f != plist.f {
+			t.Errorf("Expected %v, received %v.", plist.f, f)
+		}
+	}
+}
+
+func TestLaxStringDecode(t *testing.T) {
+	type numbers struct {
+		I   int
+		I8  int8
+		U   uint16
+		F   float64
+		F32 float32
+		B   []bool
+	}
+	plist := `<plist><dict>
+		<key>I</key><string>-42</string>
+		<key>I8</key><string>0x7f</string>
+		<key>U</key><string>65535</string>
+		<key>F</key><string>2.5e3</string>
+		<key>F32</key><string>-0.5</string>
+		<key>B</key><array><string>YES</string><string>NO</string><string>yes</string><string>true</string><string>false</string><string>1</string></array>
+	</dict></plist>`
+	expected := numbers{-42, 127, 65535, 2500, -0.5, []bool{true, false, true, true, false, true}}
+
+	var n numbers
+	if err := NewDecoder(strings.NewReader(plist)).Lax(true).Decode(&n); err != nil || !reflect.DeepEqual(n, expected) {
+		t.Errorf("Expected %+v, received %+v (%v)", expected, n, err)
+	}
+
+	if err := NewDecoder(strings.NewReader(plist)).Decode(&n); err == nil {
+		t.Error("Expected strings not to decode into numbers in strict mode")
+	}
+	var b bool
+	if err := NewDecoder(strings.NewReader(`<string>YES</string>`)).Decode(&b); err == nil {
+		t.Error("Expected strings not to decode into booleans in strict mode")
+	}
+}
+
+func TestLaxBoolDecode(t *testing.T) {
+	forms := map[string]bool{
+		`<true/>`:                true,
+		`<false/>`:               false,
+		`<string>YES</string>`:   true,
+		`<string>NO</string>`:    false,
+		`<string>true</string>`:  true,
+		`<string>false</string>`: false,
+		`<string>1</string>`:     true,
+		`<string>0</string>`:     false,
+		`<integer>1</integer>`:   true,
+		`<integer>0</integer>`:   false,
+	}
+	for form, expected := range forms {
+		doc := "<plist>" + form + "</plist>"
+		var b bool
+		if err := NewDecoder(strings.NewReader(doc)).Lax(true).Decode(&b); err != nil || b != expected {
+			t.Errorf("%s: expected %v, received %v (%v)", form, expected, b, err)
+		}
+
+		// Only the canonical forms decode in strict mode.
+		canonical := form == `<true/>` || form == `<false/>`
+		b = !expected
+		if err := NewDecoder(strings.NewReader(doc)).Decode(&b); canonical != (err == nil) || canonical && b != expected {
+			t.Errorf("%s: expected strict decoding to succeed only for <true/> and <false/>, received %v (%v)", form, b, err)
+		}
+	}
+
+	for _, form := range []string{`<integer>2</integer>`, `<integer>-1</integer>`, `<string>maybe</string>`} {
+		var b bool
+		if err := NewDecoder(strings.NewReader("<plist>" + form + "</plist>")).Lax(true).Decode(&b); err == nil {
+			t.Errorf("%s: expected an error, received %v", form, b)
+		}
+	}
+
+	var generic interface{}
+	if _, err := Unmarshal([]byte(`<plist><true/></plist>`), &generic); err != nil || generic != true {
+		t.Errorf("Expected <true/> to decode into an interface{} as true, received %#v (%v)", generic, err)
+	}
+}
+
+func TestLaxDoesNotPersistAfterOpenStep(t *testing.T) {
+	var i int
+	decoder := NewDecoder(bytes.NewReader([]byte(`42`)))
+	err := decoder.Decode(&i)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	decoder.reader = bytes.NewReader([]byte(`<string>42</string>`))
+	err = decoder.Decode(&i)
+	t.Logf("Error: %v", err)
+	if err == nil {
+		t.Error("Expected error, received nothing.")
+	}
+}
+
 func TestIllegalLaxDecode(t *testing.T) {
 	i := int64(0)
 	u := uint64(0)
@@ -62,6 +188,9 @@ func TestIllegalLaxDecode(t *testing.T) {
 		{"<string>abc</string>", &u},
 		{"<string>def</string>", &f},
 		{"<string>ghi</string>", &b},
+		{"<string>YESS</string>", &b},
+		{"<string>0x</string>", &i},
+		{"<string>4.2</string>", &i},
 		{"<string>jkl</string>", []byte{0x00}},
 	}
 
@@ -217,39 +346,1740 @@ func TestFormatDetection(t *testing.T) {
 	}
 }
 
-func ExampleDecoder_Decode() {
-	type sparseBundleHeader struct {
-		InfoDictionaryVersion string `plist:"CFBundleInfoDictionaryVersion"`
-		BandSize              uint64 `plist:"band-size"`
-		BackingStoreVersion   int    `plist:"bundle-backingstore-version"`
-		DiskImageBundleType   string `plist:"diskimage-bundle-type"`
-		Size                  uint64 `plist:"size"`
+func TestDecoderFormat(t *testing.T) {
+	type formatTest struct {
+		expectedFormat int
+		data           []byte
+	}
+	plists := []formatTest{
+		{BinaryFormat, plistValueTreeAsBplist},
+		{XMLFormat, []byte(plistValueTreeAsXML)},
+		{OpenStepFormat, []byte(plistValueTreeAsOpenStep)},
+		{GNUStepFormat, []byte(plistValueTreeAsGNUStep)},
+		{InvalidFormat, []byte(`bplist00`)},
 	}
 
-	buf := bytes.NewReader([]byte(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-	<dict>
-		<key>CFBundleInfoDictionaryVersion</key>
-		<string>6.0</string>
-		<key>band-size</key>
-		<integer>8388608</integer>
-		<key>bundle-backingstore-version</key>
-		<integer>1</integer>
-		<key>diskimage-bundle-type</key>
-		<string>com.apple.diskimage.sparsebundle</string>
-		<key>size</key>
-		<integer>4398046511104</integer>
-	</dict>
-</plist>`))
+	for _, fmttest := range plists {
+		var obj interface{}
+		decoder := NewDecoder(bytes.NewReader(fmttest.data))
+		decoder.Decode(&obj)
+		if decoder.Format != fmttest.expectedFormat {
+			t.Errorf("Wanted %s, received %s.", FormatNames[fmttest.expectedFormat], FormatNames[decoder.Format])
+		}
+	}
 
-	var data sparseBundleHeader
-	decoder := NewDecoder(buf)
-	err := decoder.Decode(&data)
+	// A failed decode must not report the format of a previous decode.
+	var obj interface{}
+	decoder := NewDecoder(bytes.NewReader(plistValueTreeAsBplist))
+	decoder.Decode(&obj)
+	decoder.reader = bytes.NewReader([]byte(`bplist00`))
+	decoder.Decode(&obj)
+	if decoder.Format != InvalidFormat {
+		t.Errorf("Wanted %s after failed decode, received %s.", FormatNames[InvalidFormat], FormatNames[decoder.Format])
+	}
+}
+
+func TestDateRoundTrip(t *testing.T) {
+	// 2013-11-27 05:34:00 +0500 is 2013-11-27 00:34:00 UTC, or 407205240 seconds after the Apple epoch.
+	date := time.Date(2013, 11, 27, 5, 34, 0, 0, time.FixedZone("PLUS5", 5*60*60))
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		data, err := Marshal(date, format)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+
+		var d time.Time
+		if _, err := Unmarshal(data, &d); err != nil {
+			t.Error(err.Error())
+		}
+		if !d.Equal(date) || d.Location() != time.UTC {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], date.In(time.UTC), d)
+		}
+
+		var i interface{}
+		if _, err := Unmarshal(data, &i); err != nil {
+			t.Error(err.Error())
+		}
+		if it, ok := i.(time.Time); !ok || !it.Equal(date) {
+			t.Errorf("%s: expected time.Time %v, received %T %v", FormatNames[format], date, i, i)
+		}
+	}
+
+	data, _ := Marshal(date, XMLFormat)
+	if expected := xmlPreamble + `<plist version="1.0"><date>2013-11-27T00:34:00Z</date></plist>`; string(data) != expected {
+		t.Errorf("Expected: %s\nReceived: %s", expected, data)
+	}
+
+	data, _ = Marshal(date, BinaryFormat)
+	var pval *Value
+	pval, _ = newBplistParser(bytes.NewReader(data)).parseDocument()
+	if data[8] != bpTagDate|0x3 || pval.kind != Date {
+		t.Errorf("Expected a binary date object, received tag %x", data[8])
+	}
+	var secs float64
+	binary.Read(bytes.NewReader(data[9:17]), binary.BigEndian, &secs)
+	if secs != 407205240 {
+		t.Errorf("Expected 407205240 seconds since the Apple epoch, received %v", secs)
+	}
+}
+
+func TestUIDInterfaceDecode(t *testing.T) {
+	for _, format := range []int{XMLFormat, BinaryFormat, GNUStepFormat} {
+		data, err := Marshal(map[string]interface{}{"root": UID(7)}, format)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+
+		var i interface{}
+		if _, err := Unmarshal(data, &i); err != nil {
+			t.Error(err.Error())
+		}
+		if m, ok := i.(map[string]interface{}); !ok || m["root"] != UID(7) {
+			t.Errorf("%s: expected a UID, received %#v", FormatNames[format], i)
+		}
+	}
+}
+
+func TestUIDDictionaryDecode(t *testing.T) {
+	for _, plist := range []string{
+		`<plist><dict><key>ref</key><dict><key>CF$UID</key><integer>7</integer></dict></dict></plist>`,
+		`{ref={CF$UID=<*I7>;};}`,
+	} {
+		// Dictionaries that happen to look like UIDs decode as dictionaries unless a UID is wanted.
+		var counts map[string]map[string]int
+		if _, err := Unmarshal([]byte(plist), &counts); err != nil || counts["ref"]["CF$UID"] != 7 {
+			t.Errorf("%s: expected a map holding CF$UID 7, received %v (%v)", plist, counts, err)
+		}
+		var maps map[string]map[string]interface{}
+		if _, err := Unmarshal([]byte(plist), &maps); err != nil || maps["ref"]["CF$UID"] != uint64(7) {
+			t.Errorf("%s: expected a generic map holding CF$UID 7, received %v (%v)", plist, maps, err)
+		}
+		var s struct {
+			Ref struct {
+				ID int `plist:"CF$UID"`
+			}
+		}
+		if _, err := Unmarshal([]byte(plist), &s); err != nil || s.Ref.ID != 7 {
+			t.Errorf("%s: expected a struct holding 7, received %+v (%v)", plist, s, err)
+		}
+
+		var refs map[string]UID
+		if _, err := Unmarshal([]byte(plist), &refs); err != nil || refs["ref"] != UID(7) {
+			t.Errorf("%s: expected UID 7, received %v (%v)", plist, refs, err)
+		}
+		var generic map[string]interface{}
+		if _, err := Unmarshal([]byte(plist), &generic); err != nil || generic["ref"] != UID(7) {
+			t.Errorf("%s: expected UID 7 in an interface, received %#v (%v)", plist, generic, err)
+		}
+	}
+}
+
+func TestOrderedDictDecode(t *testing.T) {
+	keys := []string{"zebra", "apple", "mango", "banana"}
+	xml := `<plist><dict><key>zebra</key><integer>1</integer><key>apple</key><string>2</string><key>mango</key><array><true/></array><key>banana</key><dict><key>b</key><real>4</real></dict></dict></plist>`
+	text := `{zebra=<*I1>;apple=2;mango=(<*BY>);banana={b=<*R4>;};}`
+
+	var fromXML OrderedDict
+	if _, err := Unmarshal([]byte(xml), &fromXML); err != nil {
+		t.Error(err.Error())
+	}
+
+	// The encoder writes OrderedDicts out in order, so this will also exercise the binary parser.
+	bplist, err := Marshal(fromXML, BinaryFormat)
 	if err != nil {
-		fmt.Println(err)
+		t.Error(err.Error())
 	}
-	fmt.Println(data)
 
-	// Output: {6.0 8388608 1 com.apple.diskimage.sparsebundle 4398046511104}
+	expectedValues := map[string]interface{}{
+		"zebra":  uint64(1),
+		"apple":  "2",
+		"mango":  []interface{}{true},
+		"banana": map[string]interface{}{"b": float64(4)},
+	}
+
+	for _, data := range [][]byte{[]byte(xml), bplist, []byte(text)} {
+		var od OrderedDict
+		format, err := Unmarshal(data, &od)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if !reflect.DeepEqual(od.Keys, keys) {
+			t.Errorf("%s: expected keys %v, received %v", FormatNames[format], keys, od.Keys)
+		}
+		if !reflect.DeepEqual(od.Values, expectedValues) {
+			t.Errorf("%s: expected values %#v, received %#v", FormatNames[format], expectedValues, od.Values)
+		}
+	}
+
+	var nested struct {
+		Outer OrderedDict
+	}
+	if _, err := Unmarshal([]byte(`<dict><key>Outer</key><dict><key>b</key><string/><key>a</key><string/></dict></dict>`), &nested); err != nil {
+		t.Error(err.Error())
+	}
+	if !reflect.DeepEqual(nested.Outer.Keys, []string{"b", "a"}) {
+		t.Errorf("Expected keys [b a], received %v", nested.Outer.Keys)
+	}
+}
+
+// unmarshalerDate accepts either a plist date or a real holding seconds since the UNIX epoch.
+type unmarshalerDate struct {
+	time.Time
+}
+
+func (d *unmarshalerDate) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case time.Time:
+		d.Time = v
+	case float64:
+		sec, fsec := math.Modf(v)
+		d.Time = time.Unix(int64(sec), int64(fsec*float64(time.Second))).In(time.UTC)
+	default:
+		return fmt.Errorf("can't use %T as a date", v)
+	}
+	return nil
+}
+
+type unmarshalerInt int
+
+func (i *unmarshalerInt) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	*i = unmarshalerInt(len(s))
+	return nil
+}
+
+func TestUnmarshaler(t *testing.T) {
+	expected := time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)
+	plists := []string{
+		"<date>2013-11-27T00:34:00Z</date>",
+		"<real>1385512440</real>",
+	}
+
+	for _, plist := range plists {
+		var d unmarshalerDate
+		err := NewDecoder(bytes.NewReader([]byte(plist))).Decode(&d)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if !d.Equal(expected) {
+			t.Errorf("Expected %v, received %v.", expected, d.Time)
+		}
+	}
+
+	var s struct {
+		Dates []unmarshalerDate
+		Len   *unmarshalerInt
+	}
+	err := NewDecoder(bytes.NewReader([]byte(`<dict><key>Dates</key><array><real>1385512440</real></array><key>Len</key><string>four</string></dict>`))).Decode(&s)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(s.Dates) != 1 || !s.Dates[0].Equal(expected) || s.Len == nil || *s.Len != 4 {
+		t.Errorf("Received unexpected %#v", s)
+	}
+
+	illegal := []string{
+		"<string>yesterday</string>",
+		"<integer>1</integer>",
+	}
+
+	for _, plist := range illegal {
+		var d struct {
+			D unmarshalerDate
+			I unmarshalerInt
+		}
+		err := NewDecoder(bytes.NewReader([]byte(`<dict><key>D</key>` + plist + `<key>I</key>` + plist + `</dict>`))).Decode(&d)
+		t.Logf("Error: %v", err)
+		if err == nil {
+			t.Error("Expected error, received nothing.")
+		}
+	}
+}
+
+type textUUID [16]byte
+
+func (u textUUID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(u[:4]) + "-" + hex.EncodeToString(u[4:6]) + "-" + hex.EncodeToString(u[6:8]) + "-" + hex.EncodeToString(u[8:10]) + "-" + hex.EncodeToString(u[10:])), nil
+}
+
+func (u *textUUID) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(strings.Replace(string(text), "-", "", -1))
+	if err != nil {
+		return err
+	}
+	if len(b) != len(u) {
+		return fmt.Errorf("invalid UUID length %d", len(b))
+	}
+	copy(u[:], b)
+	return nil
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	type identified struct {
+		ID  textUUID
+		IDs []textUUID
+	}
+	id := textUUID{0x9b, 0x6e, 0x3b, 0x91, 0x77, 0x2c, 0x4d, 0xa9, 0x9e, 0xfc, 0x06, 0xb5, 0x18, 0x46, 0x73, 0x7e}
+	data := identified{ID: id, IDs: []textUUID{id}}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		b, err := Marshal(data, format)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+
+		var str struct{ ID string }
+		Unmarshal(b, &str)
+		if str.ID != "9b6e3b91-772c-4da9-9efc-06b51846737e" {
+			t.Errorf("%s: expected UUID to be encoded as a string, received %q", FormatNames[format], str.ID)
+		}
+
+		var decoded identified
+		if _, err := Unmarshal(b, &decoded); err != nil {
+			t.Error(err.Error())
+		}
+		if !reflect.DeepEqual(data, decoded) {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], data, decoded)
+		}
+	}
+
+	illegal := []string{
+		`<dict><key>ID</key><string>not-a-uuid</string></dict>`,
+		`<dict><key>ID</key><integer>1</integer></dict>`,
+		`<dict><key>ID</key><array><string>9b6e3b91-772c-4da9-9efc-06b51846737e</string></array></dict>`,
+	}
+	for _, plist := range illegal {
+		var decoded identified
+		_, err := Unmarshal([]byte(plist), &decoded)
+		t.Logf("Error: %v", err)
+		if err == nil {
+			t.Error("Expected error, received nothing.")
+		}
+	}
+}
+
+type binaryPoint struct {
+	X, Y uint16
+}
+
+func (p binaryPoint) MarshalBinary() ([]byte, error) {
+	return []byte{byte(p.X >> 8), byte(p.X), byte(p.Y >> 8), byte(p.Y)}, nil
+}
+
+func (p *binaryPoint) UnmarshalBinary(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("invalid point length %d", len(data))
+	}
+	p.X, p.Y = uint16(data[0])<<8|uint16(data[1]), uint16(data[2])<<8|uint16(data[3])
+	return nil
+}
+
+// binaryAndTextPoint prefers to be encoded as text.
+type binaryAndTextPoint struct {
+	binaryPoint
+}
+
+func (p binaryAndTextPoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+}
+
+func (p *binaryAndTextPoint) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d,%d", &p.X, &p.Y)
+	return err
+}
+
+func TestBinaryUnmarshaler(t *testing.T) {
+	type shape struct {
+		Origin   binaryPoint
+		Vertices []*binaryPoint
+		Label    binaryAndTextPoint
+	}
+	data := shape{
+		Origin:   binaryPoint{1, 2},
+		Vertices: []*binaryPoint{{3, 4}, {0x1234, 0xabcd}},
+		Label:    binaryAndTextPoint{binaryPoint{5, 6}},
+	}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+		b, err := Marshal(data, format)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+
+		var raw struct {
+			Origin []byte
+			Label  string
+		}
+		Unmarshal(b, &raw)
+		if !bytes.Equal(raw.Origin, []byte{0, 1, 0, 2}) || raw.Label != "5,6" {
+			t.Errorf("%s: expected the origin as data and the label as a string, received %#v", FormatNames[format], raw)
+		}
+
+		var decoded shape
+		if _, err := Unmarshal(b, &decoded); err != nil {
+			t.Error(err.Error())
+		}
+		if !reflect.DeepEqual(data, decoded) {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], data, decoded)
+		}
+	}
+
+	illegal := []string{
+		`<dict><key>Origin</key><data>AAE=</data></dict>`,
+		`<dict><key>Origin</key><string>AAEAAg==</string></dict>`,
+		`<dict><key>Origin</key><integer>1</integer></dict>`,
+	}
+	for _, plist := range illegal {
+		var decoded shape
+		_, err := Unmarshal([]byte(plist), &decoded)
+		t.Logf("Error: %v", err)
+		if err == nil {
+			t.Error("Expected error, received nothing.")
+		}
+	}
+}
+
+func TestDecodeMapOfStructs(t *testing.T) {
+	type address struct {
+		City string
+	}
+	type person struct {
+		Name    string
+		Age     int
+		Address address
+		Tags    map[string]address
+	}
+
+	people := map[string]person{
+		"alice": {"Alice", 30, address{"Paris"}, map[string]address{"work": {"Lyon"}}},
+		"bob":   {Name: "Bob", Tags: map[string]address{}},
+	}
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		b, err := Marshal(people, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var byValue map[string]person
+		if _, err := Unmarshal(b, &byValue); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(byValue, people) {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], people, byValue)
+		}
+
+		var byPointer map[string]*person
+		if _, err := Unmarshal(b, &byPointer); err != nil {
+			t.Error(err)
+		}
+		if len(byPointer) != len(people) || byPointer["alice"] == byPointer["bob"] {
+			t.Fatalf("%s: expected a distinct pointer for each key, received %v", FormatNames[format], byPointer)
+		}
+		for k, v := range people {
+			if !reflect.DeepEqual(*byPointer[k], v) {
+				t.Errorf("%s: %s: expected %v, received %v", FormatNames[format], k, v, *byPointer[k])
+			}
+		}
+
+		var nested map[string]map[string]*person
+		if _, err := Unmarshal([]byte(xmlPreamble+`<plist><dict><key>group</key><dict><key>carol</key><dict><key>Name</key><string>Carol</string></dict></dict></dict></plist>`), &nested); err != nil {
+			t.Error(err)
+		}
+		if p := nested["group"]["carol"]; p == nil || p.Name != "Carol" {
+			t.Errorf("Expected Carol in a nested map, received %v", nested)
+		}
+	}
+
+	for _, v := range []interface{}{&map[string]person{}, &map[string]*person{}} {
+		_, err := Unmarshal([]byte(`<dict><key>alice</key><string>Alice</string></dict>`), v)
+		if _, ok := err.(*TypeMismatchError); !ok {
+			t.Errorf("%T: expected a type mismatch decoding a string into a struct, received %v", v, err)
+		}
+	}
+}
+
+func TestTypeMismatchError(t *testing.T) {
+	type server struct {
+		Hosts []string
+		Ports [2]int
+	}
+	type config struct {
+		Servers []server
+		Groups  map[string][]string
+	}
+
+	tests := []struct {
+		name, doc, keyPath string
+		expected           reflect.Type
+		got                Kind
+	}{
+		{"dictionary into a slice", `<dict><key>Servers</key><array><dict/><dict><key>Hosts</key><dict/></dict></array></dict>`, "Servers[1].Hosts", reflect.TypeOf([]string{}), Dictionary},
+		{"array into a struct", `<dict><key>Servers</key><array><array/></array></dict>`, "Servers[0]", reflect.TypeOf(server{}), Array},
+		{"string into an array", `<dict><key>Servers</key><array><dict><key>Ports</key><string>80</string></dict></array></dict>`, "Servers[0].Ports", reflect.TypeOf([2]int{}), String},
+		{"map entry", `<dict><key>Groups</key><dict><key>com.example</key><string>admin</string></dict></dict>`, `Groups["com.example"]`, reflect.TypeOf([]string{}), String},
+		{"top level", `<array/>`, "", reflect.TypeOf(config{}), Array},
+	}
+
+	for _, test := range tests {
+		var c config
+		_, err := Unmarshal([]byte(test.doc), &c)
+		mismatch, ok := err.(*TypeMismatchError)
+		if !ok {
+			t.Errorf("%s: expected a TypeMismatchError, received %v", test.name, err)
+			continue
+		}
+		if mismatch.KeyPath != test.keyPath || mismatch.Expected != test.expected || mismatch.Got != test.got {
+			t.Errorf("%s: expected %v into %v at %q, received %v into %v at %q", test.name, test.got, test.expected, test.keyPath, mismatch.Got, mismatch.Expected, mismatch.KeyPath)
+		}
+	}
+
+	// The key path leads to the offending object, and can be given to Get.
+	doc := []byte(`<dict><key>Servers</key><array><dict/><dict><key>Hosts</key><dict/></dict></array></dict>`)
+	var c config
+	_, err := Unmarshal(doc, &c)
+	if !strings.HasSuffix(err.Error(), "at Servers[1].Hosts") {
+		t.Errorf("Expected the error to give the key path, received %v", err)
+	}
+	if v, err := Get(doc, err.(*TypeMismatchError).KeyPath); err != nil || !reflect.DeepEqual(v, map[string]interface{}{}) {
+		t.Errorf("Expected the key path to lead to the dictionary, received %v (%v)", v, err)
+	}
+
+	// A decoder that has failed once reports paths from the top of the next document.
+	dec := NewDecoder(bytes.NewReader(doc))
+	dec.Decode(&c)
+	dec.Reset(bytes.NewReader([]byte(`<dict><key>Groups</key><array/></dict>`)))
+	if err := dec.Decode(&c); err == nil || err.(*TypeMismatchError).KeyPath != "Groups" {
+		t.Errorf("Expected a mismatch at Groups, received %v", err)
+	}
+}
+
+func TestUnmarshalErrorKeyPath(t *testing.T) {
+	type settings struct {
+		Port  uint8
+		When  unmarshalerDate
+		Count unmarshalerInt
+	}
+	type profile struct {
+		PayloadContent []struct {
+			Settings map[string]settings
+		}
+	}
+	nest := func(setting string) string {
+		return `<dict><key>PayloadContent</key><array><dict/><dict><key>Settings</key><dict><key>VPN</key><dict>` + setting + `</dict></dict></dict></array></dict>`
+	}
+
+	var p profile
+	_, err := Unmarshal([]byte(nest(`<key>Port</key><integer>300</integer>`)), &p)
+	var located *UnmarshalError
+	var overflow *integerOverflowError
+	if !errors.As(err, &located) || located.KeyPath != "PayloadContent[1].Settings.VPN.Port" || !errors.As(err, &overflow) {
+		t.Errorf("Expected an overflow at PayloadContent[1].Settings.VPN.Port, received %v", err)
+	} else if err.Error() != "plist: integer 300 overflows value of type uint8 at PayloadContent[1].Settings.VPN.Port" {
+		t.Errorf("Unexpected error message %q", err)
+	}
+
+	// Errors returned by an Unmarshaler are located at the value that implements it.
+	_, err = Unmarshal([]byte(nest(`<key>When</key><string>never</string>`)), &p)
+	if !errors.As(err, &located) || located.KeyPath != "PayloadContent[1].Settings.VPN.When" {
+		t.Errorf("Expected an error at PayloadContent[1].Settings.VPN.When, received %v", err)
+	}
+
+	// Errors from an Unmarshaler's callback keep their own location.
+	_, err = Unmarshal([]byte(nest(`<key>Count</key><dict/>`)), &p)
+	if mismatch, ok := err.(*TypeMismatchError); !ok || mismatch.KeyPath != "PayloadContent[1].Settings.VPN.Count" {
+		t.Errorf("Expected a type mismatch at PayloadContent[1].Settings.VPN.Count, received %v", err)
+	}
+
+	// Paths are given from the top of the document.
+	var content []struct{ Settings map[string]settings }
+	dec := NewDecoder(strings.NewReader(nest(`<key>Port</key><integer>-1</integer>`)))
+	if err := dec.DecodeKey("PayloadContent", &content); !errors.As(err, &located) || located.KeyPath != "PayloadContent[1].Settings.VPN.Port" {
+		t.Errorf("DecodeKey: expected an error at PayloadContent[1].Settings.VPN.Port, received %v", err)
+	}
+
+	stream, err := NewDecoder(strings.NewReader(`<array><dict/><dict><key>Port</key><integer>300</integer></dict></array>`)).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s settings
+	if err := stream.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Decode(&s); !errors.As(err, &located) || located.KeyPath != "[1].Port" {
+		t.Errorf("Stream: expected an error at [1].Port, received %v", err)
+	}
+
+	// Errors at the top level are returned as they are.
+	var port uint8
+	if _, err := Unmarshal([]byte(`<integer>300</integer>`), &port); !errors.As(err, &overflow) || errors.As(err, &located) {
+		t.Errorf("Expected an unwrapped overflow at the top level, received %v", err)
+	}
+}
+
+func TestDecodeInterfaceSlice(t *testing.T) {
+	date := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	doc := []interface{}{
+		"text", 1, -2, 1.5, float32(0.25), true, []byte{1}, date, UID(3), Set{"member"},
+		map[string]interface{}{
+			"list": []interface{}{map[string]interface{}{"deep": []interface{}{"x", 2.5}}},
+		},
+	}
+	data, err := Marshal(doc, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []interface{}
+	if _, err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	types := []reflect.Type{
+		reflect.TypeOf(""), reflect.TypeOf(uint64(0)), reflect.TypeOf(int64(0)), reflect.TypeOf(float64(0)),
+		reflect.TypeOf(float32(0)), reflect.TypeOf(false), reflect.TypeOf([]byte{}), reflect.TypeOf(date),
+		reflect.TypeOf(UID(0)), reflect.TypeOf(Set{}), reflect.TypeOf(map[string]interface{}{}),
+	}
+	if len(decoded) != len(types) {
+		t.Fatalf("Expected %d elements, received %d", len(types), len(decoded))
+	}
+	for i, typ := range types {
+		if got := reflect.TypeOf(decoded[i]); got != typ {
+			t.Errorf("Element %d: expected %v, received %v", i, typ, got)
+		}
+	}
+
+	// Nested collections are generic values too, all the way down.
+	dict, _ := decoded[10].(map[string]interface{})
+	list, _ := dict["list"].([]interface{})
+	if len(list) != 1 {
+		t.Fatalf("Expected a list of one dictionary, received %#v", dict["list"])
+	}
+	inner, _ := list[0].(map[string]interface{})
+	if deep, ok := inner["deep"].([]interface{}); !ok || !reflect.DeepEqual(deep, []interface{}{"x", 2.5}) {
+		t.Errorf("Expected the innermost array as []interface{}{\"x\", 2.5}, received %#v", inner["deep"])
+	}
+	if set := decoded[9].(Set); !reflect.DeepEqual(set, Set{"member"}) {
+		t.Errorf("Expected the set's members as generic values, received %#v", set)
+	}
+}
+
+func TestDecodeIndirectPointers(t *testing.T) {
+	var n **int
+	if _, err := Unmarshal([]byte(`<integer>3</integer>`), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n == nil || *n == nil || **n != 3 {
+		t.Errorf("Expected **int pointing to 3, received %v", n)
+	}
+
+	var strs *[]string
+	if _, err := Unmarshal([]byte(`<array><string>a</string><string>b</string></array>`), &strs); err != nil {
+		t.Fatal(err)
+	}
+	if strs == nil || !reflect.DeepEqual(*strs, []string{"a", "b"}) {
+		t.Errorf("Expected *[]string pointing to [a b], received %v", strs)
+	}
+
+	type sub struct {
+		Name string
+	}
+	existing := &sub{"unchanged"}
+	var outer struct {
+		Sub      **sub
+		Existing **sub
+		Subs     []***sub
+	}
+	outer.Existing = &existing
+	plist := `<dict>
+		<key>Sub</key><dict><key>Name</key><string>sub</string></dict>
+		<key>Existing</key><dict><key>Name</key><string>existing</string></dict>
+		<key>Subs</key><array><dict><key>Name</key><string>first</string></dict></array>
+	</dict>`
+	if _, err := Unmarshal([]byte(plist), &outer); err != nil {
+		t.Fatal(err)
+	}
+	if outer.Sub == nil || *outer.Sub == nil || (*outer.Sub).Name != "sub" {
+		t.Errorf("Expected **sub to be allocated, received %v", outer.Sub)
+	}
+	if existing.Name != "existing" {
+		t.Errorf("Expected the existing pointer to be decoded into, received %v", existing)
+	}
+	if len(outer.Subs) != 1 || (**outer.Subs[0]).Name != "first" {
+		t.Errorf("Expected []***sub to hold one element, received %v", outer.Subs)
+	}
+}
+
+func TestTagKey(t *testing.T) {
+	type jsonTagged struct {
+		Name     string `json:"name"`
+		Count    int    `json:"count,omitempty"`
+		Ignored  string `json:"-"`
+		Fallback string `plist:"fallback"`
+		Both     string `json:"both" plist:"plist-both"`
+	}
+
+	plist := xmlPreamble + `<plist><dict>
+		<key>name</key><string>a</string>
+		<key>count</key><integer>2</integer>
+		<key>Ignored</key><string>ignored</string>
+		<key>fallback</key><string>b</string>
+		<key>both</key><string>c</string>
+		<key>plist-both</key><string>wrong</string>
+	</dict></plist>`
+
+	var decoded jsonTagged
+	if err := NewDecoder(strings.NewReader(plist)).TagKey("json").Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := jsonTagged{Name: "a", Count: 2, Fallback: "b", Both: "c"}
+	if decoded != expected {
+		t.Errorf("Expected %+v, received %+v", expected, decoded)
+	}
+
+	// Without the option, the json tags are not consulted; name and count still match their fields case-insensitively.
+	var plain jsonTagged
+	if _, err := Unmarshal([]byte(plist), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (jsonTagged{Name: "a", Count: 2, Ignored: "ignored", Fallback: "b", Both: "wrong"}); plain != expected {
+		t.Errorf("Expected %+v, received %+v", expected, plain)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.TagKey("json")
+	if err := enc.Encode(jsonTagged{Name: "a", Ignored: "ignored", Fallback: "b", Both: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]string
+	if _, err := Unmarshal(buf.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"name": "a", "fallback": "b", "both": "c"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, received %v", expected, keys)
+	}
+}
+
+func TestCaseInsensitiveFields(t *testing.T) {
+	type record struct {
+		Id     string
+		Name   string `plist:"name"`
+		URL    string
+		Url    string
+		Exact  string
+		Missed string
+	}
+
+	plist := xmlPreamble + `<plist><dict>
+		<key>ID</key><string>id</string>
+		<key>NAME</key><string>name</string>
+		<key>URL</key><string>exact URL</string>
+		<key>exact</key><string>folded</string>
+		<key>Exact</key><string>exact</string>
+		<key>EXACT</key><string>folded again</string>
+		<key>missed</key><string>first</string>
+		<key>MISSED</key><string>last</string>
+	</dict></plist>`
+
+	var decoded record
+	if _, err := Unmarshal([]byte(plist), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	// URL is claimed by the field of that name, so Url doesn't match it case-insensitively.
+	// Of several keys that differ from a field's name only in case, the last is used.
+	expected := record{Id: "id", Name: "name", URL: "exact URL", Exact: "exact", Missed: "last"}
+	if decoded != expected {
+		t.Errorf("Expected %+v, received %+v", expected, decoded)
+	}
+
+	// Keys matched case-insensitively are known fields; the folded duplicates of Exact are not.
+	var strict record
+	dec := NewDecoder(strings.NewReader(plist))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&strict)
+	if err == nil || !strings.Contains(err.Error(), `"exact"`) || !strings.Contains(err.Error(), `"EXACT"`) || !strings.Contains(err.Error(), `"missed"`) ||
+		strings.Contains(err.Error(), `"ID"`) || strings.Contains(err.Error(), `"MISSED"`) {
+		t.Errorf("Expected only the extra spellings of Exact to be unknown, received %v", err)
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	type wanted struct {
+		Name string
+	}
+
+	binaryDoc, err := Marshal(map[string]interface{}{
+		"before": []string{"a", "b"},
+		"wanted": wanted{"found"},
+		"large":  make([]byte, 1000),
+	}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the unwanted data object so that decoding the whole document fails.
+	i := bytes.Index(binaryDoc, []byte{0x4F, 0x11, 0x03, 0xE8})
+	if i == -1 {
+		t.Fatal("Couldn't find the data object")
+	}
+	binaryDoc[i] = 0x70
+	if _, err := Unmarshal(binaryDoc, &map[string]interface{}{}); err == nil {
+		t.Fatal("Expected the corrupted document to fail to decode")
+	}
+
+	xmlDoc := []byte(xmlPreamble + `<plist><dict>
+		<key>before</key><array><integer>not a number</integer><dict><key>nested</key><string>x</string></dict></array>
+		<key>wanted</key><dict><key>Name</key><string>found</string></dict>
+		<key>after</key><integer>not a number either</integer>
+	</dict></plist>`)
+
+	textDoc := []byte(`{before = (a, b); wanted = {Name = found;};}`)
+
+	for _, doc := range [][]byte{binaryDoc, xmlDoc, textDoc} {
+		dec := NewDecoder(bytes.NewReader(doc))
+		var w wanted
+		if err := dec.DecodeKey("wanted", &w); err != nil {
+			t.Errorf("%s: %v", FormatNames[dec.Format], err)
+		} else if w.Name != "found" {
+			t.Errorf("%s: expected found, received %q", FormatNames[dec.Format], w.Name)
+		}
+
+		format := dec.Format
+		dec = NewDecoder(bytes.NewReader(doc))
+		if err := dec.DecodeKey("missing", &w); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+			t.Errorf("%s: expected a missing key error, received %v", FormatNames[format], err)
+		}
+	}
+
+	notDictionaries := [][]byte{
+		[]byte(`<plist><array><string>wanted</string></array></plist>`),
+		[]byte(`<string>wanted</string>`),
+		[]byte(`(wanted)`),
+	}
+	binaryArray, _ := Marshal([]string{"wanted"}, BinaryFormat)
+	notDictionaries = append(notDictionaries, binaryArray)
+	for _, doc := range notDictionaries {
+		var v interface{}
+		if err := NewDecoder(bytes.NewReader(doc)).DecodeKey("wanted", &v); err == nil || !strings.Contains(err.Error(), "not a dictionary") {
+			t.Errorf("%q: expected an error for a non-dictionary, received %v", doc, err)
+		}
+	}
+
+	for _, doc := range []string{
+		`<plist><dict><string>wanted</string></dict></plist>`,
+		`<plist><dict><key>wanted</key></dict></plist>`,
+	} {
+		var v interface{}
+		if _, ok := NewDecoder(strings.NewReader(doc)).DecodeKey("wanted", &v).(*SyntaxError); !ok {
+			t.Errorf("%q: expected a *SyntaxError", doc)
+		}
+	}
+}
+
+func TestLaxDateToEpoch(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 500000000, time.UTC)
+	before := time.Date(1969, 12, 31, 23, 59, 59, 500000000, time.UTC)
+	type stamps struct {
+		Int     int64
+		Uint    uint32
+		Float   float64
+		Float32 float32
+		Before  int64
+		BeforeF float64
+	}
+	source := map[string]time.Time{"Int": when, "Uint": when, "Float": when, "Float32": when, "Before": before, "BeforeF": before}
+
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		data, err := Marshal(source, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded stamps
+		if err := NewDecoder(bytes.NewReader(data)).Lax(true).Decode(&decoded); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		expected := stamps{1577934245, 1577934245, 1577934245.5, 1577934245.5, -1, -0.5}
+		if format == XMLFormat {
+			// XML dates are stored to the second.
+			expected.Float, expected.Float32, expected.BeforeF = 1577934245, 1577934245, -1
+		}
+		if decoded != expected {
+			t.Errorf("%s: expected %+v, received %+v", FormatNames[format], expected, decoded)
+		}
+
+		// Without lax mode, dates only decode into time.Time.
+		var strict stamps
+		if _, err := Unmarshal(data, &strict); err == nil {
+			t.Errorf("%s: expected an error decoding a date into a number", FormatNames[format])
+		}
+	}
+
+	for _, v := range []interface{}{new(int16), new(uint64), new(string)} {
+		if err := NewDecoder(strings.NewReader(`<date>1960-01-01T00:00:00Z</date>`)).Lax(true).Decode(v); err == nil {
+			t.Errorf("%T: expected an error", v)
+		}
+	}
+}
+
+// nonSeekableReader hides any Seek method the wrapped reader might have.
+type nonSeekableReader struct {
+	io.Reader
+}
+
+func TestDecoderReader(t *testing.T) {
+	type readerTest struct {
+		expectedFormat int
+		data           []byte
+	}
+	plists := []readerTest{
+		{BinaryFormat, plistValueTreeAsBplist},
+		{XMLFormat, []byte(plistValueTreeAsXML)},
+		{OpenStepFormat, []byte(plistValueTreeAsOpenStep)},
+		{GNUStepFormat, []byte(plistValueTreeAsGNUStep)},
+	}
+
+	for _, test := range plists {
+		var d EverythingTestData
+		decoder := NewDecoderReader(nonSeekableReader{bytes.NewReader(test.data)})
+		err := decoder.Decode(&d)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if decoder.Format != test.expectedFormat {
+			t.Errorf("Wanted %s, received %s.", FormatNames[test.expectedFormat], FormatNames[decoder.Format])
+		}
+		if !reflect.DeepEqual(d.Strings, plistValueTreeRawData.Strings) || !d.Date.Equal(plistValueTreeRawData.Date) {
+			t.Logf("Expected: %#v", plistValueTreeRawData)
+			t.Logf("Received: %#v", d)
+			t.Fail()
+		}
+	}
+}
+
+type UnknownFieldsEmbedded struct {
+	Embedded string
+}
+
+type unknownFieldsData struct {
+	UnknownFieldsEmbedded
+	Name   string
+	Tagged int `plist:"tagged-key"`
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	known := `<dict><key>Name</key><string>Dustin</string><key>tagged-key</key><integer>1</integer><key>Embedded</key><string>yes</string></dict>`
+	unknown := `<dict><key>Name</key><string>Dustin</string><key>Tagged</key><integer>1</integer><key>Nmae</key><string>typo</string></dict>`
+
+	var d unknownFieldsData
+	if err := NewDecoder(strings.NewReader(unknown)).Decode(&d); err != nil {
+		t.Errorf("Unknown keys should be ignored by default, received %v", err)
+	}
+
+	decoder := NewDecoder(strings.NewReader(known))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&d); err != nil {
+		t.Errorf("Expected no error for known keys, received %v", err)
+	}
+
+	decoder = NewDecoder(strings.NewReader(unknown))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&d)
+	if err == nil {
+		t.Fatal("Expected an error for unknown keys, received nothing.")
+	}
+	t.Logf("Error: %v", err)
+	for _, want := range []string{`"Tagged"`, `"Nmae"`, "unknownFieldsData"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, received %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), `"Name"`) {
+		t.Errorf("Error should not mention known keys, received %v", err)
+	}
+
+	var m map[string]interface{}
+	decoder = NewDecoder(strings.NewReader(unknown))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		t.Errorf("Maps should accept any key, received %v", err)
+	}
+}
+
+func ExampleDecoder_Decode() {
+	type sparseBundleHeader struct {
+		InfoDictionaryVersion string `plist:"CFBundleInfoDictionaryVersion"`
+		BandSize              uint64 `plist:"band-size"`
+		BackingStoreVersion   int    `plist:"bundle-backingstore-version"`
+		DiskImageBundleType   string `plist:"diskimage-bundle-type"`
+		Size                  uint64 `plist:"size"`
+	}
+
+	buf := bytes.NewReader([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<key>CFBundleInfoDictionaryVersion</key>
+		<string>6.0</string>
+		<key>band-size</key>
+		<integer>8388608</integer>
+		<key>bundle-backingstore-version</key>
+		<integer>1</integer>
+		<key>diskimage-bundle-type</key>
+		<string>com.apple.diskimage.sparsebundle</string>
+		<key>size</key>
+		<integer>4398046511104</integer>
+	</dict>
+</plist>`))
+
+	var data sparseBundleHeader
+	decoder := NewDecoder(buf)
+	err := decoder.Decode(&data)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(data)
+
+	// Output: {6.0 8388608 1 com.apple.diskimage.sparsebundle 4398046511104}
+}
+
+func TestValid(t *testing.T) {
+	type validTest struct {
+		name   string
+		data   []byte
+		format int
+		ok     bool
+	}
+	tests := []validTest{
+		{"binary", plistValueTreeAsBplist, BinaryFormat, true},
+		{"XML", []byte(plistValueTreeAsXML), XMLFormat, true},
+		{"OpenStep", []byte(plistValueTreeAsOpenStep), OpenStepFormat, true},
+		{"GNUStep", []byte(plistValueTreeAsGNUStep), GNUStepFormat, true},
+		{"truncated binary", plistValueTreeAsBplist[:len(plistValueTreeAsBplist)-16], InvalidFormat, false},
+		{"truncated XML", []byte(plistValueTreeAsXML[:len(plistValueTreeAsXML)/2]), InvalidFormat, false},
+		{"garbage", []byte("{\x00\xff\xfe(\x01\x02"), InvalidFormat, false},
+		{"empty binary", []byte("bplist00"), InvalidFormat, false},
+	}
+	for _, data := range InvalidBplists {
+		tests = append(tests, validTest{"invalid binary", []byte(data), InvalidFormat, false})
+	}
+
+	for _, test := range tests {
+		format, ok := Valid(test.data)
+		if ok != test.ok || format != test.format {
+			t.Errorf("%s: expected (%s, %v), received (%s, %v)", test.name, FormatNames[test.format], test.ok, FormatNames[format], ok)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"binary", []byte{0x62, 0x70, 0x6c, 0x69, 0x73, 0x74, 0x30, 0x30, 0x24, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x19}},
+		{"XML", []byte(`<plist><dict><key>a</key><integer>zz</integer></dict></plist>`)},
+		{"text", []byte(`{a=b;c=d`)},
+	}
+
+	for _, test := range tests {
+		var v interface{}
+		_, err := Unmarshal(test.data, &v)
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) {
+			t.Errorf("%s: expected a *SyntaxError, received %#v", test.name, err)
+			continue
+		}
+		t.Logf("%s: %v", test.name, err)
+		if syntaxError.Offset <= 0 || syntaxError.Offset > int64(len(test.data)) {
+			t.Errorf("%s: implausible offset %d for %d bytes of input", test.name, syntaxError.Offset, len(test.data))
+		}
+	}
+
+	var v interface{}
+	_, err := Unmarshal([]byte(`{a=b`), &v)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Expected a truncated text property list to wrap io.EOF, received %v", err)
+	}
+}
+
+func TestArrayLengthMismatch(t *testing.T) {
+	data, _ := Marshal([]int{1, 2, 3, 4}, XMLFormat)
+
+	var short [3]int
+	if _, err := Unmarshal(data, &short); err == nil {
+		t.Error("Expected an error decoding 4 values into [3]int, received", short)
+	}
+
+	var long [5]int
+	if _, err := Unmarshal(data, &long); err == nil {
+		t.Error("Expected an error decoding 4 values into [5]int, received", long)
+	}
+
+	short = [3]int{}
+	if err := NewDecoder(bytes.NewReader(data)).Lax(true).Decode(&short); err != nil || short != [3]int{1, 2, 3} {
+		t.Errorf("Expected lax decoding to truncate to [1 2 3], received %v (%v)", short, err)
+	}
+
+	long = [5]int{9, 9, 9, 9, 9}
+	if err := NewDecoder(bytes.NewReader(data)).Lax(true).Decode(&long); err != nil || long != [5]int{1, 2, 3, 4, 0} {
+		t.Errorf("Expected lax decoding to zero-fill to [1 2 3 4 0], received %v (%v)", long, err)
+	}
+
+	var id [4]byte
+	blob, _ := Marshal([]byte{0xde, 0xad, 0xbe, 0xef}, BinaryFormat)
+	if _, err := Unmarshal(blob, &id); err != nil || id != [4]byte{0xde, 0xad, 0xbe, 0xef} {
+		t.Errorf("Expected data to decode into [4]byte, received %v (%v)", id, err)
+	}
+}
+
+type namedBlob []byte
+
+func TestDataDecode(t *testing.T) {
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+		for _, data := range [][]byte{{}, {0xde, 0xad, 0xbe, 0xef}} {
+			encoded, err := Marshal(namedBlob(data), format)
+			if err != nil {
+				t.Errorf("%s: %v", FormatNames[format], err)
+				continue
+			}
+
+			var b []byte
+			var blob namedBlob
+			var i interface{}
+			for _, v := range []interface{}{&b, &blob, &i} {
+				if _, err := Unmarshal(encoded, v); err != nil {
+					t.Errorf("%s: %v", FormatNames[format], err)
+				}
+			}
+
+			if b == nil || !bytes.Equal(b, data) {
+				t.Errorf("%s: expected []byte %#v, received %#v", FormatNames[format], data, b)
+			}
+			if blob == nil || !bytes.Equal(blob, data) {
+				t.Errorf("%s: expected namedBlob %#v, received %#v", FormatNames[format], data, blob)
+			}
+			if ib, ok := i.([]byte); !ok || ib == nil || !bytes.Equal(ib, data) {
+				t.Errorf("%s: expected interface{} holding []byte %#v, received %#v", FormatNames[format], data, i)
+			}
+		}
+	}
+
+	for _, plist := range []string{`<plist><data></data></plist>`, `<plist><data/></plist>`, `<>`} {
+		var b []byte
+		if _, err := Unmarshal([]byte(plist), &b); err != nil || b == nil || len(b) != 0 {
+			t.Errorf("%s: expected empty, non-nil data, received %#v (%v)", plist, b, err)
+		}
+	}
+}
+
+func TestInvalidIntegerMapKeys(t *testing.T) {
+	for _, plist := range []string{`{300=a;}`, `{"-1"=a;}`, `{abc=a;}`} {
+		var m map[uint8]string
+		if _, err := Unmarshal([]byte(plist), &m); err == nil {
+			t.Errorf("%s: expected an error decoding into map[uint8]string, received %v", plist, m)
+		}
+	}
+}
+
+func TestTextMarshalerMapKeys(t *testing.T) {
+	id := textUUID{0x9b, 0x6e, 0x3b, 0x91, 0x77, 0x2c, 0x4d, 0xa9, 0x9e, 0xfc, 0x06, 0xb5, 0x18, 0x46, 0x73, 0x7e}
+	original := map[textUUID]int{id: 1}
+
+	data, err := Marshal(original, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<key>9b6e3b91-772c-4da9-9efc-06b51846737e</key>") {
+		t.Errorf("Expected the key to be encoded as text, received %s", data)
+	}
+
+	var decoded map[textUUID]int
+	if _, err := Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(original, decoded) {
+		t.Errorf("Expected %v, received %v (%v)", original, decoded, err)
+	}
+}
+
+func nestedArrays(depth int) interface{} {
+	var v interface{} = []interface{}{}
+	for i := 1; i < depth; i++ {
+		v = []interface{}{v}
+	}
+	return v
+}
+
+func TestMaxDepth(t *testing.T) {
+	deep := map[string][]byte{
+		"XML":        []byte(`<plist>` + strings.Repeat(`<array>`, defaultMaxDepth+1) + strings.Repeat(`</array>`, defaultMaxDepth+1) + `</plist>`),
+		"OpenStep":   []byte(strings.Repeat(`(`, defaultMaxDepth+1) + strings.Repeat(`)`, defaultMaxDepth+1)),
+		"Dictionary": []byte(strings.Repeat(`{a=`, defaultMaxDepth+1) + strings.Repeat(`;}`, defaultMaxDepth+1)),
+	}
+
+	binaryDeep, err := Marshal(nestedArrays(defaultMaxDepth+1), BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep["Binary"] = binaryDeep
+
+	for name, data := range deep {
+		var v interface{}
+		_, err := Unmarshal(data, &v)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) || !strings.Contains(err.Error(), "nested") {
+			t.Errorf("%s: expected a nesting depth error, received %v", name, err)
+		}
+	}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		data, err := Marshal(nestedArrays(10), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v interface{}
+		if err := NewDecoder(bytes.NewReader(data)).MaxDepth(10).Decode(&v); err != nil {
+			t.Errorf("%s: expected 10 levels to be accepted, received %v", FormatNames[format], err)
+		}
+		if err := NewDecoder(bytes.NewReader(data)).MaxDepth(9).Decode(&v); err == nil {
+			t.Errorf("%s: expected 10 levels to exceed a limit of 9", FormatNames[format])
+		}
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	binaryData, _ := Marshal(map[string]interface{}{"a": 1, "b": []string{"c"}}, BinaryFormat)
+
+	dec := NewDecoder(bytes.NewReader(binaryData)).MaxDepth(5)
+	var first map[string]interface{}
+	if err := dec.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	dec.Reset(strings.NewReader(`(d, e)`))
+	if dec.Format != InvalidFormat {
+		t.Errorf("Expected Reset to clear Format, received %s", FormatNames[dec.Format])
+	}
+	var second []string
+	if err := dec.Decode(&second); err != nil || !reflect.DeepEqual(second, []string{"d", "e"}) {
+		t.Errorf("Expected [d e], received %v (%v)", second, err)
+	}
+	if dec.Format != OpenStepFormat {
+		t.Errorf("Expected OpenStep, received %s", FormatNames[dec.Format])
+	}
+
+	// Lax mode, turned on for the OpenStep document, mustn't leak into the next.
+	dec.Reset(strings.NewReader(`<plist><string>1</string></plist>`))
+	var n int
+	if err := dec.Decode(&n); err == nil {
+		t.Errorf("Expected a type mismatch decoding a string into an int, received %v", n)
+	}
+
+	// Options are kept.
+	dec.Reset(strings.NewReader(`((((((a))))))`))
+	var deep interface{}
+	if err := dec.Decode(&deep); err == nil {
+		t.Error("Expected MaxDepth to survive Reset")
+	}
+}
+
+func TestDecodeUTF8BOM(t *testing.T) {
+	plists := map[string]int{
+		"\xef\xbb\xbf" + xmlPreamble + `<plist version="1.0"><dict><key>a</key><string>b</string></dict></plist>`: XMLFormat,
+		"\xef\xbb\xbf<plist><dict><key>a</key><string>b</string></dict></plist>":                                  XMLFormat,
+		"\xef\xbb\xbf{a = b;}":            OpenStepFormat,
+		"\xef\xbb\xbf{a = b; n = <*I1>;}": GNUStepFormat,
+	}
+
+	for plist, format := range plists {
+		for _, dec := range []*Decoder{NewDecoder(strings.NewReader(plist)), NewDecoderReader(strings.NewReader(plist))} {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); err != nil || dec.Format != format || m["a"] != "b" {
+				t.Errorf("%q: expected %s with a=b, received %s %v (%v)", plist, FormatNames[format], FormatNames[dec.Format], m, err)
+			}
+		}
+	}
+}
+
+type shape interface {
+	Area() float64
+}
+
+type rectangle struct {
+	Width, Height float64
+}
+
+func (r *rectangle) Area() float64 {
+	return r.Width * r.Height
+}
+
+type square struct {
+	Side float64
+}
+
+func (s square) Area() float64 {
+	return s.Side * s.Side
+}
+
+func TestRegisterType(t *testing.T) {
+	plist := `<array>
+		<dict><key>Width</key><real>2</real><key>Height</key><real>3</real></dict>
+		<dict><key>Width</key><real>4</real><key>Height</key><real>5</real></dict>
+	</array>`
+
+	var shapes []shape
+	dec := NewDecoder(strings.NewReader(plist)).RegisterType((*rectangle)(nil))
+	if err := dec.Decode(&shapes); err != nil {
+		t.Fatal(err)
+	}
+	expected := []shape{&rectangle{2, 3}, &rectangle{4, 5}}
+	if !reflect.DeepEqual(shapes, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, shapes)
+	}
+
+	// rectangle itself doesn't implement shape, so registering it alongside square leaves a single match.
+	var sq struct {
+		Shape shape
+	}
+	dec = NewDecoder(strings.NewReader(`<dict><key>Shape</key><dict><key>Side</key><real>2</real></dict></dict>`))
+	dec.RegisterType(rectangle{}).RegisterType(square{})
+	if err := dec.Decode(&sq); err != nil {
+		t.Fatal(err)
+	}
+	if sq.Shape != (square{2}) {
+		t.Errorf("Expected square{2}, received %#v", sq.Shape)
+	}
+
+	// Without a single implementing type, the interface can't be decoded.
+	for _, types := range [][]interface{}{nil, {(*rectangle)(nil), square{}}} {
+		var shapes []shape
+		dec := NewDecoder(strings.NewReader(plist))
+		for _, typ := range types {
+			dec.RegisterType(typ)
+		}
+		if err := dec.Decode(&shapes); err == nil {
+			t.Errorf("Expected an error decoding into []shape with %d registered types, received none", len(types))
+		}
+	}
+
+	// Registered types don't affect empty interfaces.
+	var generic []interface{}
+	dec = NewDecoder(strings.NewReader(plist)).RegisterType((*rectangle)(nil))
+	if err := dec.Decode(&generic); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := generic[0].(map[string]interface{}); !ok {
+		t.Errorf("Expected a generic dictionary, received %#v", generic[0])
+	}
+}
+
+func TestFloat32RoundTrip(t *testing.T) {
+	values := []float32{0.1, 1.0 / 3, 16777217, -2.7182817, math.SmallestNonzeroFloat32, math.MaxFloat32}
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+		for _, f := range values {
+			data, err := Marshal(f, format)
+			if err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			var decoded float32
+			if _, err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			if decoded != f {
+				t.Errorf("%s: expected %v to round-trip, received %v (%s)", FormatNames[format], f, decoded, data)
+			}
+		}
+	}
+
+	// Text formats are written with the shortest decimal that round-trips to the float32, not to its float64 widening.
+	data, err := Marshal(float32(0.1), XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<real>0.1</real>") {
+		t.Errorf("Expected <real>0.1</real>, received %s", data)
+	}
+
+	// Binary property lists store a float32 in four bytes.
+	data, err = Marshal(float32(0.1), BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte{0x22, 0x3d, 0xcc, 0xcc, 0xcd}) {
+		t.Errorf("Expected a 32-bit real, received % x", data)
+	}
+
+	var f float32
+	if _, err := Unmarshal([]byte(`<real>1e39</real>`), &f); err == nil {
+		t.Error("Expected an error decoding 1e39 into a float32")
+	}
+	if err := NewDecoder(strings.NewReader(`<real>1e39</real>`)).Lax(true).Decode(&f); err != nil || !math.IsInf(float64(f), 1) {
+		t.Errorf("Expected +Inf in lax mode, received %v (%v)", f, err)
+	}
+	if _, err := Unmarshal([]byte(`<real>-inf</real>`), &f); err != nil || !math.IsInf(float64(f), -1) {
+		t.Errorf("Expected -Inf, received %v (%v)", f, err)
+	}
+}
+
+// cancellingReader cancels a context once more than limit bytes have been read through it.
+type cancellingReader struct {
+	io.ReadSeeker
+	limit, read int
+	cancel      func()
+}
+
+func (r *cancellingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(b)
+	r.read += n
+	if r.read > r.limit {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestDecodeContext(t *testing.T) {
+	large := make([]interface{}, 20000)
+	for i := range large {
+		large[i] = map[string]interface{}{"Index": i, "Name": "element"}
+	}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		data, err := Marshal(large, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &cancellingReader{ReadSeeker: bytes.NewReader(data), limit: len(data) / 10, cancel: cancel}
+		var v interface{}
+		err = NewDecoder(r).DecodeContext(ctx, &v)
+		if err != context.Canceled {
+			t.Errorf("%s: expected context.Canceled, received %v", FormatNames[format], err)
+		}
+		// The XML parser reads all of an OpenStep property list before rejecting it, so only count the others' reads.
+		if format != OpenStepFormat && r.read > len(data)/2 {
+			t.Errorf("%s: expected decoding to stop soon after cancellation, but %d of %d bytes were read", FormatNames[format], r.read, len(data))
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var v interface{}
+	if err := NewDecoder(strings.NewReader(`<string>a</string>`)).DecodeContext(ctx, &v); err != context.Canceled {
+		t.Errorf("Expected context.Canceled from a cancelled context, received %v", err)
+	}
+
+	// The context doesn't outlive the call.
+	dec := NewDecoder(strings.NewReader(`<string>a</string>`))
+	if err := dec.DecodeContext(context.Background(), &v); err != nil || v != "a" {
+		t.Errorf("Expected a, received %v (%v)", v, err)
+	}
+	if dec.ctx != nil {
+		t.Error("Expected DecodeContext to forget its context")
+	}
+}
+
+func TestDecodeGzip(t *testing.T) {
+	doc := map[string]interface{}{"Name": "compressed", "Count": uint64(3), "List": []interface{}{"a", "b"}}
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		data, err := Marshal(doc, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write(data)
+		gz.Close()
+
+		readers := map[string]*Decoder{
+			"NewDecoder":       NewDecoder(bytes.NewReader(buf.Bytes())),
+			"NewDecoderReader": NewDecoderReader(bytes.NewBuffer(buf.Bytes())),
+		}
+		for name, dec := range readers {
+			var decoded map[string]interface{}
+			if err := dec.Decode(&decoded); err != nil {
+				t.Fatalf("%s %s: %v", name, FormatNames[format], err)
+			}
+			if dec.Format != format {
+				t.Errorf("%s: expected format %s, received %s", name, FormatNames[format], FormatNames[dec.Format])
+			}
+			// OpenStep property lists hold only strings, so only check the others' values.
+			if format != OpenStepFormat && !reflect.DeepEqual(decoded, doc) {
+				t.Errorf("%s %s: expected %v, received %v", name, FormatNames[format], doc, decoded)
+			}
+		}
+	}
+
+	var v interface{}
+	if _, err := Unmarshal([]byte{0x1F, 0x8B, 0x08, 0x00, 0x00}, &v); err == nil {
+		t.Error("Expected an error decoding a truncated gzip stream")
+	}
+}
+
+func TestDecodeGzipSource(t *testing.T) {
+	data, _ := Marshal(map[string]interface{}{"List": []string{"a", "b", "c", "d"}}, XMLFormat)
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write(data)
+	gz.Close()
+
+	// The decompressing reader is only used for one document; the next is read from the start again.
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	for i := 0; i < 2; i++ {
+		var v map[string]interface{}
+		if err := dec.Decode(&v); err != nil || len(v) != 1 {
+			t.Errorf("Decode %d: expected the document, received %v (%v)", i, v, err)
+		}
+	}
+
+	defer func(size int64) { maxDecompressedSize = size }(maxDecompressedSize)
+	maxDecompressedSize = int64(len(data) - 10)
+	var v interface{}
+	if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&v); err == nil {
+		t.Error("Expected an error decoding a property list that decompresses to more than the maximum, received", v)
+	}
+}
+
+func TestDataReader(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	doc := map[string]interface{}{"Name": "blob", "Blob": blob, "Small": []byte("small")}
+
+	type blobs struct {
+		Name  string
+		Blob  DataReader
+		Small []byte
+	}
+	for _, format := range []int{XMLFormat, BinaryFormat, GNUStepFormat} {
+		data, err := Marshal(doc, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded blobs
+		if _, err := Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if decoded.Name != "blob" || !bytes.Equal(decoded.Small, []byte("small")) {
+			t.Errorf("%s: expected the other fields to decode as usual, received %+v", FormatNames[format], decoded)
+		}
+		if decoded.Blob.Reader == nil {
+			t.Fatalf("%s: expected a reader", FormatNames[format])
+		}
+		if format == BinaryFormat {
+			if _, ok := decoded.Blob.Reader.(*io.SectionReader); !ok {
+				t.Errorf("%s: expected the data to be read from the document, received a %T", FormatNames[format], decoded.Blob.Reader)
+			}
+		}
+
+		// Read the data a little at a time.
+		var read []byte
+		chunk := make([]byte, 4096)
+		for {
+			n, err := decoded.Blob.Read(chunk)
+			read = append(read, chunk[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+		}
+		if !bytes.Equal(read, blob) {
+			t.Errorf("%s: expected %d bytes of data, received %d", FormatNames[format], len(blob), len(read))
+		}
+	}
+
+	// The same data object can be read once for each DataReader that holds it.
+	data, err := Marshal([][]byte{blob[:100], blob[:100]}, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var readers []DataReader
+	if _, err := Unmarshal(data, &readers); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range readers {
+		if b, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(b, blob[:100]) {
+			t.Errorf("Expected reader %d to read 100 bytes, received %d (%v)", i, len(b), err)
+		}
+	}
+
+	var r DataReader
+	if _, err := Unmarshal([]byte(`<data>not base64!</data>`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected an error reading malformed base64")
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	documents := map[string]string{
+		"XML":      `<plist><dict><key>A</key><string>first</string><key>B</key><string>b</string><key>A</key><string>last</string></dict></plist>`,
+		"OpenStep": `{A = first; B = b; A = last;}`,
+	}
+	for name, doc := range documents {
+		var od OrderedDict
+		if err := NewDecoder(strings.NewReader(doc)).Decode(&od); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expected := OrderedDict{Keys: []string{"A", "B"}, Values: map[string]interface{}{"A": "last", "B": "b"}}
+		if !reflect.DeepEqual(od, expected) {
+			t.Errorf("%s: expected the last value to win, received %#v", name, od)
+		}
+
+		dec := NewDecoder(strings.NewReader(doc))
+		dec.DisallowDuplicateKeys()
+		var v interface{}
+		err := dec.Decode(&v)
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) || !strings.Contains(err.Error(), `duplicate key "A"`) {
+			t.Errorf("%s: expected a SyntaxError naming the duplicate key, received %v", name, err)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`<plist><dict><key>A</key><string>a</string><key>B</key><dict><key>A</key><string>a</string></dict></dict></plist>`))
+	dec.DisallowDuplicateKeys()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Errorf("Expected a dictionary without duplicates to decode, received %v", err)
+	}
+}
+
+func TestInvalidUnmarshal(t *testing.T) {
+	data := []byte(`<plist><dict><key>A</key><string>a</string></dict></plist>`)
+	invalid := []struct {
+		name string
+		v    interface{}
+		err  string
+	}{
+		{"nil", nil, "plist: Unmarshal(nil)"},
+		{"non-pointer", map[string]string{}, "plist: Unmarshal(non-pointer map[string]string)"},
+		{"struct", struct{ A string }{}, "plist: Unmarshal(non-pointer struct { A string })"},
+		{"typed nil", (*map[string]string)(nil), "plist: Unmarshal(nil *map[string]string)"},
+	}
+	for _, test := range invalid {
+		format, err := Unmarshal(data, test.v)
+		var invalidErr *InvalidUnmarshalError
+		if !errors.As(err, &invalidErr) || err.Error() != test.err {
+			t.Errorf("%s: expected %q, received %v", test.name, test.err, err)
+		}
+		if format != XMLFormat {
+			t.Errorf("%s: expected the format to be detected anyway, received %s", test.name, FormatNames[format])
+		}
+
+		if err := NewDecoder(bytes.NewReader(data)).DecodeKey("A", test.v); !errors.As(err, &invalidErr) {
+			t.Errorf("%s: expected DecodeKey to return an InvalidUnmarshalError, received %v", test.name, err)
+		}
+	}
+
+	// A nil pointer behind the pointer passed in is allocated.
+	var m *map[string]string
+	if _, err := Unmarshal(data, &m); err != nil || m == nil || (*m)["A"] != "a" {
+		t.Errorf("Expected the map to be allocated, received %v (%v)", m, err)
+	}
+
+	// The stream decoder keeps the element for a later call.
+	stream, err := NewDecoder(strings.NewReader(`<plist><array><string>a</string></array></plist>`)).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := stream.Decode(s); !errors.As(err, new(*InvalidUnmarshalError)) {
+		t.Errorf("Expected an InvalidUnmarshalError, received %v", err)
+	}
+	if err := stream.Decode(&s); err != nil || s != "a" {
+		t.Errorf("Expected a, received %q (%v)", s, err)
+	}
+}
+
+func TestDecodeOptionalFields(t *testing.T) {
+	type optional struct {
+		String *string
+		Int    *int
+		Uint   *uint8
+		Float  *float64
+		Bool   *bool
+		Data   *[]byte
+		Date   *time.Time
+		UID    *UID
+	}
+	zero := `<dict>
+		<key>String</key><string></string>
+		<key>Int</key><integer>0</integer>
+		<key>Uint</key><integer>0</integer>
+		<key>Float</key><real>0</real>
+		<key>Bool</key><false/>
+		<key>Data</key><data></data>
+		<key>Date</key><date>0001-01-01T00:00:00Z</date>
+		<key>UID</key><dict><key>CF$UID</key><integer>0</integer></dict>
+	</dict>`
+
+	// Keys that are present allocate their fields, even for zero values.
+	var present optional
+	if _, err := Unmarshal([]byte(zero), &present); err != nil {
+		t.Fatal(err)
+	}
+	rv := reflect.ValueOf(present)
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Field(i)
+		if field.IsNil() {
+			t.Errorf("Expected %s to be allocated", rv.Type().Field(i).Name)
+		} else if elem := field.Elem(); !elem.IsZero() && !(elem.Kind() == reflect.Slice && elem.Len() == 0) {
+			t.Errorf("Expected %s to point to a zero value, received %v", rv.Type().Field(i).Name, field.Elem())
+		}
+	}
+
+	// Keys that are absent leave their fields nil.
+	var missing optional
+	if _, err := Unmarshal([]byte(`<dict/>`), &missing); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(missing, optional{}) {
+		t.Errorf("Expected every field to stay nil, received %+v", missing)
+	}
+
+	// Fields that are already set are left alone when their keys are absent.
+	s := "kept"
+	kept := optional{String: &s}
+	if _, err := Unmarshal([]byte(`<dict><key>Int</key><integer>3</integer></dict>`), &kept); err != nil {
+		t.Fatal(err)
+	}
+	if kept.String != &s || s != "kept" || kept.Int == nil || *kept.Int != 3 {
+		t.Errorf("Expected String to be kept and Int set, received %+v", kept)
+	}
+}
+
+func TestSignedIntegers(t *testing.T) {
+	for _, format := range []int{XMLFormat, BinaryFormat, GNUStepFormat} {
+		data, err := Marshal([]interface{}{-1, 1, uint64(math.MaxUint64)}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var signed, unsigned interface{}
+		if err := NewDecoder(bytes.NewReader(data)).SignedIntegers(true).Decode(&signed); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if expected := []interface{}{int64(-1), int64(1), uint64(math.MaxUint64)}; !reflect.DeepEqual(signed, expected) {
+			t.Errorf("%s: expected %#v, received %#v", FormatNames[format], expected, signed)
+		}
+
+		if err := NewDecoder(bytes.NewReader(data)).Decode(&unsigned); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if expected := []interface{}{int64(-1), uint64(1), uint64(math.MaxUint64)}; !reflect.DeepEqual(unsigned, expected) {
+			t.Errorf("%s: expected %#v by default, received %#v", FormatNames[format], expected, unsigned)
+		}
+	}
 }
@@ -0,0 +1,118 @@
+package plist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A Document is a property list loaded for editing in place. It records the format the property list was read in,
+// and keeps its objects as they were parsed, so that Bytes writes it back in the same format with the same types
+// and dictionary key order, changed only where Set has been called. Whitespace and comments are not preserved.
+type Document struct {
+	// Format is the format the document was loaded from, and the one Bytes writes it in.
+	Format int
+
+	root *Value
+}
+
+// Load parses the property list in data into a Document.
+func Load(data []byte) (*Document, error) {
+	dec := NewDecoder(bytes.NewReader(data))
+	root, err := dec.DecodeValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Format: dec.Format, root: root}, nil
+}
+
+// Get returns the value at the given key path, as it would be decoded into an interface{}.
+// Key paths are written as for the package-level Get function.
+func (d *Document) Get(path string) (interface{}, error) {
+	elements, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	pval, err := lookupPath(d.root, elements)
+	if err != nil {
+		return nil, err
+	}
+	return (&Decoder{}).valueInterface(pval), nil
+}
+
+// Set replaces the value at the given key path with the property list encoding of value, or adds it if the path
+// names a key missing from an existing dictionary. The empty path replaces the whole document.
+// Array indices must be in range; to add elements to an array, Set the array itself.
+//
+// Set returns an error if the path does not lead to an existing dictionary or array member, or wherever Marshal would.
+// A nil value is an error, as property lists cannot represent it.
+func (d *Document) Set(path string, value interface{}) error {
+	elements, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	pval, err := marshalValue(value)
+	if err != nil {
+		return err
+	}
+	if pval == nil {
+		return errors.New("plist: cannot set a nil value")
+	}
+
+	root, err := setPath(d.root, elements, pval, "")
+	if err != nil {
+		return err
+	}
+	d.root = root
+	return nil
+}
+
+// setPath returns a copy of pval with the value at the key path elements replaced. Only the arrays and dictionaries
+// along the path are copied: binary property lists may share objects between several places in the document.
+func setPath(pval *Value, elements []pathElement, replacement *Value, location string) (*Value, error) {
+	if len(elements) == 0 {
+		return replacement, nil
+	}
+	if pval == nil {
+		return nil, fmt.Errorf("plist: no value at %s", pathLocation(location))
+	}
+
+	e := elements[0]
+	switch {
+	case e.isIndex && (pval.kind == Array || pval.kind == CFSet):
+		values := pval.value.([]*Value)
+		if e.index >= len(values) {
+			return nil, fmt.Errorf("plist: index %d out of range for array of length %d at %s", e.index, len(values), pathLocation(location))
+		}
+		subval, err := setPath(values[e.index], elements[1:], replacement, location+e.String())
+		if err != nil {
+			return nil, err
+		}
+		values = append([]*Value(nil), values...)
+		values[e.index] = subval
+		return &Value{pval.kind, values}, nil
+	case !e.isIndex && pval.kind == Dictionary:
+		dict := pval.value.(*dictionary)
+		subval, ok := dict.m[e.key]
+		if !ok && len(elements) > 1 {
+			return nil, fmt.Errorf("plist: key %q not found in dictionary at %s", e.key, pathLocation(location))
+		}
+		subval, err := setPath(subval, elements[1:], replacement, location+e.String())
+		if err != nil {
+			return nil, err
+		}
+		dict.populateArrays()
+		copied := newDictionary()
+		for i, k := range dict.keys {
+			copied.set(k, dict.values[i])
+		}
+		copied.set(e.key, subval)
+		return &Value{Dictionary, copied}, nil
+	}
+	return nil, fmt.Errorf("plist: cannot set %s in %s at %s", e, plistKindNames[pval.kind], pathLocation(location))
+}
+
+// Bytes returns the document encoded in its original format.
+func (d *Document) Bytes() ([]byte, error) {
+	return Marshal(d.root, d.Format)
+}
@@ -0,0 +1,104 @@
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDocument(t *testing.T) {
+	original := map[string]interface{}{
+		"Name":    "app",
+		"Version": 1,
+		"Scale":   1.0,
+		"Servers": []interface{}{
+			map[string]interface{}{"Host": "a.example.com", "Port": 80},
+			map[string]interface{}{"Host": "b.example.com", "Port": 80},
+		},
+	}
+	data, err := Marshal(original, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Format != BinaryFormat {
+		t.Fatalf("Expected a binary document, received %s", FormatNames[doc.Format])
+	}
+	if host, err := doc.Get("Servers[1].Host"); err != nil || host != "b.example.com" {
+		t.Errorf("Expected b.example.com, received %v (%v)", host, err)
+	}
+
+	if err := doc.Set("Servers[1].Port", 8080); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set("Enabled", true); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("bplist00")) {
+		t.Errorf("Expected a binary property list, received %q", out)
+	}
+
+	var decoded map[string]interface{}
+	if _, err := Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"Name":    "app",
+		"Version": uint64(1),
+		"Scale":   1.0,
+		"Enabled": true,
+		"Servers": []interface{}{
+			map[string]interface{}{"Host": "a.example.com", "Port": uint64(80)},
+			map[string]interface{}{"Host": "b.example.com", "Port": uint64(8080)},
+		},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, decoded)
+	}
+
+	// The Port of the first server was the same object as that of the second, and is unchanged.
+	if port, err := doc.Get("Servers[0].Port"); err != nil || port != uint64(80) {
+		t.Errorf("Expected 80, received %v (%v)", port, err)
+	}
+
+	for _, path := range []string{"Servers[2].Port", "Missing.Key", "Name.Key", "Servers.Key", "Servers["} {
+		if err := doc.Set(path, 1); err == nil {
+			t.Errorf("Expected an error setting %s", path)
+		}
+	}
+	if err := doc.Set("Name", nil); err == nil {
+		t.Error("Expected an error setting nil")
+	}
+
+	// XML documents keep their format and key order.
+	doc, err = Load([]byte(`<plist><dict><key>B</key><integer>1</integer><key>A</key><real>2</real></dict></plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set("B", "one"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`<dict><key>B</key><string>one</string><key>A</key><real>2</real></dict>`)) {
+		t.Errorf("Expected B to be replaced in place, received %s", out)
+	}
+
+	if err := doc.Set("", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if root, err := doc.Get(""); err != nil || !reflect.DeepEqual(root, []interface{}{uint64(1)}) {
+		t.Errorf("Expected the root to be replaced, received %v (%v)", root, err)
+	}
+}
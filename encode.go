@@ -3,22 +3,52 @@ package plist
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"runtime"
+	"strings"
 )
 
 type generator interface {
-	generateDocument(*plistValue)
+	generateDocument(*Value)
 	Indent(string)
 }
 
+// Marshaler is the interface implemented by types that can marshal themselves into property list objects.
+// The returned value is marshaled in place of the original value.
+type Marshaler interface {
+	MarshalPlist() (interface{}, error)
+}
+
+// defaultDataWrap is the line length CoreFoundation uses for base64 data in XML property lists.
+const defaultDataWrap = 76
+
 // An Encoder writes a property list to an output stream.
 type Encoder struct {
 	writer io.Writer
 	format int
 
-	indent string
+	indent   string
+	compact  bool
+	dataWrap int
+	tagKey   string
+
+	dateLayout string
+
+	omitDoctype bool
+	stringers   bool
+	streaming   bool
+	durationInt bool
+
+	numbersAsReals bool
+
+	keyNamer func(string) string
+	// renamed holds the typeInfos with the keys keyNamer gives, as they are needed.
+	renamed map[tinfoKey]*typeInfo
+
+	// ptrSeen holds the pointers, maps and slices currently being marshaled, to detect cycles.
+	ptrSeen map[ptrSeenKey]struct{}
 }
 
 // Encode writes the property list encoding of v to the stream.
@@ -28,10 +58,15 @@ func (p *Encoder) Encode(v interface{}) (err error) {
 			if _, ok := r.(runtime.Error); ok {
 				panic(r)
 			}
-			err = r.(error)
+			err = panicError(r)
 		}
 	}()
 
+	if p.streaming && p.format == XMLFormat {
+		p.encodeXMLStream(v)
+		return
+	}
+
 	pval := p.marshal(reflect.ValueOf(v))
 	if pval == nil {
 		panic(errors.New("plist: no root element to encode"))
@@ -40,23 +75,152 @@ func (p *Encoder) Encode(v interface{}) (err error) {
 	var g generator
 	switch p.format {
 	case XMLFormat:
-		g = newXMLPlistGenerator(p.writer)
+		g = p.xmlGenerator()
 	case BinaryFormat, AutomaticFormat:
 		g = newBplistGenerator(p.writer)
 	case OpenStepFormat, GNUStepFormat:
 		g = newTextPlistGenerator(p.writer, p.format)
+	default:
+		panic(fmt.Errorf("plist: unknown format %d", p.format))
+	}
+	if !p.compact {
+		g.Indent(p.indent)
 	}
-	g.Indent(p.indent)
 	g.generateDocument(pval)
 	return
 }
 
+// EncodeN works like Encode, and also returns the number of bytes written to the stream,
+// including any written before an error occurred.
+func (p *Encoder) EncodeN(v interface{}) (int64, error) {
+	w := p.writer
+	counter := &countedWriter{Writer: w}
+	p.writer = counter
+	defer func() { p.writer = w }()
+
+	err := p.Encode(v)
+	return int64(counter.BytesWritten()), err
+}
+
+// EncodeValue writes the property list encoding of v, which must not be nil, to the stream.
+func (p *Encoder) EncodeValue(v *Value) error {
+	return p.Encode(v)
+}
+
+// xmlGenerator returns a generator for XML property lists with the Encoder's settings.
+func (p *Encoder) xmlGenerator() *xmlPlistGenerator {
+	g := newXMLPlistGenerator(p.writer)
+	g.compact = p.compact
+	g.omitDoctype = p.omitDoctype
+	g.dateLayout = p.dateLayout
+	if !p.compact {
+		g.dataWrap = p.dataWrap
+		g.Indent(p.indent)
+	}
+	return g
+}
+
+// encodeXMLStream writes v as an XML property list while walking it, for StreamXML.
+func (p *Encoder) encodeXMLStream(v interface{}) {
+	g := p.xmlGenerator()
+	opened := false
+	p.streamValue(g, reflect.ValueOf(v), func() {
+		opened = true
+		g.openDocument()
+	})
+	if !opened {
+		panic(errors.New("plist: no root element to encode"))
+	}
+	g.closeDocument()
+}
+
+// StreamXML makes the Encoder write XML property lists as it walks the value being encoded, rather than first
+// building the whole property list in memory, which reduces the memory needed to encode large arrays and dictionaries.
+// The output is the same, but an error, such as a value that cannot be encoded, may be reported after part of
+// the property list has been written. Other formats are not affected.
+func (p *Encoder) StreamXML(stream bool) {
+	p.streaming = stream
+}
+
 // Indent turns on pretty-printing for the XML and Text property list formats.
 // Each element begins on a new line and is preceded by one or more copies of indent according to its nesting depth.
+// As in the output of plutil -convert xml1, the top-level value of an XML property list is not indented within
+// the <plist> element, so its keys and values are indented once.
 func (p *Encoder) Indent(indent string) {
 	p.indent = indent
 }
 
+// Reset discards the Encoder's state and makes it write property lists to w in the specified format,
+// as if it had been created by NewEncoderForFormat. The settings made by Indent, Compact, OmitDoctype, EncodeStringers, EncodeDurationsAsIntegers, NumbersAsReals, WrapData, DateLayout, StreamXML, TagKey and KeyNamer are kept.
+func (p *Encoder) Reset(w io.Writer, format int) {
+	p.writer = w
+	p.format = format
+	p.ptrSeen = nil
+}
+
+// Compact turns on compact output: no whitespace is written between elements, even if an indent has been set with Indent.
+// XML property lists also omit the line breaks after the XML declaration and DOCTYPE, but remain complete documents.
+func (p *Encoder) Compact(compact bool) {
+	p.compact = compact
+}
+
+// OmitDoctype leaves the DOCTYPE declaration out of XML property lists, for consumers whose XML parsers reject it.
+// The XML declaration and the <plist version="1.0"> root element are still written.
+func (p *Encoder) OmitDoctype(omit bool) {
+	p.omitDoctype = omit
+}
+
+// EncodeStringers makes the Encoder write values that implement fmt.Stringer as the strings returned by
+// their String methods, when they can't be encoded otherwise: structs without any fields to encode,
+// and values of kinds such as channels that would be rejected. Marshalers and reflection come first,
+// so a named integer with a String method is still encoded as an integer. This is off by default,
+// as the String methods of many types are meant for display rather than for storage.
+func (p *Encoder) EncodeStringers(enable bool) {
+	p.stringers = enable
+}
+
+// EncodeDurationsAsIntegers makes the Encoder write time.Duration values as integers of nanoseconds
+// instead of strings such as "1m30s". Both forms decode back into a time.Duration.
+func (p *Encoder) EncodeDurationsAsIntegers(enable bool) {
+	p.durationInt = enable
+}
+
+// NumbersAsReals makes the Encoder write every integer as a real, for consumers that cannot tell integers and reals
+// apart and fail when they are mixed. This applies to Go integers of all sizes, Numbers holding integers,
+// and durations encoded as integers; UIDs are still written as UIDs.
+// Reals hold integers exactly only up to 2^53 in magnitude: larger integers are rounded to the nearest real.
+func (p *Encoder) NumbersAsReals(enable bool) {
+	p.numbersAsReals = enable
+}
+
+// WrapData sets the number of base64 characters per line in the data elements of XML property lists.
+// Data too long for one line is split across several, which are indented to the element's depth if an indent has been set.
+// The default of 76 characters matches CoreFoundation. A width of zero turns wrapping off, as does Compact.
+func (p *Encoder) WrapData(width int) {
+	p.dataWrap = width
+}
+
+// DateLayout makes the Encoder write the dates in XML property lists in layout, as understood by time.Time.Format,
+// instead of RFC 3339. Dates are converted to UTC first. Other property list formats are not affected.
+// Most readers accept only RFC 3339 dates; use a Decoder with the same DateLayout to read the result back.
+func (p *Encoder) DateLayout(layout string) {
+	p.dateLayout = layout
+}
+
+// TagKey makes the Encoder read struct field names and flags from the struct tag under key,
+// such as "json", instead of "plist". Fields without a tag under key fall back to their plist tags.
+func (p *Encoder) TagKey(key string) {
+	p.tagKey = key
+}
+
+// KeyNamer makes the Encoder store struct fields without a key in their tags under the key namer returns for the
+// field's name, such as a snake_case or lowerCamelCase form of it, rather than the name itself.
+// Keys given by tags are used as they are. A nil namer restores the default.
+func (p *Encoder) KeyNamer(namer func(fieldName string) string) {
+	p.keyNamer = namer
+	p.renamed = nil
+}
+
 // NewEncoder returns an Encoder that writes an XML property list to w.
 func NewEncoder(w io.Writer) *Encoder {
 	return NewEncoderForFormat(w, XMLFormat)
@@ -64,10 +228,12 @@ func NewEncoder(w io.Writer) *Encoder {
 
 // NewEncoderForFormat returns an Encoder that writes a property list to w in the specified format.
 // Pass AutomaticFormat to allow the library to choose the best encoding (currently BinaryFormat).
+// If format is not one of the format constants, Encode returns an error without writing anything.
 func NewEncoderForFormat(w io.Writer, format int) *Encoder {
 	return &Encoder{
-		writer: w,
-		format: format,
+		writer:   w,
+		format:   format,
+		dataWrap: defaultDataWrap,
 	}
 }
 
@@ -88,10 +254,29 @@ func NewBinaryEncoder(w io.Writer) *Encoder {
 // Strings bearing non-ASCII runes will be encoded differently depending upon the property list format:
 // UTF-8 for XML property lists and UTF-16 for binary property lists.
 //
+// Infinite and NaN floating-point values are encoded as their IEEE 754 bit patterns in binary property lists,
+// as inf, -inf and nan in XML property lists, and as +Inf, -Inf and NaN in text property lists; all of them decode back unchanged.
+//
+// time.Time values are encoded as dates, normalized to UTC. time.Duration values are encoded as strings such as "1m30s",
+// the form time.ParseDuration accepts, unless an Encoder is told to write them as integers of nanoseconds instead.
+// url.URL values are encoded as the strings returned by their String methods.
+//
+// Null values are encoded as null objects in binary property lists. The other formats cannot represent them:
+// they are omitted from arrays and dictionaries, as nil values are.
+//
+// OpenStep property lists have no typed values: integers and reals are written bare, booleans as 1 and 0,
+// and dates as quoted strings of the form "2006-01-02 15:04:05 -0700". Unmarshal converts them back in lax mode.
+// GNUStep property lists retain the types using the <*I>, <*R>, <*B> and <*D> extensions.
+// Strings are quoted only when they contain characters outside the format's unquoted set.
+//
+// UID values are encoded as UIDs in binary property lists, and as dictionaries
+// containing a single "CF$UID" integer in all other formats.
+//
 // Slice and Array values are encoded as property list arrays, except for
-// []byte values, which are encoded as data.
+// []byte values, which are encoded as data. Byte arrays ([N]byte) are encoded as arrays of integers.
 //
-// Map values encode as dictionaries. The map's key type must be string; there is no provision for encoding non-string dictionary keys.
+// Map values encode as dictionaries. The map's key type must be a string or integer type, or implement encoding.TextMarshaler:
+// integer keys are written in decimal, and TextMarshaler keys as the text they marshal to.
 //
 // Struct values are encoded as dictionaries, with only exported fields being serialized. Struct field encoding may be influenced with the use of tags.
 // The tag format is:
@@ -102,13 +287,35 @@ func NewBinaryEncoder(w io.Writer) *Encoder {
 //
 //     omitempty    Only include the field if it is not set to the zero value for its type.
 //
-// If the key is "-", the field is ignored.
+// If the key is "-", the field is ignored. A field may be stored under the key "-" by using the tag `plist:"-,"`.
 //
-// Anonymous struct fields are encoded as if their exported fields were exposed via the outer struct.
+// A struct that contains a blank field tagged `plist:",positional"` is encoded as an array of its fields' values,
+// in the order the fields are declared, for property lists that store fixed-shape records as arrays. Keys and the
+// omitempty flag have no effect on such a struct, and Marshal returns an error if any of its fields encodes to nothing,
+// such as a nil pointer, as the fields after it would shift into its place.
 //
-// Pointer values encode as the value pointed to.
+// Anonymous struct fields are encoded as if their exported fields were exposed via the outer struct,
+// unless they are given a name by a tag. When several fields share a key, Go's visibility rules apply:
+// the least deeply nested field wins, then a tagged field over untagged ones; otherwise all of them are ignored.
+//
+// If a value implements Marshaler, Marshal calls its MarshalPlist method and encodes the returned value in its place.
+// Otherwise, a value implementing encoding.TextMarshaler is encoded as the string returned by its MarshalText method,
+// and failing that, a value implementing encoding.BinaryMarshaler as data holding the bytes returned by MarshalBinary.
+// Only values implementing none of these interfaces are encoded as described above.
+// If EncodeStringers is enabled on the Encoder, structs without any fields to encode and values of unsupported kinds
+// are encoded as the strings returned by String instead, if they implement fmt.Stringer.
+//
+// Pointer values encode as the value pointed to. Values encode as the property list objects they hold.
+//
+// Nil slices and maps encode as empty arrays and dictionaries, or are left out by omitempty.
+// Nil pointers and interfaces encode as nothing at all: they are left out of the arrays, dictionaries and structs
+// that contain them, and Marshal returns an error if v itself is nil. Property lists have no null object except in
+// the binary format; to write one there, encode a Null.
 //
 // Channel, complex and function values cannot be encoded. Any attempt to do so causes Marshal to return an error.
+//
+// Property lists cannot represent cyclic data structures: if v refers to itself through a pointer, map or slice,
+// Marshal returns an UnsupportedValueError instead of recursing forever.
 func Marshal(v interface{}, format int) ([]byte, error) {
 	return MarshalIndent(v, format, "")
 }
@@ -124,3 +331,40 @@ func MarshalIndent(v interface{}, format int, indent string) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// MarshalString works like Marshal, but returns the property list encoding of v as a string.
+func MarshalString(v interface{}, format int) (string, error) {
+	buf := &strings.Builder{}
+	if err := NewEncoderForFormat(buf, format).Encode(v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ToGeneric returns the value that decoding the property list encoding of v into an interface{} would produce,
+// without encoding it: one of the types listed in the documentation for Unmarshal, with arrays as []interface{}
+// and dictionaries as map[string]interface{}. Integers become uint64, or int64 if they are negative; structs, maps
+// and OrderedDicts become dictionaries; and nil values are dropped from arrays and dictionaries, as they are by Marshal.
+//
+// A nil v returns nil. ToGeneric returns an error wherever Marshal would.
+func ToGeneric(v interface{}) (interface{}, error) {
+	pval, err := marshalValue(v)
+	if err != nil || pval == nil {
+		return nil, err
+	}
+	return (&Decoder{}).valueInterface(pval), nil
+}
+
+// marshalValue marshals v into a property list object.
+func marshalValue(v interface{}) (pval *Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = panicError(r)
+		}
+	}()
+
+	return (&Encoder{}).marshal(reflect.ValueOf(v)), nil
+}
@@ -2,8 +2,17 @@ package plist
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func BenchmarkXMLEncode(b *testing.B) {
@@ -84,6 +93,230 @@ func TestEncode(t *testing.T) {
 	}
 }
 
+type marshalerVersion struct {
+	Major, Minor, Patch int
+}
+
+func (v marshalerVersion) MarshalPlist() (interface{}, error) {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch), nil
+}
+
+type marshalerColor struct {
+	R, G, B, A uint8
+}
+
+func (c *marshalerColor) MarshalPlist() (interface{}, error) {
+	return map[string]interface{}{
+		"rgb":   []uint8{c.R, c.G, c.B},
+		"alpha": c.A,
+	}, nil
+}
+
+type marshalerWrapper struct {
+	Value interface{}
+}
+
+func (w marshalerWrapper) MarshalPlist() (interface{}, error) {
+	return []interface{}{w.Value}, nil
+}
+
+type marshalerFailure struct{}
+
+func (marshalerFailure) MarshalPlist() (interface{}, error) {
+	return nil, errors.New("marshalerFailure always fails")
+}
+
+func TestMarshaler(t *testing.T) {
+	type tagged struct {
+		Version marshalerVersion
+		Color   marshalerColor
+		Missing *marshalerVersion
+	}
+
+	tests := []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"Value receiver", marshalerVersion{1, 2, 3}, `<string>1.2.3</string>`},
+		{"Pointer receiver", &marshalerColor{1, 2, 3, 4}, `<dict><key>alpha</key><integer>4</integer><key>rgb</key><data>AQID</data></dict>`},
+		{"Inside an interface slice", []interface{}{marshalerVersion{4, 5, 6}}, `<array><string>4.5.6</string></array>`},
+		{"Nested marshalers", marshalerWrapper{marshalerVersion{7, 8, 9}}, `<array><string>7.8.9</string></array>`},
+		{"Struct fields", &tagged{Version: marshalerVersion{1, 0, 0}, Color: marshalerColor{A: 255}}, `<dict><key>Color</key><dict><key>alpha</key><integer>255</integer><key>rgb</key><data>AAAA</data></dict><key>Version</key><string>1.0.0</string></dict>`},
+	}
+
+	for _, test := range tests {
+		out, err := Marshal(test.data, XMLFormat)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		expected := xmlPreamble + `<plist version="1.0">` + test.expected + `</plist>`
+		if string(out) != expected {
+			t.Errorf("%s:\nExpected: %s\nReceived: %s", test.name, expected, out)
+		}
+	}
+
+	_, err := Marshal(marshalerFailure{}, XMLFormat)
+	t.Logf("Error: %v", err)
+	if err == nil {
+		t.Error("Expected error, received nothing.")
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	type person struct {
+		Name     string            `plist:"name"`
+		Nickname string            `plist:"nickname,omitempty"`
+		Tags     map[string]string `plist:"tags,omitempty"`
+		Friends  []string          `plist:",omitempty"`
+	}
+
+	tests := []struct {
+		data     person
+		expected string
+	}{
+		{person{Name: "Dustin"}, `<dict><key>name</key><string>Dustin</string></dict>`},
+		{person{Name: "Dustin", Tags: map[string]string{}, Friends: []string{}}, `<dict><key>name</key><string>Dustin</string></dict>`},
+		{person{Name: "Dustin", Nickname: "DH"}, `<dict><key>name</key><string>Dustin</string><key>nickname</key><string>DH</string></dict>`},
+		{person{Name: "", Friends: []string{"Nobody"}}, `<dict><key>Friends</key><array><string>Nobody</string></array><key>name</key><string></string></dict>`},
+	}
+
+	for _, test := range tests {
+		out, err := Marshal(test.data, XMLFormat)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		expected := xmlPreamble + `<plist version="1.0">` + test.expected + `</plist>`
+		if string(out) != expected {
+			t.Errorf("Expected: %s\nReceived: %s", expected, out)
+		}
+	}
+}
+
+type ignoredFieldData struct {
+	Name  string
+	Cache string `plist:"-"`
+	Dash  string `plist:"-,"`
+}
+
+func TestIgnoredField(t *testing.T) {
+	data := ignoredFieldData{Name: "Dustin", Cache: "secret", Dash: "dash"}
+	expected := xmlPreamble + `<plist version="1.0"><dict><key>-</key><string>dash</string><key>Name</key><string>Dustin</string></dict></plist>`
+
+	out, err := Marshal(data, XMLFormat)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if string(out) != expected {
+		t.Errorf("Expected: %s\nReceived: %s", expected, out)
+	}
+
+	var decoded ignoredFieldData
+	_, err = Unmarshal([]byte(`<dict><key>Name</key><string>Dustin</string><key>Cache</key><string>secret</string><key>-</key><string>dash</string></dict>`), &decoded)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if decoded != (ignoredFieldData{Name: "Dustin", Dash: "dash"}) {
+		t.Errorf("Received unexpected %#v", decoded)
+	}
+}
+
+func TestMarshalIndent(t *testing.T) {
+	data := map[string]interface{}{
+		"a": []interface{}{1, map[string]interface{}{"b": true}, []int{}},
+		"c": map[string]int{},
+		"d": "s",
+	}
+	expected := map[int]string{
+		XMLFormat: xmlPreamble + `<plist version="1.0">
+<dict>
+	<key>a</key>
+	<array>
+		<integer>1</integer>
+		<dict>
+			<key>b</key>
+			<true></true>
+		</dict>
+		<array></array>
+	</array>
+	<key>c</key>
+	<dict></dict>
+	<key>d</key>
+	<string>s</string>
+</dict>
+</plist>`,
+		OpenStepFormat: `{
+	a = (
+		1,
+		{
+			b = 1;
+		},
+		(
+		),
+	);
+	c = {
+	};
+	d = s;
+}`,
+	}
+
+	for format, golden := range expected {
+		out, err := MarshalIndent(data, format, "\t")
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if string(out) != golden {
+			t.Errorf("%s:\nExpected: %s\nReceived: %s", FormatNames[format], golden, out)
+		}
+	}
+
+	// Binary property lists have nothing to indent.
+	unindented, _ := Marshal(data, BinaryFormat)
+	indented, _ := MarshalIndent(data, BinaryFormat, "\t")
+	if !bytes.Equal(unindented, indented) {
+		t.Errorf("Expected indentation to be ignored for binary property lists.")
+	}
+}
+
+func TestMapKeyOrderIsDeterministic(t *testing.T) {
+	data := map[string]interface{}{}
+	for i := 0; i < 32; i++ {
+		data[fmt.Sprintf("key%02d", 31-i)] = map[string]int{
+			fmt.Sprintf("z%d", i): i,
+			fmt.Sprintf("a%d", i): i,
+		}
+	}
+
+	for _, format := range []int{BinaryFormat, XMLFormat, OpenStepFormat} {
+		first, err := Marshal(data, format)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		for i := 0; i < 10; i++ {
+			again, _ := Marshal(data, format)
+			if !bytes.Equal(first, again) {
+				t.Errorf("%s: encoding the same map twice yielded different output", FormatNames[format])
+				break
+			}
+		}
+	}
+
+	out, _ := Marshal(map[string]interface{}{"c": 1, "a": map[string]int{"y": 1, "x": 2}, "b": 3}, XMLFormat)
+	expected := xmlPreamble + `<plist version="1.0"><dict><key>a</key><dict><key>x</key><integer>2</integer><key>y</key><integer>1</integer></dict><key>b</key><integer>3</integer><key>c</key><integer>1</integer></dict></plist>`
+	if string(out) != expected {
+		t.Errorf("Expected: %s\nReceived: %s", expected, out)
+	}
+
+	out, _ = Marshal(map[string]int{"c": 1, "a": 2, "b": 3}, BinaryFormat)
+	var od OrderedDict
+	Unmarshal(out, &od)
+	if !reflect.DeepEqual(od.Keys, []string{"a", "b", "c"}) {
+		t.Errorf("Expected binary keys in sorted order, received %v", od.Keys)
+	}
+}
+
 func ExampleEncoder_Encode() {
 	type sparseBundleHeader struct {
 		InfoDictionaryVersion string `plist:"CFBundleInfoDictionaryVersion"`
@@ -138,18 +371,18 @@ func ExampleMarshal_xml() {
 	// Output: <?xml version="1.0" encoding="UTF-8"?>
 	// <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 	// <plist version="1.0">
-	// 	<dict>
-	// 		<key>CFBundleInfoDictionaryVersion</key>
-	// 		<string>6.0</string>
-	// 		<key>band-size</key>
-	// 		<integer>8388608</integer>
-	// 		<key>bundle-backingstore-version</key>
-	// 		<integer>1</integer>
-	// 		<key>diskimage-bundle-type</key>
-	// 		<string>com.apple.diskimage.sparsebundle</string>
-	// 		<key>size</key>
-	// 		<integer>4398046511104</integer>
-	// 	</dict>
+	// <dict>
+	// 	<key>CFBundleInfoDictionaryVersion</key>
+	// 	<string>6.0</string>
+	// 	<key>band-size</key>
+	// 	<integer>8388608</integer>
+	// 	<key>bundle-backingstore-version</key>
+	// 	<integer>1</integer>
+	// 	<key>diskimage-bundle-type</key>
+	// 	<string>com.apple.diskimage.sparsebundle</string>
+	// 	<key>size</key>
+	// 	<integer>4398046511104</integer>
+	// </dict>
 	// </plist>
 }
 
@@ -183,3 +416,926 @@ func ExampleMarshal_gnustep() {
 	// 	size = <*I4398046511104>;
 	// }
 }
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	type roundTrip struct {
+		Name    string
+		Count   int
+		Ratio   float64
+		Enabled bool
+		Blob    []byte
+		Tags    []string
+	}
+	original := roundTrip{"Dustin", 42, 0.5, true, []byte{1, 2, 3}, []string{"a", "b"}}
+
+	for _, format := range []int{BinaryFormat, XMLFormat} {
+		data, err := Marshal(original, format)
+		if err != nil {
+			t.Errorf("%s: %v", FormatNames[format], err)
+			continue
+		}
+
+		var decoded roundTrip
+		detected, err := Unmarshal(data, &decoded)
+		if err != nil {
+			t.Errorf("%s: %v", FormatNames[format], err)
+			continue
+		}
+
+		if detected != format {
+			t.Errorf("Wanted %s, received %s.", FormatNames[format], FormatNames[detected])
+		}
+
+		if !reflect.DeepEqual(original, decoded) {
+			t.Errorf("%s: expected %#v, received %#v", FormatNames[format], original, decoded)
+		}
+	}
+}
+
+func TestMarshalUnknownFormat(t *testing.T) {
+	_, err := Marshal("hello", 99)
+	if err == nil {
+		t.Error("Expected an error marshaling to an unknown format, received nothing.")
+	}
+}
+
+func TestEncoderFormats(t *testing.T) {
+	value := map[string]interface{}{"a": 1}
+	tests := []struct {
+		name   string
+		new    func(w io.Writer) *Encoder
+		prefix string
+	}{
+		{"NewEncoder", NewEncoder, "<?xml"},
+		{"NewBinaryEncoder", NewBinaryEncoder, "bplist00"},
+		{"XMLFormat", func(w io.Writer) *Encoder { return NewEncoderForFormat(w, XMLFormat) }, "<?xml"},
+		{"BinaryFormat", func(w io.Writer) *Encoder { return NewEncoderForFormat(w, BinaryFormat) }, "bplist00"},
+		{"AutomaticFormat", func(w io.Writer) *Encoder { return NewEncoderForFormat(w, AutomaticFormat) }, "bplist00"},
+		{"OpenStepFormat", func(w io.Writer) *Encoder { return NewEncoderForFormat(w, OpenStepFormat) }, "{a=1;}"},
+		{"GNUStepFormat", func(w io.Writer) *Encoder { return NewEncoderForFormat(w, GNUStepFormat) }, "{a=<*I1>;}"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := test.new(&buf).Encode(value); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !strings.HasPrefix(buf.String(), test.prefix) {
+			t.Errorf("%s: expected output beginning with %q, received %q", test.name, test.prefix, buf.String())
+		}
+	}
+
+	for _, format := range []int{-1, 5, 99} {
+		var buf bytes.Buffer
+		if err := NewEncoderForFormat(&buf, format).Encode(value); err == nil {
+			t.Errorf("Format %d: expected an error", format)
+		} else if buf.Len() != 0 {
+			t.Errorf("Format %d: expected nothing to be written, received %q", format, buf.String())
+		}
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	_, err := Marshal(map[string]interface{}{"channel": make(chan int)}, XMLFormat)
+	var unsupported *UnsupportedTypeError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Expected an *UnsupportedTypeError, received %#v", err)
+	}
+	if unsupported.Type != reflect.TypeOf(make(chan int)) {
+		t.Errorf("Expected the error to name chan int, received %v", unsupported.Type)
+	}
+
+	unsupportedFields := []interface{}{
+		struct{ F func() }{},
+		struct{ F func() }{func() {}},
+		struct {
+			F func() `plist:",omitempty"`
+		}{func() {}},
+		struct{ C chan int }{},
+		struct{ C complex64 }{},
+		struct{ C complex128 }{1i},
+		struct{ P unsafe.Pointer }{},
+		struct{ I interface{} }{func() {}},
+	}
+	for _, v := range unsupportedFields {
+		for _, format := range []int{XMLFormat, BinaryFormat} {
+			_, err := Marshal(v, format)
+			if !errors.As(err, &unsupported) {
+				t.Errorf("%T: expected an *UnsupportedTypeError, received %#v", v, err)
+			}
+		}
+	}
+
+	// Unsupported fields that are nil are left out if they're marked omitempty.
+	omitted := struct {
+		F func()         `plist:",omitempty"`
+		C chan int       `plist:",omitempty"`
+		P unsafe.Pointer `plist:",omitempty"`
+		S string
+	}{S: "kept"}
+	data, err := Marshal(omitted, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<dict><key>S</key><string>kept</string></dict>") {
+		t.Errorf("Expected only S to be encoded, received %s", data)
+	}
+}
+
+type embeddedPromoted struct {
+	Promoted string
+	Shadowed string
+}
+
+type EmbeddedTagged struct {
+	Inner string
+}
+
+type EmbeddedPointer struct {
+	Pointed string
+}
+
+type EmbeddedLeft struct {
+	Ambiguous string
+	Preferred string
+}
+
+type EmbeddedRight struct {
+	Ambiguous string
+	Preferred string `plist:"Preferred"`
+}
+
+type embeddingData struct {
+	embeddedPromoted
+	EmbeddedTagged `plist:"tagged"`
+	*EmbeddedPointer
+	EmbeddedLeft
+	EmbeddedRight
+	Shadowed string
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	data := embeddingData{
+		embeddedPromoted: embeddedPromoted{"promoted", "hidden"},
+		EmbeddedTagged:   EmbeddedTagged{"inner"},
+		EmbeddedLeft:     EmbeddedLeft{"left", "untagged"},
+		EmbeddedRight:    EmbeddedRight{"right", "tagged"},
+		Shadowed:         "outer",
+	}
+
+	out, err := Marshal(data, OpenStepFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ambiguous is dropped: both candidates are untagged and equally deep.
+	expected := `{Preferred=tagged;Promoted=promoted;Shadowed=outer;tagged={Inner=inner;};}`
+	if string(out) != expected {
+		t.Errorf("Expected %s, received %s", expected, out)
+	}
+
+	var decoded embeddingData
+	if _, err := Unmarshal([]byte(`{Preferred=a;Promoted=b;Shadowed=c;tagged={Inner=d;};Pointed=e;Ambiguous=f;}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Promoted != "b" || decoded.embeddedPromoted.Shadowed != "" || decoded.Shadowed != "c" {
+		t.Errorf("Promoted or shadowed fields decoded incorrectly: %#v", decoded)
+	}
+	if decoded.EmbeddedRight.Preferred != "a" || decoded.EmbeddedLeft.Preferred != "" {
+		t.Errorf("Expected the tagged field to win, received %#v", decoded)
+	}
+	if decoded.EmbeddedLeft.Ambiguous != "" || decoded.EmbeddedRight.Ambiguous != "" {
+		t.Errorf("Expected ambiguous fields to be ignored, received %#v", decoded)
+	}
+	if decoded.Inner != "d" {
+		t.Errorf("Expected the tagged embedded struct to decode as a nested dictionary, received %#v", decoded)
+	}
+	if decoded.EmbeddedPointer == nil || decoded.Pointed != "e" {
+		t.Errorf("Expected the embedded pointer to be allocated, received %#v", decoded.EmbeddedPointer)
+	}
+}
+
+type cyclicNode struct {
+	Name     string
+	Children []*cyclicNode
+}
+
+func TestCycleDetection(t *testing.T) {
+	root := &cyclicNode{Name: "root"}
+	root.Children = []*cyclicNode{{Name: "child"}, root}
+
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+
+	cyclicSlice := []interface{}{nil}
+	cyclicSlice[0] = cyclicSlice
+
+	for _, v := range []interface{}{root, cyclicMap, cyclicSlice} {
+		_, err := Marshal(v, BinaryFormat)
+		var unsupported *UnsupportedValueError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("Expected an *UnsupportedValueError, received %#v", err)
+		}
+	}
+
+	// Sharing a value without a cycle is fine.
+	shared := &cyclicNode{Name: "shared"}
+	dag := &cyclicNode{Name: "root", Children: []*cyclicNode{shared, shared}}
+	if _, err := Marshal(dag, BinaryFormat); err != nil {
+		t.Errorf("Expected a shared, acyclic value to encode, received %v", err)
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	var first, second bytes.Buffer
+	enc := NewEncoderForFormat(&first, BinaryFormat)
+	enc.Indent("\t")
+	if err := enc.Encode(map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	enc.Reset(&second, XMLFormat)
+	if err := enc.Encode([]string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := MarshalIndent([]string{"b"}, XMLFormat, "\t")
+	if !bytes.Equal(second.Bytes(), expected) {
+		t.Errorf("Expected %s, received %s", expected, second.Bytes())
+	}
+	if !bytes.HasPrefix(first.Bytes(), []byte("bplist00")) || bytes.Contains(first.Bytes(), []byte("<array>")) {
+		t.Errorf("Expected the first document to be untouched, received %q", first.Bytes())
+	}
+}
+
+func TestOmitDoctype(t *testing.T) {
+	value := map[string]interface{}{"a": []string{"b"}}
+
+	for _, compact := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Compact(compact)
+		enc.OmitDoctype(true)
+		if err := enc.Encode(value); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<plist version=\"1.0\"><dict><key>a</key><array><string>b</string></array></dict></plist>"
+		if compact {
+			expected = strings.Replace(expected, "\n", "", 1)
+		}
+		if buf.String() != expected {
+			t.Errorf("Compact %v: expected %s, received %s", compact, expected, buf.String())
+		}
+
+		var decoded map[string][]string
+		if format, err := Unmarshal(buf.Bytes(), &decoded); err != nil || format != XMLFormat || !reflect.DeepEqual(decoded, map[string][]string{"a": {"b"}}) {
+			t.Errorf("Compact %v: expected the document to decode as XML, received %v in %s (%v)", compact, decoded, FormatNames[format], err)
+		}
+	}
+}
+
+func TestCompactXML(t *testing.T) {
+	value := map[string]interface{}{
+		"a":    "b",
+		"list": []interface{}{1, 2.5, true, "three"},
+		"dict": map[string]string{"c": "d"},
+	}
+
+	var indented, compact bytes.Buffer
+	enc := NewEncoder(&indented)
+	enc.Indent("\t")
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+
+	enc.Reset(&compact, XMLFormat)
+	enc.Compact(true)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, _ := Marshal(value, XMLFormat)
+	if compact.Len() >= len(plain) || compact.Len() >= indented.Len() {
+		t.Errorf("Expected compact output to be the smallest, received %d bytes (plain %d, indented %d)", compact.Len(), len(plain), indented.Len())
+	}
+	if bytes.ContainsAny(compact.Bytes(), "\n\t") || !bytes.HasPrefix(compact.Bytes(), []byte(`<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE plist`)) {
+		t.Errorf("Expected no whitespace between elements, received %s", compact.Bytes())
+	}
+	if !bytes.Contains(compact.Bytes(), []byte(`<dict><key>c</key><string>d</string></dict>`)) {
+		t.Errorf("Expected nested elements without whitespace, received %s", compact.Bytes())
+	}
+
+	var decoded map[string]interface{}
+	format, err := Unmarshal(compact.Bytes(), &decoded)
+	if err != nil || format != XMLFormat {
+		t.Fatalf("Expected compact output to parse as XML, received %s (%v)", FormatNames[format], err)
+	}
+	var expected map[string]interface{}
+	Unmarshal(plain, &expected)
+	if !reflect.DeepEqual(expected, decoded) {
+		t.Errorf("Expected %#v, received %#v", expected, decoded)
+	}
+}
+
+func TestNonFiniteFloats(t *testing.T) {
+	values := []float64{math.Inf(1), math.Inf(-1), math.NaN()}
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+		for _, bits := range []int{32, 64} {
+			var v interface{} = values
+			if bits == 32 {
+				v = []float32{float32(values[0]), float32(values[1]), float32(values[2])}
+			}
+			data, err := Marshal(v, format)
+			if err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			if format == XMLFormat && !bytes.Contains(data, []byte("<real>inf</real><real>-inf</real><real>nan</real>")) {
+				t.Errorf("XML: expected inf, -inf and nan, received %s", data)
+			}
+
+			var decoded []float64
+			if _, err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			if len(decoded) != 3 || !math.IsInf(decoded[0], 1) || !math.IsInf(decoded[1], -1) || !math.IsNaN(decoded[2]) {
+				t.Errorf("%s (%d-bit): expected [+Inf -Inf NaN], received %v", FormatNames[format], bits, decoded)
+			}
+		}
+	}
+
+	for _, real := range []string{"inf", "+inf", "-inf", "nan", "Infinity", "-Infinity", "NaN"} {
+		var f float64
+		if _, err := Unmarshal([]byte("<plist><real>"+real+"</real></plist>"), &f); err != nil || !(math.IsInf(f, 0) || math.IsNaN(f)) {
+			t.Errorf("%s: expected a non-finite value, received %v (%v)", real, f, err)
+		}
+	}
+}
+
+func TestStringHelpers(t *testing.T) {
+	for _, test := range tests {
+		for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+			data, err := Marshal(test.Data, format)
+			s, serr := MarshalString(test.Data, format)
+			if (err == nil) != (serr == nil) || s != string(data) {
+				t.Errorf("%s (%s): MarshalString returned %q (%v), Marshal returned %q (%v)", test.Name, FormatNames[format], s, serr, data, err)
+			}
+			if err != nil {
+				continue
+			}
+
+			var fromBytes, fromString interface{}
+			bformat, berr := Unmarshal(data, &fromBytes)
+			sformat, serr := UnmarshalString(s, &fromString)
+			// Compare printed values, as NaN never equals itself.
+			if bformat != sformat || (berr == nil) != (serr == nil) || fmt.Sprintf("%#v", fromBytes) != fmt.Sprintf("%#v", fromString) {
+				t.Errorf("%s (%s): UnmarshalString returned %#v (%s, %v), Unmarshal returned %#v (%s, %v)", test.Name, FormatNames[format], fromString, FormatNames[sformat], serr, fromBytes, FormatNames[bformat], berr)
+			}
+		}
+	}
+}
+
+func TestToGeneric(t *testing.T) {
+	type inner struct {
+		Value float32
+		When  time.Time
+	}
+	type outer struct {
+		Name    string
+		Count   int
+		Offset  int8
+		Flags   []bool
+		Inner   inner
+		Ptr     *inner
+		Nil     *inner
+		Data    []byte
+		Ignored string `plist:"-"`
+		Members map[string]uint16
+		Any     []interface{}
+	}
+
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	generic, err := ToGeneric(outer{
+		Name:    "outer",
+		Count:   3,
+		Offset:  -2,
+		Flags:   []bool{true, false},
+		Inner:   inner{0.5, when},
+		Ptr:     &inner{1, when},
+		Data:    []byte("data"),
+		Ignored: "ignored",
+		Members: map[string]uint16{"a": 1},
+		Any:     []interface{}{nil, "x", UID(4)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"Name":    "outer",
+		"Count":   uint64(3),
+		"Offset":  int64(-2),
+		"Flags":   []interface{}{true, false},
+		"Inner":   map[string]interface{}{"Value": float32(0.5), "When": when},
+		"Ptr":     map[string]interface{}{"Value": float32(1), "When": when},
+		"Data":    []byte("data"),
+		"Members": map[string]interface{}{"a": uint64(1)},
+		"Any":     []interface{}{"x", UID(4)},
+	}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, generic)
+	}
+
+	// The generic tree is what decoding the encoded value would produce.
+	data, err := Marshal(generic, BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if _, err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected the decoded value to match, received %#v", decoded)
+	}
+
+	if v, err := ToGeneric(nil); v != nil || err != nil {
+		t.Errorf("Expected nil, received %#v (%v)", v, err)
+	}
+	if _, err := ToGeneric(make(chan int)); err == nil {
+		t.Error("Expected an error converting a channel")
+	}
+}
+
+func TestEncodeNilMarshalers(t *testing.T) {
+	type record struct {
+		Name  string
+		When  *time.Time
+		Text  *TextMarshalingBool
+		Point *binaryPoint
+		Boxed interface{}
+	}
+	value := record{Name: "nil", Boxed: (*binaryPoint)(nil)}
+
+	for _, stream := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.StreamXML(stream)
+		if err := enc.Encode(value); err != nil {
+			t.Errorf("stream %v: %v", stream, err)
+			continue
+		}
+		var decoded map[string]interface{}
+		if _, err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[string]interface{}{"Name": "nil"}; !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("stream %v: expected the nil pointers to be left out, received %#v", stream, decoded)
+		}
+	}
+
+	if _, err := Marshal(value, BinaryFormat); err != nil {
+		t.Error(err)
+	}
+}
+
+// opaqueColor has no exported fields and is only a Stringer.
+type opaqueColor struct {
+	name string
+}
+
+func (c opaqueColor) String() string {
+	return "color " + c.name
+}
+
+type namedLevel int
+
+func (l *namedLevel) String() string {
+	return fmt.Sprintf("level %d", int(*l))
+}
+
+// signal is a channel, which can't be encoded by reflection, with a String method.
+type signal chan struct{}
+
+func (signal) String() string {
+	return "signal"
+}
+
+func TestEncodeStringers(t *testing.T) {
+	level := namedLevel(2)
+	value := map[string]interface{}{
+		"color":  opaqueColor{"red"},
+		"colors": []opaqueColor{{"green"}},
+		"level":  &level,
+		"signal": make(signal),
+		"text":   TextMarshalingBool{true},
+		"when":   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"nil":    (*opaqueColor)(nil),
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.EncodeStringers(true)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if _, err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"color":  "color red",
+		"colors": []interface{}{"color green"},
+		"level":  uint64(2),
+		"signal": "signal",
+		"text":   "truthful",
+		"when":   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, decoded)
+	}
+
+	// Streaming the XML takes the same route.
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.EncodeStringers(true)
+	enc.StreamXML(true)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	decoded = nil
+	if _, err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %#v when streaming, received %#v", expected, decoded)
+	}
+
+	// Without the option, Stringers are encoded as any other value, and channels not at all.
+	delete(value, "signal")
+	if _, err := Marshal(map[string]interface{}{"signal": make(signal)}, XMLFormat); err == nil {
+		t.Error("Expected an error encoding a channel without EncodeStringers")
+	}
+	decoded = nil
+	data, err := Marshal(value, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded["color"], map[string]interface{}{}) || decoded["level"] != uint64(2) {
+		t.Errorf("Expected Stringers to be ignored by default, received %#v", decoded)
+	}
+}
+
+func TestEncodeDuration(t *testing.T) {
+	type config struct {
+		Timeout time.Duration
+		Retry   *time.Duration
+	}
+	retry := -1500 * time.Millisecond
+	value := config{Timeout: 30 * time.Second, Retry: &retry}
+
+	for _, integers := range []bool{false, true} {
+		for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+			var buf bytes.Buffer
+			enc := NewEncoderForFormat(&buf, format)
+			enc.EncodeDurationsAsIntegers(integers)
+			if err := enc.Encode(value); err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded config
+			if _, err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("%s (integers %v): %v", FormatNames[format], integers, err)
+			}
+			if decoded.Timeout != value.Timeout || decoded.Retry == nil || *decoded.Retry != retry {
+				t.Errorf("%s (integers %v): expected %v and %v, received %+v", FormatNames[format], integers, value.Timeout, retry, decoded)
+			}
+
+			// Generic values hold the scalar in the property list.
+			var generic map[string]interface{}
+			if _, err := Unmarshal(buf.Bytes(), &generic); err != nil {
+				t.Fatal(err)
+			}
+			var expected interface{} = "30s"
+			if integers && format != OpenStepFormat {
+				expected = uint64(30 * time.Second)
+			} else if integers {
+				expected = "30000000000"
+			}
+			if generic["Timeout"] != expected {
+				t.Errorf("%s (integers %v): expected %#v, received %#v", FormatNames[format], integers, expected, generic["Timeout"])
+			}
+		}
+	}
+
+	var d time.Duration
+	if _, err := Unmarshal([]byte(`<string>30</string>`), &d); err == nil {
+		t.Error("Expected an error decoding a duration without a unit")
+	}
+	if _, err := Unmarshal([]byte(`<string>1h2m</string>`), &d); err != nil || d != time.Hour+2*time.Minute {
+		t.Errorf("Expected 1h2m0s, received %v (%v)", d, err)
+	}
+}
+
+func TestEncodeURL(t *testing.T) {
+	type service struct {
+		Endpoint *url.URL
+		Mirror   url.URL
+		Fallback *url.URL
+	}
+	endpoint, _ := url.Parse("https://example.com/api?v=2#top")
+	mirror, _ := url.Parse("ftp://user@mirror.example.com:2121/pub")
+	value := service{Endpoint: endpoint, Mirror: *mirror}
+
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+		data, err := Marshal(value, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var generic map[string]interface{}
+		if _, err := Unmarshal(data, &generic); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{"Endpoint": endpoint.String(), "Mirror": mirror.String()}
+		if !reflect.DeepEqual(generic, expected) {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], expected, generic)
+		}
+
+		var decoded service
+		if _, err := Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Errorf("%s: expected %+v, received %+v", FormatNames[format], value, decoded)
+		}
+	}
+
+	var decoded service
+	_, err := Unmarshal([]byte(`<dict><key>Endpoint</key><string>http://[::1</string></dict>`), &decoded)
+	var located *UnmarshalError
+	var urlErr *url.Error
+	if !errors.As(err, &located) || located.KeyPath != "Endpoint" || !errors.As(err, &urlErr) {
+		t.Errorf("Expected a URL error at Endpoint, received %v", err)
+	}
+}
+
+func TestNumbersAsReals(t *testing.T) {
+	type record struct {
+		Count  int
+		Small  uint8
+		Ratio  float32
+		Number Number
+		ID     UID
+	}
+	value := record{Count: -3, Small: 200, Ratio: 0.5, Number: "7", ID: 1}
+
+	var buf bytes.Buffer
+	enc := NewEncoderForFormat(&buf, XMLFormat)
+	enc.Compact(true)
+	enc.NumbersAsReals(true)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<key>Count</key><real>-3</real><key>ID</key><dict><key>CF$UID</key><integer>1</integer></dict><key>Number</key><real>7</real><key>Ratio</key><real>0.5</real><key>Small</key><real>200</real>") {
+		t.Errorf("Expected integers to be written as reals, received %s", buf.Bytes())
+	}
+
+	buf.Reset()
+	enc = NewEncoderForFormat(&buf, BinaryFormat)
+	enc.NumbersAsReals(true)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	pval, err := NewDecoder(bytes.NewReader(buf.Bytes())).DecodeValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := pval.MapIndex("Count"); count.Kind() != Real || count.Float() != -3 {
+		t.Errorf("Expected Count to be the real -3, received %v", count.Kind())
+	}
+	if ratio := pval.MapIndex("Ratio"); ratio.FloatBits() != 32 {
+		t.Errorf("Expected Ratio to stay a 32-bit real, received %d bits", ratio.FloatBits())
+	}
+
+	// The option is off by default.
+	data, err := Marshal(value, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<integer>-3</integer>") {
+		t.Errorf("Expected integers by default, received %s", data)
+	}
+}
+
+func TestScalarRoot(t *testing.T) {
+	roots := []struct {
+		value interface{}
+		xml   string
+	}{
+		{"hi", `<plist version="1.0"><string>hi</string></plist>`},
+		{uint64(42), `<plist version="1.0"><integer>42</integer></plist>`},
+		{int64(-42), `<plist version="1.0"><integer>-42</integer></plist>`},
+		{[]byte("data"), `<plist version="1.0"><data>ZGF0YQ==</data></plist>`},
+	}
+	for _, root := range roots {
+		for _, format := range []int{XMLFormat, BinaryFormat} {
+			data, err := Marshal(root.value, format)
+			if err != nil {
+				t.Fatalf("%s %v: %v", FormatNames[format], root.value, err)
+			}
+			if format == XMLFormat && !strings.HasSuffix(string(data), root.xml) {
+				t.Errorf("Expected %s, received %s", root.xml, data)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(root.value))
+			if _, err := Unmarshal(data, decoded.Interface()); err != nil {
+				t.Fatalf("%s %v: %v", FormatNames[format], root.value, err)
+			}
+			if !reflect.DeepEqual(decoded.Elem().Interface(), root.value) {
+				t.Errorf("%s: expected %#v, received %#v", FormatNames[format], root.value, decoded.Elem().Interface())
+			}
+
+			var generic interface{}
+			if _, err := Unmarshal(data, &generic); err != nil || !reflect.DeepEqual(generic, root.value) {
+				t.Errorf("%s: expected %#v, received %#v (%v)", FormatNames[format], root.value, generic, err)
+			}
+		}
+	}
+}
+
+func TestStreamXML(t *testing.T) {
+	encode := func(v interface{}, stream bool, indent string) ([]byte, error) {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Indent(indent)
+		enc.StreamXML(stream)
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	}
+
+	for _, test := range tests {
+		if _, ok := test.Expected[XMLFormat]; test.SkipEncode[XMLFormat] || !ok && !test.ShouldFail {
+			continue
+		}
+		data, err := encode(test.Data, true, "")
+		if test.ShouldFail {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.Name)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(data, test.Expected[XMLFormat]) {
+			t.Errorf("%s: expected %s, received %s (%v)", test.Name, test.Expected[XMLFormat], data, err)
+		}
+	}
+
+	values := []interface{}{
+		map[string]interface{}{
+			"list":    []interface{}{1, "two", []int{3}, map[string]int{}},
+			"skipped": nil,
+			"version": marshalerVersion{1, 2, 3},
+			"color":   &marshalerColor{1, 2, 3, 4},
+		},
+		struct {
+			Name    string
+			Empty   string `plist:",omitempty"`
+			Nested  *struct{ Values []float32 }
+			Missing *int
+			Data    []byte
+			When    time.Time
+		}{Name: "name", Nested: &struct{ Values []float32 }{[]float32{0.5}}, When: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		&cyclicNode{Name: "leaf"},
+	}
+	for _, indent := range []string{"", "\t"} {
+		for _, v := range values {
+			expected, err := encode(v, false, indent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := encode(v, true, indent); err != nil || !bytes.Equal(data, expected) {
+				t.Errorf("%#v: expected %s, received %s (%v)", v, expected, data, err)
+			}
+		}
+	}
+
+	// Errors are still reported, though part of the document may have been written.
+	root := &cyclicNode{Name: "root"}
+	root.Children = []*cyclicNode{root}
+	for _, v := range []interface{}{root, nil, map[string]marshalerFailure{"a": {}}} {
+		if _, err := encode(v, true, ""); err == nil {
+			t.Errorf("%#v: expected an error", v)
+		}
+	}
+}
+
+func BenchmarkXMLEncodeLargeArray(b *testing.B) {
+	type entry struct {
+		Name  string
+		Index int
+		Tags  []string
+	}
+	entries := make([]entry, 10000)
+	for i := range entries {
+		entries[i] = entry{Name: fmt.Sprint("entry ", i), Index: i, Tags: []string{"a", "b"}}
+	}
+
+	for _, stream := range []bool{false, true} {
+		b.Run(fmt.Sprintf("stream=%v", stream), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				enc := NewEncoder(ioutil.Discard)
+				enc.StreamXML(stream)
+				if err := enc.Encode(entries); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	var nilInt *int
+	type fields struct {
+		Slice     []int
+		Map       map[string]int
+		Pointer   *int
+		Interface interface{}
+		Error     error
+		Omitted   []int `plist:",omitempty"`
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"struct fields", fields{}, map[string]interface{}{"Slice": []interface{}{}, "Map": map[string]interface{}{}}},
+		{"nil interfaces in an array", []interface{}{nil, 1, nil}, []interface{}{uint64(1)}},
+		{"nil pointers in an array", []*int{nilInt, nilInt}, []interface{}{}},
+		{"nil slice in an array", []interface{}{[]int(nil)}, []interface{}{[]interface{}{}}},
+		{"nil values in a map", map[string]interface{}{"a": nil, "b": nilInt, "c": map[string]int(nil)}, map[string]interface{}{"c": map[string]interface{}{}}},
+	}
+	for _, test := range tests {
+		for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat} {
+			data, err := Marshal(test.value, format)
+			if err != nil {
+				t.Fatalf("%s in %s: %v", test.name, FormatNames[format], err)
+			}
+			var decoded interface{}
+			if _, err := Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("%s in %s: %v", test.name, FormatNames[format], err)
+			}
+			expected := test.expected
+			if format == OpenStepFormat {
+				// OpenStep stores integers as strings, which print the same.
+				expected, decoded = fmt.Sprint(expected), fmt.Sprint(decoded)
+			}
+			if !reflect.DeepEqual(decoded, expected) {
+				t.Errorf("%s in %s: expected %#v, received %#v", test.name, FormatNames[format], expected, decoded)
+			}
+		}
+
+		var streamed bytes.Buffer
+		enc := NewEncoder(&streamed)
+		enc.StreamXML(true)
+		buffered, _ := Marshal(test.value, XMLFormat)
+		if err := enc.Encode(test.value); err != nil || !bytes.Equal(streamed.Bytes(), buffered) {
+			t.Errorf("%s: expected streaming to produce %s, received %s (%v)", test.name, buffered, streamed.Bytes(), err)
+		}
+	}
+
+	for _, v := range []interface{}{nil, nilInt} {
+		if _, err := Marshal(v, XMLFormat); err == nil {
+			t.Errorf("Expected an error encoding %#v at the top level", v)
+		}
+	}
+	if data, err := Marshal([]byte(nil), XMLFormat); err != nil || !strings.HasSuffix(string(data), "<data></data></plist>") {
+		t.Errorf("Expected a nil []byte to encode as empty data, received %s (%v)", data, err)
+	}
+}
+
+func TestEncodeN(t *testing.T) {
+	value := map[string]interface{}{"a": []interface{}{1, "two", 3.5}, "b": []byte("data")}
+	for _, format := range []int{XMLFormat, BinaryFormat, OpenStepFormat, GNUStepFormat} {
+		var buf bytes.Buffer
+		enc := NewEncoderForFormat(&buf, format)
+		for i := 1; i <= 2; i++ {
+			n, err := enc.EncodeN(value)
+			if err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			if n <= 0 || int(n)*i != buf.Len() {
+				t.Errorf("%s: expected each of %d documents to take %d bytes, received %d", FormatNames[format], i, buf.Len()/i, n)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if n, err := NewEncoder(&buf).EncodeN(nil); err == nil || n != 0 {
+		t.Errorf("Expected an error and nothing written, received %d bytes (%v)", n, err)
+	}
+}
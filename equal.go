@@ -0,0 +1,118 @@
+package plist
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"time"
+)
+
+// Equal reports whether a and b marshal to the same property list.
+//
+// The values are compared as property list objects rather than as Go values: integers are equal if they have the same value,
+// whatever their Go types; reals are compared by value regardless of their precision, and NaN is equal to itself;
+// data is compared byte by byte and dates by the instant they represent. Dictionaries are equal if they hold the same keys
+// with equal values, in any order, and sets if they hold equal members in any order.
+// As in Marshal, nil values inside arrays and dictionaries are ignored.
+//
+// Values that cannot be marshaled are not equal to anything.
+func Equal(a, b interface{}) bool {
+	pa, err := marshalValue(a)
+	if err != nil {
+		return false
+	}
+	pb, err := marshalValue(b)
+	if err != nil {
+		return false
+	}
+	return plistValuesEqual(pa, pb)
+}
+
+func plistValuesEqual(a, b *Value) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.kind != b.kind {
+		return false
+	}
+
+	switch a.kind {
+	case Dictionary:
+		da, db := a.value.(*dictionary), b.value.(*dictionary)
+		if len(da.m) != len(db.m) {
+			return false
+		}
+		for k, va := range da.m {
+			if vb, ok := db.m[k]; !ok || !plistValuesEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	case Array:
+		aa, ab := nonNilValues(a.value.([]*Value)), nonNilValues(b.value.([]*Value))
+		if len(aa) != len(ab) {
+			return false
+		}
+		for i := range aa {
+			if !plistValuesEqual(aa[i], ab[i]) {
+				return false
+			}
+		}
+		return true
+	case CFSet:
+		// Sets are unordered: match each member of one with a distinct, equal member of the other.
+		sa, sb := nonNilValues(a.value.([]*Value)), nonNilValues(b.value.([]*Value))
+		if len(sa) != len(sb) {
+			return false
+		}
+		matched := make([]bool, len(sb))
+	members:
+		for _, va := range sa {
+			for j, vb := range sb {
+				if !matched[j] && plistValuesEqual(va, vb) {
+					matched[j] = true
+					continue members
+				}
+			}
+			return false
+		}
+		return true
+	case Integer:
+		return integerBigValue(a.value).Cmp(integerBigValue(b.value)) == 0
+	case Real:
+		fa, fb := a.value.(sizedFloat).value, b.value.(sizedFloat).value
+		return fa == fb || math.IsNaN(fa) && math.IsNaN(fb)
+	case Data:
+		return bytes.Equal(a.value.([]byte), b.value.([]byte))
+	case Date:
+		return a.value.(time.Time).Equal(b.value.(time.Time))
+	case CFNull:
+		return true
+	}
+	return a.value == b.value
+}
+
+// nonNilValues returns the elements of an array that will be encoded; nil elements are left out.
+func nonNilValues(values []*Value) []*Value {
+	out := values[:0:0]
+	for _, v := range values {
+		if v != nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// integerBigValue returns the value of an integer property list object, which is a signedInt or a *big.Int.
+func integerBigValue(value interface{}) *big.Int {
+	switch n := value.(type) {
+	case *big.Int:
+		return n
+	case signedInt:
+		if n.signed {
+			return big.NewInt(int64(n.value))
+		}
+		return new(big.Int).SetUint64(n.value)
+	}
+	return new(big.Int)
+}
@@ -0,0 +1,77 @@
+package plist
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEqual(t *testing.T) {
+	date := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	equal := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"nil", nil, nil},
+		{"integer types", int8(-5), int64(-5)},
+		{"signed and unsigned", int(7), uint64(7)},
+		{"float precision", float32(0.5), 0.5},
+		{"NaN", math.NaN(), float32(math.NaN())},
+		{"data", []byte("hello"), []byte("hello")},
+		{"dates in different zones", date, date.In(time.FixedZone("X", 3600))},
+		{"struct and map", struct {
+			Name  string
+			Count uint8
+			Tags  []string
+		}{"a", 3, []string{"x", "y"}}, map[string]interface{}{
+			"Name":  "a",
+			"Count": int64(3),
+			"Tags":  []interface{}{"x", "y"},
+		}},
+		{"nested generic trees", map[string]interface{}{
+			"A": []interface{}{int32(1), map[string]interface{}{"B": uint16(2)}},
+		}, map[string]interface{}{
+			"A": []interface{}{uint64(1), map[string]int{"B": 2}},
+		}},
+		{"nil values ignored", []interface{}{1, nil, 2}, []int{1, 2}},
+		{"ordered and unordered dictionaries", OrderedDict{Keys: []string{"b", "a"}, Values: map[string]interface{}{"a": 1, "b": 2}}, map[string]int{"a": 1, "b": 2}},
+	}
+	for _, test := range equal {
+		if !Equal(test.a, test.b) {
+			t.Errorf("%s: expected %#v and %#v to be equal", test.name, test.a, test.b)
+		}
+		if !Equal(test.b, test.a) {
+			t.Errorf("%s: expected %#v and %#v to be equal", test.name, test.b, test.a)
+		}
+	}
+
+	unequal := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"nil and value", nil, 1},
+		{"different integers", 1, 2},
+		{"negative and large unsigned", int64(-1), uint64(math.MaxUint64)},
+		{"integer and real", 1, 1.0},
+		{"integer and string", 1, "1"},
+		{"boolean and integer", true, 1},
+		{"data and string", []byte("a"), "a"},
+		{"data", []byte("a"), []byte("b")},
+		{"dates", date, date.Add(time.Second)},
+		{"array lengths", []int{1, 2}, []int{1, 2, 3}},
+		{"array order", []int{1, 2}, []int{2, 1}},
+		{"missing key", map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1}},
+		{"different keys", map[string]int{"a": 1}, map[string]int{"b": 1}},
+		{"nested value", map[string][]string{"a": {"x"}}, map[string][]string{"a": {"y"}}},
+		{"unmarshalable", make(chan int), make(chan int)},
+	}
+	for _, test := range unequal {
+		if Equal(test.a, test.b) {
+			t.Errorf("%s: expected %#v and %#v not to be equal", test.name, test.a, test.b)
+		}
+		if Equal(test.b, test.a) {
+			t.Errorf("%s: expected %#v and %#v not to be equal", test.name, test.b, test.a)
+		}
+	}
+}
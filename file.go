@@ -0,0 +1,30 @@
+package plist
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// ReadFile decodes the property list in the named file into the value pointed to by v, as Unmarshal would,
+// and returns the format it was in, so that the file can be written back with WriteFile in the same format.
+func ReadFile(path string, v interface{}) (format int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return InvalidFormat, err
+	}
+	defer f.Close()
+
+	dec := NewDecoder(f)
+	err = dec.Decode(v)
+	return dec.Format, err
+}
+
+// WriteFile encodes v in the given format, as Marshal would, and writes it to the named file,
+// creating it with permissions 0666 (before umask) if necessary. Nothing is written if v cannot be encoded.
+func WriteFile(path string, v interface{}, format int) error {
+	data, err := Marshal(v, format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0666)
+}
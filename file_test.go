@@ -0,0 +1,48 @@
+package plist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	value := map[string]interface{}{"Name": "example", "Count": uint64(3), "Tags": []interface{}{"a", "b"}}
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		path := filepath.Join(dir, FormatNames[format]+".plist")
+		if err := WriteFile(path, value, format); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded map[string]interface{}
+		readFormat, err := ReadFile(path, &decoded)
+		if err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if readFormat != format || !reflect.DeepEqual(decoded, value) {
+			t.Errorf("%s: expected %v, received %s %v", FormatNames[format], value, FormatNames[readFormat], decoded)
+		}
+
+		// A value that can't be encoded leaves the file as it was.
+		if err := WriteFile(path, make(chan int), format); err == nil {
+			t.Errorf("%s: expected an error writing a channel", FormatNames[format])
+		}
+		var reread map[string]interface{}
+		if _, err := ReadFile(path, &reread); err != nil || !reflect.DeepEqual(reread, value) {
+			t.Errorf("%s: expected the file to survive a failed write, received %v (%v)", FormatNames[format], reread, err)
+		}
+	}
+
+	var v interface{}
+	if format, err := ReadFile(filepath.Join(dir, "missing.plist"), &v); !os.IsNotExist(err) || format != InvalidFormat {
+		t.Errorf("Expected a not-exist error for a missing file, received %s (%v)", FormatNames[format], err)
+	}
+}
@@ -2,7 +2,11 @@ package plist
 
 import (
 	"encoding"
+	"fmt"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"time"
 )
 
@@ -18,137 +22,501 @@ func isEmptyValue(v reflect.Value) bool {
 		return v.Uint() == 0
 	case reflect.Float32, reflect.Float64:
 		return v.Float() == 0
-	case reflect.Interface, reflect.Ptr:
+	case reflect.Interface, reflect.Ptr, reflect.Func, reflect.Chan, reflect.UnsafePointer:
 		return v.IsNil()
 	}
 	return false
 }
 
 var (
-	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
-	timeType          = reflect.TypeOf((*time.Time)(nil)).Elem()
+	plistMarshalerType  = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	stringerType        = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType            = reflect.TypeOf((*time.Time)(nil)).Elem()
+	durationType        = reflect.TypeOf((*time.Duration)(nil)).Elem()
+	urlType             = reflect.TypeOf((*url.URL)(nil)).Elem()
+	uidType             = reflect.TypeOf((*UID)(nil)).Elem()
+	nullType            = reflect.TypeOf((*Null)(nil)).Elem()
+	setType             = reflect.TypeOf((*Set)(nil)).Elem()
+	orderedDictType     = reflect.TypeOf((*OrderedDict)(nil)).Elem()
+	valueType           = reflect.TypeOf((*Value)(nil)).Elem()
+	dataReaderType      = reflect.TypeOf((*DataReader)(nil)).Elem()
 )
 
-func (p *Encoder) marshalTextInterface(marshalable encoding.TextMarshaler) *plistValue {
+func (p *Encoder) marshalPlistInterface(marshalable Marshaler) *Value {
+	value, err := marshalable.MarshalPlist()
+	if err != nil {
+		panic(err)
+	}
+	return p.marshal(reflect.ValueOf(value))
+}
+
+func (p *Encoder) marshalValue(val reflect.Value) *Value {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	pval := val.Interface().(Value)
+	if pval.kind == Invalid {
+		panic(&UnsupportedValueError{val, "the zero Value holds no property list object"})
+	}
+	return &pval
+}
+
+func (p *Encoder) marshalTextInterface(marshalable encoding.TextMarshaler) *Value {
 	s, err := marshalable.MarshalText()
 	if err != nil {
 		panic(err)
 	}
-	return &plistValue{String, string(s)}
+	return &Value{String, string(s)}
 }
 
-func (p *Encoder) marshalStruct(typ reflect.Type, val reflect.Value) *plistValue {
-	tinfo, _ := getTypeInfo(typ)
+func (p *Encoder) marshalBinaryInterface(marshalable encoding.BinaryMarshaler) *Value {
+	b, err := marshalable.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	return &Value{Data, b}
+}
+
+// isValidMapKeyType reports whether maps keyed by typ can be represented as dictionaries:
+// their keys must be strings, integers or implement encoding.TextMarshaler.
+func isValidMapKeyType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return typ.Implements(textMarshalerType)
+}
+
+func (p *Encoder) marshalMapKey(keyv reflect.Value) string {
+	if keyv.Kind() == reflect.String {
+		return keyv.String()
+	}
+	if tm, ok := keyv.Interface().(encoding.TextMarshaler); ok {
+		if keyv.Kind() == reflect.Ptr && keyv.IsNil() {
+			return ""
+		}
+		s, err := tm.MarshalText()
+		if err != nil {
+			panic(err)
+		}
+		return string(s)
+	}
+	switch keyv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(keyv.Int(), 10)
+	default:
+		return strconv.FormatUint(keyv.Uint(), 10)
+	}
+}
+
+// typeInfo returns the details needed to encode typ with the Encoder's settings.
+func (p *Encoder) typeInfo(typ reflect.Type) *typeInfo {
+	tinfo, _ := getTypeInfo(typ, p.tagKey)
+	return renamedTypeInfo(&p.renamed, tinfoKey{typ, p.tagKey}, tinfo, p.keyNamer)
+}
+
+func (p *Encoder) marshalStruct(typ reflect.Type, val reflect.Value) *Value {
+	tinfo := p.typeInfo(typ)
+
+	if tinfo.positional {
+		// Every field needs a value, or those after it would be taken for the one before.
+		values := make([]*Value, len(tinfo.fields))
+		for i, finfo := range tinfo.fields {
+			if value := finfo.existingValue(val); value.IsValid() {
+				values[i] = p.marshal(value)
+			}
+			if values[i] == nil {
+				panic(&UnsupportedValueError{val, fmt.Sprintf("field %s of positional struct %v has no value", finfo.name, typ)})
+			}
+		}
+		return &Value{Array, values}
+	}
+
+	// A struct with nothing to encode might still say what it is.
+	if len(tinfo.fields) == 0 {
+		if pval, ok := p.marshalStringer(val); ok {
+			return pval
+		}
+	}
 
 	dict := &dictionary{
-		m: make(map[string]*plistValue, len(tinfo.fields)),
+		m: make(map[string]*Value, len(tinfo.fields)),
 	}
 	for _, finfo := range tinfo.fields {
-		value := finfo.value(val)
+		value := finfo.existingValue(val)
 		if !value.IsValid() || finfo.omitEmpty && isEmptyValue(value) {
 			continue
 		}
-		dict.m[finfo.name] = p.marshal(value)
+		if subpval := p.marshal(value); subpval != nil {
+			dict.m[finfo.name] = subpval
+		}
 	}
 
-	return &plistValue{Dictionary, dict}
+	return &Value{Dictionary, dict}
 }
 
-func (p *Encoder) marshalTime(val reflect.Value) *plistValue {
-	time := val.Interface().(time.Time)
-	return &plistValue{Date, time}
-}
-
-func (p *Encoder) marshal(val reflect.Value) *plistValue {
+// streamValue writes val to g as it walks it, producing the XML that marshaling val and writing the result would,
+// without building the whole tree first: the dictionaries and arrays that marshal would build by reflection are
+// written entry by entry, and only the other values are marshaled, one at a time. before is called just before
+// anything is written for val, and not at all if val is discarded.
+func (p *Encoder) streamValue(g *xmlPlistGenerator, val reflect.Value, before func()) {
 	if !val.IsValid() {
-		return nil
+		return
 	}
 
-	// time.Time implements TextMarshaler, but we need to store it in RFC3339
-	if val.Type() == timeType {
-		return p.marshalTime(val)
+	defer p.trackPointer(val)()
+
+	if pval, ok := p.marshalSpecial(val); ok {
+		p.streamLeaf(g, pval, before)
+		return
 	}
-	if val.Kind() == reflect.Ptr || (val.Kind() == reflect.Interface && val.NumMethod() == 0) {
-		ival := val.Elem()
-		if ival.IsValid() && ival.Type() == timeType {
-			return p.marshalTime(ival)
+
+	if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		p.streamValue(g, val.Elem(), before)
+		return
+	}
+
+	typ := val.Type()
+	switch {
+	case val.Kind() == reflect.Struct && typ != orderedDictType:
+		tinfo := p.typeInfo(typ)
+		if tinfo.positional || p.stringers && len(tinfo.fields) == 0 {
+			p.streamLeaf(g, p.marshalStruct(typ, val), before)
+			return
 		}
+		entries := make([]streamEntry, 0, len(tinfo.fields))
+		for _, finfo := range tinfo.fields {
+			value := finfo.existingValue(val)
+			if !value.IsValid() || finfo.omitEmpty && isEmptyValue(value) {
+				continue
+			}
+			entries = append(entries, streamEntry{finfo.name, value})
+		}
+		p.streamDict(g, entries, before)
+	case val.Kind() == reflect.Map:
+		if !isValidMapKeyType(typ.Key()) {
+			panic(&UnsupportedTypeError{typ})
+		}
+		entries := make([]streamEntry, 0, val.Len())
+		for _, keyv := range val.MapKeys() {
+			entries = append(entries, streamEntry{p.marshalMapKey(keyv), val.MapIndex(keyv)})
+		}
+		p.streamDict(g, entries, before)
+	case val.Kind() == reflect.Array, val.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8:
+		before()
+		g.openContainer("array")
+		for i, n := 0, val.Len(); i < n; i++ {
+			p.streamValue(g, val.Index(i), func() {})
+		}
+		g.closeContainer("array")
+	default:
+		p.streamLeaf(g, p.marshalReflect(val), before)
 	}
+}
 
-	// Check for text marshaler.
-	if val.CanInterface() && val.Type().Implements(textMarshalerType) {
-		return p.marshalTextInterface(val.Interface().(encoding.TextMarshaler))
+// streamEntry is a dictionary entry waiting to be written by streamDict.
+type streamEntry struct {
+	key   string
+	value reflect.Value
+}
+
+// streamDict writes a dictionary holding entries, in order of their keys, as marshal would.
+func (p *Encoder) streamDict(g *xmlPlistGenerator, entries []streamEntry, before func()) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	before()
+	g.openContainer("dict")
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].key == e.key {
+			// Map keys that marshal to the same string overwrite one another.
+			continue
+		}
+		p.streamValue(g, e.value, func() { g.writeKey(e.key) })
 	}
-	if val.CanAddr() {
-		pv := val.Addr()
-		if pv.CanInterface() && pv.Type().Implements(textMarshalerType) {
-			return p.marshalTextInterface(pv.Interface().(encoding.TextMarshaler))
+	g.closeContainer("dict")
+}
+
+// streamLeaf writes a marshaled value. As in writePlistValue, null objects are left out of arrays and dictionaries.
+func (p *Encoder) streamLeaf(g *xmlPlistGenerator, pval *Value, before func()) {
+	if pval == nil || pval.kind == CFNull && g.depth > 0 {
+		return
+	}
+	before()
+	g.writePlistValue(pval)
+}
+
+func (p *Encoder) marshalOrderedDict(od OrderedDict) *Value {
+	dict := newDictionary()
+	for _, k := range od.Keys {
+		v, ok := od.Values[k]
+		if !ok {
+			continue
+		}
+		if subpval := p.marshal(reflect.ValueOf(v)); subpval != nil {
+			dict.set(k, subpval)
 		}
 	}
+	return &Value{Dictionary, dict}
+}
 
-	// Descend into pointers or interfaces
-	if val.Kind() == reflect.Ptr || (val.Kind() == reflect.Interface && val.NumMethod() == 0) {
-		val = val.Elem()
+func (p *Encoder) marshalTime(val reflect.Value) *Value {
+	time := val.Interface().(time.Time)
+	return &Value{Date, time}
+}
+
+// marshalURL returns the string form of a url.URL, which would otherwise be encoded as data by its MarshalBinary method.
+func (p *Encoder) marshalURL(val reflect.Value) *Value {
+	u := val.Interface().(url.URL)
+	return &Value{String, u.String()}
+}
+
+// marshalInteger returns the integer object for n, or the nearest real if the Encoder writes all numbers as reals.
+func (p *Encoder) marshalInteger(n signedInt) *Value {
+	if !p.numbersAsReals {
+		return &Value{Integer, n}
+	}
+	if n.signed {
+		return &Value{Real, sizedFloat{float64(int64(n.value)), 64}}
+	}
+	return &Value{Real, sizedFloat{float64(n.value), 64}}
+}
+
+func (p *Encoder) marshalDuration(val reflect.Value) *Value {
+	if p.durationInt {
+		return p.marshalInteger(signedInt{uint64(val.Int()), true})
 	}
+	return &Value{String, time.Duration(val.Int()).String()}
+}
 
-	// We got this far and still may have an invalid anything or nil ptr/interface
-	if !val.IsValid() || ((val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil()) {
+// ptrSeenKey identifies a pointer, map or slice. The type is included because a pointer to a struct
+// and a pointer to its first field share an address.
+type ptrSeenKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// trackPointer records that val, if it is a pointer, map or slice, is being marshaled, and panics if it already is:
+// val contains itself. It returns a function that forgets val again.
+func (p *Encoder) trackPointer(val reflect.Value) func() {
+	switch val.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !val.IsNil() && !(val.Kind() == reflect.Slice && val.Len() == 0) {
+			key := ptrSeenKey{val.Pointer(), val.Type(), 0}
+			if val.Kind() == reflect.Slice {
+				key.len = val.Len()
+			}
+			if _, ok := p.ptrSeen[key]; ok {
+				panic(&UnsupportedValueError{val, "encountered a cycle via " + val.Type().String()})
+			}
+			if p.ptrSeen == nil {
+				p.ptrSeen = make(map[ptrSeenKey]struct{})
+			}
+			p.ptrSeen[key] = struct{}{}
+			return func() { delete(p.ptrSeen, key) }
+		}
+	}
+	return func() {}
+}
+
+func (p *Encoder) marshal(val reflect.Value) *Value {
+	if !val.IsValid() {
 		return nil
 	}
 
+	defer p.trackPointer(val)()
+
+	if pval, ok := p.marshalSpecial(val); ok {
+		return pval
+	}
+
+	// Descend into pointers or interfaces; the value within might itself be marshalable.
+	if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		return p.marshal(val.Elem())
+	}
+
+	return p.marshalReflect(val)
+}
+
+// marshalReflect marshals a value that is neither a pointer nor an interface, and isn't handled by marshalSpecial,
+// according to its kind.
+func (p *Encoder) marshalReflect(val reflect.Value) *Value {
 	typ := val.Type()
 
+	if typ == orderedDictType {
+		return p.marshalOrderedDict(val.Interface().(OrderedDict))
+	}
+
 	if val.Kind() == reflect.Struct {
 		return p.marshalStruct(typ, val)
 	}
 
+	if typ == uidType {
+		return &Value{CFUID, UID(val.Uint())}
+	}
+
 	switch val.Kind() {
 	case reflect.String:
-		return &plistValue{String, val.String()}
+		return &Value{String, val.String()}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return &plistValue{Integer, signedInt{uint64(val.Int()), true}}
+		return p.marshalInteger(signedInt{uint64(val.Int()), true})
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return &plistValue{Integer, signedInt{uint64(val.Uint()), false}}
+		return p.marshalInteger(signedInt{uint64(val.Uint()), false})
 	case reflect.Float32, reflect.Float64:
-		return &plistValue{Real, sizedFloat{val.Float(), val.Type().Bits()}}
+		return &Value{Real, sizedFloat{val.Float(), val.Type().Bits()}}
 	case reflect.Bool:
-		return &plistValue{Boolean, val.Bool()}
+		return &Value{Boolean, val.Bool()}
 	case reflect.Slice, reflect.Array:
-		if typ.Elem().Kind() == reflect.Uint8 {
-			bytes := []byte(nil)
-			if val.CanAddr() {
-				bytes = val.Bytes()
-			} else {
-				bytes = make([]byte, val.Len())
-				reflect.Copy(reflect.ValueOf(bytes), val)
-			}
-			return &plistValue{Data, bytes}
+		// Only byte slices are data; byte arrays are encoded element by element like any other array.
+		if val.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
+			return &Value{Data, val.Bytes()}
 		} else {
-			subvalues := make([]*plistValue, val.Len())
+			subvalues := make([]*Value, val.Len())
 			for idx, length := 0, val.Len(); idx < length; idx++ {
 				if subpval := p.marshal(val.Index(idx)); subpval != nil {
 					subvalues[idx] = subpval
 				}
 			}
-			return &plistValue{Array, subvalues}
+			if typ == setType {
+				return &Value{CFSet, subvalues}
+			}
+			return &Value{Array, subvalues}
 		}
 	case reflect.Map:
-		if typ.Key().Kind() != reflect.String {
-			panic(&unknownTypeError{typ})
+		if !isValidMapKeyType(typ.Key()) {
+			panic(&UnsupportedTypeError{typ})
 		}
 
 		l := val.Len()
 		dict := &dictionary{
-			m: make(map[string]*plistValue, l),
+			m: make(map[string]*Value, l),
 		}
 		for _, keyv := range val.MapKeys() {
 			if subpval := p.marshal(val.MapIndex(keyv)); subpval != nil {
-				dict.m[keyv.String()] = subpval
+				dict.m[p.marshalMapKey(keyv)] = subpval
 			}
 		}
-		return &plistValue{Dictionary, dict}
+		return &Value{Dictionary, dict}
 	default:
-		panic(&unknownTypeError{typ})
+		if pval, ok := p.marshalStringer(val); ok {
+			return pval
+		}
+		panic(&UnsupportedTypeError{typ})
+	}
+}
+
+// marshalSpecial marshals the values that are encoded by their own methods or are otherwise handled specially,
+// rather than by reflecting over them. It reports whether val is one of these.
+func (p *Encoder) marshalSpecial(val reflect.Value) (*Value, bool) {
+	// Values are property list objects already.
+	if val.Type() == valueType || val.Kind() == reflect.Ptr && val.Type().Elem() == valueType {
+		return p.marshalValue(val), true
+	}
+
+	if val.Type() == numberType {
+		return p.marshalNumber(val), true
+	}
+
+	// Functions registered for the type come before any interfaces it implements.
+	if codec, ok := registeredCodec(val.Type()); ok && codec.enc != nil && val.CanInterface() {
+		return p.marshalCodec(val, codec.enc), true
+	}
+
+	// A nil pointer or interface can't marshal itself, by any of the interfaces below: we'll discard it instead.
+	isNil := (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil()
+
+	// Check for plist marshaler.
+	if !isNil {
+		if val.CanInterface() && val.Type().Implements(plistMarshalerType) {
+			return p.marshalPlistInterface(val.Interface().(Marshaler)), true
+		}
+		if val.CanAddr() {
+			pv := val.Addr()
+			if pv.CanInterface() && pv.Type().Implements(plistMarshalerType) {
+				return p.marshalPlistInterface(pv.Interface().(Marshaler)), true
+			}
+		}
+	}
+
+	// time.Time implements TextMarshaler, but we need to store it in RFC3339
+	if val.Type() == timeType {
+		return p.marshalTime(val), true
+	}
+	if val.Type() == durationType {
+		return p.marshalDuration(val), true
+	}
+	if val.Type() == urlType {
+		return p.marshalURL(val), true
+	}
+	if val.Kind() == reflect.Ptr && val.Type().Elem() == urlType {
+		// A nil *url.URL would panic in MarshalBinary; discard it as any other nil pointer.
+		if val.IsNil() {
+			return nil, true
+		}
+		return p.marshalURL(val.Elem()), true
+	}
+	if val.Type() == nullType {
+		return &Value{CFNull, nil}, true
+	}
+	if val.Kind() == reflect.Ptr || (val.Kind() == reflect.Interface && val.NumMethod() == 0) {
+		ival := val.Elem()
+		if ival.IsValid() && ival.Type() == timeType {
+			return p.marshalTime(ival), true
+		}
+	}
+
+	if isNil {
+		return nil, false
+	}
+
+	// Check for text marshaler.
+	if val.CanInterface() && val.Type().Implements(textMarshalerType) {
+		return p.marshalTextInterface(val.Interface().(encoding.TextMarshaler)), true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(textMarshalerType) {
+			return p.marshalTextInterface(pv.Interface().(encoding.TextMarshaler)), true
+		}
+	}
+
+	// Check for binary marshaler, which is only consulted for types that can't marshal themselves as text.
+	if val.CanInterface() && val.Type().Implements(binaryMarshalerType) {
+		return p.marshalBinaryInterface(val.Interface().(encoding.BinaryMarshaler)), true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(binaryMarshalerType) {
+			return p.marshalBinaryInterface(pv.Interface().(encoding.BinaryMarshaler)), true
+		}
+	}
+
+	return nil, false
+}
+
+// marshalStringer marshals val as the string returned by its String method, if EncodeStringers is enabled and val
+// implements fmt.Stringer. It is only used for the values reflection can't otherwise make anything of.
+func (p *Encoder) marshalStringer(val reflect.Value) (*Value, bool) {
+	if !p.stringers {
+		return nil, false
+	}
+	if val.CanInterface() && val.Type().Implements(stringerType) {
+		return &Value{String, val.Interface().(fmt.Stringer).String()}, true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(stringerType) {
+			return &Value{String, pv.Interface().(fmt.Stringer).String()}, true
+		}
 	}
-	return nil
+	return nil, false
 }
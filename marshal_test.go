@@ -1,7 +1,9 @@
 package plist
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,3 +50,107 @@ func BenchmarkMapMarshal(b *testing.B) {
 		e.marshal(reflect.ValueOf(data))
 	}
 }
+
+func TestKeyNamer(t *testing.T) {
+	lowerFirst := func(name string) string {
+		return strings.ToLower(name[:1]) + name[1:]
+	}
+	type embedded struct {
+		DisplayName string
+	}
+	type settings struct {
+		embedded
+		AutoSave bool
+		MaxItems int    `plist:",omitempty"`
+		Legacy   string `plist:"LegacyKey"`
+	}
+	value := settings{embedded{"Example"}, true, 3, "old"}
+
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		var buf bytes.Buffer
+		enc := NewEncoderForFormat(&buf, format)
+		enc.KeyNamer(lowerFirst)
+		if err := enc.Encode(value); err != nil {
+			t.Fatal(err)
+		}
+
+		var generic map[string]interface{}
+		if _, err := Unmarshal(buf.Bytes(), &generic); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{"displayName": "Example", "autoSave": true, "maxItems": uint64(3), "LegacyKey": "old"}
+		if !reflect.DeepEqual(generic, expected) {
+			t.Errorf("%s: expected %v, received %v", FormatNames[format], expected, generic)
+		}
+
+		var decoded settings
+		dec := NewDecoder(bytes.NewReader(buf.Bytes())).KeyNamer(lowerFirst)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&decoded); err != nil || decoded != value {
+			t.Errorf("%s: expected %+v, received %+v (%v)", FormatNames[format], value, decoded, err)
+		}
+	}
+
+	// The streaming encoder names keys the same way.
+	var buffered, streamed bytes.Buffer
+	for _, stream := range []bool{false, true} {
+		buf := &buffered
+		if stream {
+			buf = &streamed
+		}
+		enc := NewEncoder(buf)
+		enc.KeyNamer(lowerFirst)
+		enc.StreamXML(stream)
+		if err := enc.Encode(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buffered.Bytes(), streamed.Bytes()) {
+		t.Errorf("Expected %s, received %s", buffered.Bytes(), streamed.Bytes())
+	}
+
+	// Namers that change more than case need the Decoder to use them, too.
+	prefixed := func(name string) string {
+		return "x_" + name
+	}
+	data, _ := Marshal(map[string]interface{}{"x_AutoSave": true}, XMLFormat)
+	var decoded settings
+	if err := NewDecoder(bytes.NewReader(data)).KeyNamer(prefixed).Decode(&decoded); err != nil || !decoded.AutoSave {
+		t.Errorf("Expected AutoSave to be decoded from x_AutoSave, received %+v (%v)", decoded, err)
+	}
+
+	// The names are worked out once for each type, however many values of it there are.
+	calls := 0
+	counting := func(name string) string {
+		calls++
+		return lowerFirst(name)
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.KeyNamer(counting)
+	if err := enc.Encode([]settings{value, value, value}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected the namer to be called once for each untagged field when encoding, received %d calls", calls)
+	}
+	calls = 0
+	var decodedList []settings
+	dec := NewDecoder(bytes.NewReader(buf.Bytes())).KeyNamer(counting)
+	if err := dec.Decode(&decodedList); err != nil || len(decodedList) != 3 || decodedList[2] != value {
+		t.Errorf("Expected three copies of %+v, received %+v (%v)", value, decodedList, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected the namer to be called once for each untagged field when decoding, received %d calls", calls)
+	}
+
+	// A new namer replaces the names worked out by the old one.
+	buf.Reset()
+	enc.KeyNamer(prefixed)
+	if err := enc.Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("<key>x_AutoSave</key>")) {
+		t.Errorf("Expected the new namer's keys, received %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,68 @@
+package plist
+
+// Merge returns the result of laying overlay over base, in the generic form returned by ToGeneric.
+//
+// Where base and overlay both hold a dictionary, the dictionaries are merged key by key: keys present in only one of them
+// are kept, and the values of keys present in both are merged recursively. Anywhere else, the value in overlay replaces
+// the one in base; in particular, an array in overlay replaces an array in base entirely. Use MergeConcat to concatenate
+// arrays instead. A nil overlay leaves base unchanged.
+//
+// base and overlay may be any values that Marshal accepts; Merge returns an error wherever Marshal would.
+func Merge(base, overlay interface{}) (interface{}, error) {
+	return merge(base, overlay, false)
+}
+
+// MergeConcat works like Merge, except that where base and overlay both hold an array,
+// the result holds the elements of the array in base followed by those of the array in overlay.
+func MergeConcat(base, overlay interface{}) (interface{}, error) {
+	return merge(base, overlay, true)
+}
+
+func merge(base, overlay interface{}, concat bool) (interface{}, error) {
+	pbase, err := marshalValue(base)
+	if err != nil {
+		return nil, err
+	}
+	poverlay, err := marshalValue(overlay)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := mergePlistValues(pbase, poverlay, concat)
+	if merged == nil {
+		return nil, nil
+	}
+	return (&Decoder{}).valueInterface(merged), nil
+}
+
+func mergePlistValues(base, overlay *Value, concat bool) *Value {
+	if base == nil || overlay == nil {
+		if overlay == nil {
+			return base
+		}
+		return overlay
+	}
+
+	switch {
+	case base.kind == Dictionary && overlay.kind == Dictionary:
+		bd, od := base.value.(*dictionary), overlay.value.(*dictionary)
+		bd.populateArrays()
+		od.populateArrays()
+
+		merged := newDictionary()
+		for i, k := range bd.keys {
+			merged.set(k, bd.values[i])
+		}
+		for i, k := range od.keys {
+			merged.set(k, mergePlistValues(merged.m[k], od.values[i], concat))
+		}
+		return &Value{Dictionary, merged}
+	case concat && base.kind == Array && overlay.kind == Array:
+		ba, oa := base.value.([]*Value), overlay.value.([]*Value)
+		merged := make([]*Value, 0, len(ba)+len(oa))
+		merged = append(merged, ba...)
+		merged = append(merged, oa...)
+		return &Value{Array, merged}
+	}
+	return overlay
+}
@@ -0,0 +1,81 @@
+package plist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	type server struct {
+		Host  string
+		Port  int
+		Flags []string
+	}
+	base := map[string]interface{}{
+		"Name":    "base",
+		"Servers": []string{"a", "b"},
+		"Primary": server{"localhost", 80, []string{"x"}},
+		"Limits":  map[string]interface{}{"CPU": 1, "Memory": map[string]int{"Soft": 1, "Hard": 2}},
+	}
+	overlay := map[string]interface{}{
+		"Name":    "overlay",
+		"Servers": []string{"c"},
+		"Primary": map[string]interface{}{"Port": 8080, "Flags": []string{"y"}, "TLS": true},
+		"Limits":  map[string]interface{}{"Memory": map[string]int{"Hard": 4}, "Disk": 10},
+		"Extra":   "added",
+	}
+
+	merged, err := Merge(base, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"Name":    "overlay",
+		"Servers": []interface{}{"c"},
+		"Primary": map[string]interface{}{"Host": "localhost", "Port": uint64(8080), "Flags": []interface{}{"y"}, "TLS": true},
+		"Limits":  map[string]interface{}{"CPU": uint64(1), "Memory": map[string]interface{}{"Soft": uint64(1), "Hard": uint64(4)}, "Disk": uint64(10)},
+		"Extra":   "added",
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, merged)
+	}
+
+	merged, err = MergeConcat(base, overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected["Servers"] = []interface{}{"a", "b", "c"}
+	expected["Primary"].(map[string]interface{})["Flags"] = []interface{}{"x", "y"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, merged)
+	}
+
+	// The inputs are left unchanged.
+	if base["Name"] != "base" || len(base["Limits"].(map[string]interface{})) != 2 {
+		t.Errorf("Expected base to be unchanged, received %v", base)
+	}
+
+	replacements := []struct {
+		name                  string
+		base, overlay, merged interface{}
+	}{
+		{"scalar over dictionary", map[string]int{"a": 1}, "scalar", "scalar"},
+		{"dictionary over scalar", "scalar", map[string]int{"a": 1}, map[string]interface{}{"a": uint64(1)}},
+		{"array over dictionary", map[string]int{"a": 1}, []int{1}, []interface{}{uint64(1)}},
+		{"nil overlay", map[string]int{"a": 1}, nil, map[string]interface{}{"a": uint64(1)}},
+		{"nil base", nil, "overlay", "overlay"},
+		{"nil both", nil, nil, nil},
+	}
+	for _, test := range replacements {
+		merged, err := Merge(test.base, test.overlay)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(merged, test.merged) {
+			t.Errorf("%s: expected %#v, received %#v", test.name, test.merged, merged)
+		}
+	}
+
+	if _, err := Merge(map[string]interface{}{"a": make(chan int)}, nil); err == nil {
+		t.Error("Expected an error merging a channel")
+	}
+}
@@ -0,0 +1,93 @@
+package plist
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// A Number holds an integer or real from a property list in decimal form, like json.Number, so that its value can
+// be kept exactly until the caller decides how to interpret it. Integers of any size are written without a fraction
+// or exponent; reals are written in the shortest form that parses back to the same value.
+//
+// Numbers encode as integers if they are written as integers, and as 64-bit reals otherwise.
+// Integers that need more than 64 bits cannot be encoded.
+type Number string
+
+var numberType = reflect.TypeOf(Number(""))
+
+// String returns the number's decimal form.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns the number as an int64. It returns an error if the number is not an integer or does not fit.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Uint64 returns the number as a uint64. It returns an error if the number is not a non-negative integer or does not fit.
+func (n Number) Uint64() (uint64, error) {
+	return strconv.ParseUint(string(n), 10, 64)
+}
+
+// Float64 returns the number as a float64, rounding integers that have no exact representation.
+func (n Number) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// BigInt returns the exact value of the number as a *big.Int. It returns an error if the number is not an integer.
+func (n Number) BigInt() (*big.Int, error) {
+	i, ok := new(big.Int).SetString(string(n), 10)
+	if !ok {
+		return nil, fmt.Errorf("plist: %q is not an integer", string(n))
+	}
+	return i, nil
+}
+
+// numberFromValue returns the decimal form of an integer or real object.
+func numberFromValue(pval *Value) Number {
+	if pval.kind == Real {
+		f := pval.value.(sizedFloat)
+		return Number(strconv.FormatFloat(f.value, 'g', -1, f.bits))
+	}
+	return Number(integerBigValue(pval.value).String())
+}
+
+// marshalNumber returns the integer or real object n describes.
+func (p *Encoder) marshalNumber(val reflect.Value) *Value {
+	n := val.Interface().(Number)
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		switch {
+		case i.IsInt64():
+			return p.marshalInteger(signedInt{uint64(i.Int64()), true})
+		case i.IsUint64():
+			return p.marshalInteger(signedInt{i.Uint64(), false})
+		}
+		panic(&UnsupportedValueError{val, fmt.Sprintf("integer %s does not fit in 64 bits", string(n))})
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil && !math.IsInf(f, 0) {
+		panic(&UnsupportedValueError{val, fmt.Sprintf("%q is not a number", string(n))})
+	}
+	return &Value{Real, sizedFloat{f, 64}}
+}
+
+// unmarshalNumber stores the decimal form of an integer or real object in val, which holds a Number.
+// In lax mode, strings that are numbers are stored as they are.
+func (p *Decoder) unmarshalNumber(pval *Value, val reflect.Value) {
+	switch {
+	case pval.kind == Integer, pval.kind == Real:
+		val.Set(reflect.ValueOf(numberFromValue(pval)))
+	case pval.kind == String && p.lax:
+		s := pval.value.(string)
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			panic(err)
+		}
+		val.Set(reflect.ValueOf(Number(s)))
+	default:
+		panic(&TypeMismatchError{Expected: val.Type(), Got: pval.kind})
+	}
+}
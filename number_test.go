@@ -0,0 +1,89 @@
+package plist
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestNumber(t *testing.T) {
+	type numbers struct {
+		Large    Number
+		Negative Number
+		Precise  Number
+		Single   Number
+	}
+	precise := 0.1
+	precise += 0.2 // at run time, so that it is inexact
+	value := map[string]interface{}{
+		"Large":    uint64(math.MaxUint64),
+		"Negative": int64(math.MinInt64),
+		"Precise":  precise,
+		"Single":   float32(0.1),
+	}
+	expected := numbers{"18446744073709551615", "-9223372036854775808", "0.30000000000000004", "0.1"}
+
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		data, err := Marshal(value, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded numbers
+		if _, err := Unmarshal(data, &decoded); err != nil || decoded != expected {
+			t.Errorf("%s: expected %+v, received %+v (%v)", FormatNames[format], expected, decoded, err)
+		}
+
+		// Numbers encode as the objects they came from.
+		reencoded, err := Marshal(decoded, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var generic map[string]interface{}
+		if _, err := Unmarshal(reencoded, &generic); err != nil {
+			t.Fatal(err)
+		}
+		if generic["Large"] != uint64(math.MaxUint64) || generic["Negative"] != int64(math.MinInt64) || generic["Precise"] != precise {
+			t.Errorf("%s: expected the numbers to survive encoding, received %v", FormatNames[format], generic)
+		}
+	}
+
+	if _, err := expected.Large.Int64(); err == nil {
+		t.Error("Expected Int64 to fail for a number above math.MaxInt64")
+	}
+	if u, err := expected.Large.Uint64(); err != nil || u != math.MaxUint64 {
+		t.Errorf("Expected %d, received %d (%v)", uint64(math.MaxUint64), u, err)
+	}
+	if i, err := expected.Negative.Int64(); err != nil || i != math.MinInt64 {
+		t.Errorf("Expected %d, received %d (%v)", int64(math.MinInt64), i, err)
+	}
+	if f, err := expected.Large.Float64(); err != nil || f != math.MaxUint64 {
+		t.Errorf("Expected %v, received %v (%v)", float64(math.MaxUint64), f, err)
+	}
+	if f, err := expected.Precise.Float64(); err != nil || f != precise {
+		t.Errorf("Expected %v, received %v (%v)", precise, f, err)
+	}
+	if _, err := expected.Precise.Int64(); err == nil {
+		t.Error("Expected Int64 to fail for a real")
+	}
+
+	// 128-bit integers are kept exactly.
+	var huge Number
+	if _, err := Unmarshal(int128Bplist(1, 0), &huge); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := huge.BigInt(); err != nil || b.Cmp(new(big.Int).Lsh(big.NewInt(1), 64)) != 0 {
+		t.Errorf("Expected 2^64, received %s (%v)", huge, err)
+	}
+
+	var n Number
+	if _, err := Unmarshal([]byte(`<plist><string>3</string></plist>`), &n); err == nil {
+		t.Error("Expected a string not to decode into a Number in strict mode")
+	}
+	if _, err := Unmarshal([]byte(`{a = 2.5;}`), &map[string]*Number{"a": &n}); err != nil || n != "2.5" {
+		t.Errorf("Expected OpenStep strings to decode into Numbers, received %q (%v)", n, err)
+	}
+	if _, err := Marshal(Number("twelve"), XMLFormat); err == nil || !strings.Contains(err.Error(), "not a number") {
+		t.Errorf("Expected an error encoding a malformed Number, received %v", err)
+	}
+}
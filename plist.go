@@ -1,8 +1,13 @@
 package plist
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
 	"reflect"
 	"sort"
+	"strings"
 )
 
 // Property list format constants
@@ -27,10 +32,11 @@ var FormatNames = map[int]string{
 	GNUStepFormat:  "GNUStep",
 }
 
-type plistKind uint
+// A Kind is the type of a property list object held by a Value.
+type Kind uint
 
 const (
-	Invalid plistKind = iota
+	Invalid Kind = iota
 	Dictionary
 	Array
 	String
@@ -39,9 +45,12 @@ const (
 	Boolean
 	Data
 	Date
+	CFUID
+	CFNull
+	CFSet
 )
 
-var plistKindNames map[plistKind]string = map[plistKind]string{
+var plistKindNames map[Kind]string = map[Kind]string{
 	Invalid:    "invalid",
 	Dictionary: "dictionary",
 	Array:      "array",
@@ -51,13 +60,81 @@ var plistKindNames map[plistKind]string = map[plistKind]string{
 	Boolean:    "boolean",
 	Data:       "data",
 	Date:       "date",
+	CFUID:      "UID",
+	CFNull:     "null",
+	CFSet:      "set",
 }
 
-type plistValue struct {
-	kind  plistKind
+// UID is a reference to another object in the same property list, as used by NSKeyedArchiver.
+// It is stored natively in binary property lists, and as a dictionary containing
+// a single integer with the key "CF$UID" in all other formats. Such a dictionary decodes as a UID
+// into a UID or an empty interface, and as the dictionary it is into any other value.
+type UID uint64
+
+// Null represents the null object (kCFNull), which only binary property lists can store.
+// It is decoded into an interface{} wherever a binary property list contains a null object.
+type Null struct{}
+
+// Set holds the members of a set object (NSSet or CFSet), which only binary property lists can store.
+// Sets decode into an interface{} as Set, and into slices and arrays as property list arrays do; their members are
+// in the order they appear in the property list. A Set encodes as a set object in binary property lists,
+// and as an array in the other formats, which decodes back as an array rather than a Set.
+type Set []interface{}
+
+// DataReader reads the contents of a data object. Decode stores one in any DataReader it decodes data into,
+// without first reading the data into memory when it can: for binary property lists read from an io.ReaderAt
+// (such as a bytes.Reader or an os.File), the DataReader reads the data from the underlying reader as it is read,
+// so that reader must remain open; for XML property lists, it decodes the base64 text as it is read,
+// and reports a malformed encoding as a read error. In other cases, it reads from a copy of the data.
+type DataReader struct {
+	io.Reader
+}
+
+// OrderedDict holds a decoded dictionary along with the order in which its keys appeared in the property list.
+// Values holds the dictionary's values, decoded as they would be into an interface{}.
+//
+// OrderedDict values encode as dictionaries with their entries in the order given by Keys;
+// values whose keys are not listed in Keys are not encoded.
+type OrderedDict struct {
+	Keys   []string
+	Values map[string]interface{}
+}
+
+// A Value is a property list object as it appears in a property list, before it is decoded into a Go value
+// or after a Go value is encoded: unlike the generic values Unmarshal stores in an interface{}, it keeps
+// integers and reals apart, and records the precision of reals and the order of dictionary keys.
+//
+// Obtain a Value from Decoder.DecodeValue or ValueOf, inspect it with its accessors, and encode it with Encoder.EncodeValue.
+// Values also encode as themselves and decode a property list object unchanged, wherever they occur in a Go value.
+// The accessors of the reflect package's Value are mirrored here: calling one on the wrong kind of Value panics.
+type Value struct {
+	kind  Kind
 	value interface{}
 }
 
+// lazyData is the value of a data object whose contents are left in the document until they are decoded.
+// Parsers only produce it when asked to, for destinations that contain a DataReader.
+type lazyData interface {
+	open() io.Reader
+}
+
+// sectionData is a data object in a binary property list, read from the document's io.ReaderAt.
+type sectionData struct {
+	r      io.ReaderAt
+	off, n int64
+}
+
+func (d sectionData) open() io.Reader {
+	return io.NewSectionReader(d.r, d.off, d.n)
+}
+
+// base64Data is the base64-encoded contents of a data element in an XML property list, without whitespace.
+type base64Data string
+
+func (d base64Data) open() io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(string(d)))
+}
+
 type signedInt struct {
 	value  uint64
 	signed bool
@@ -68,11 +145,48 @@ type sizedFloat struct {
 	bits  int
 }
 
+// A dictionary holds its entries in m. keys and values hold the same entries in the order they
+// should be written; dictionaries built by a parser fill them in document order, and all others
+// have them populated (and sorted) on demand. index maps each key to its position in keys, and is
+// built by set when it's first needed.
 type dictionary struct {
 	count  int
-	m      map[string]*plistValue
+	m      map[string]*Value
 	keys   sort.StringSlice
-	values []*plistValue
+	values []*Value
+	index  map[string]int
+}
+
+func newDictionary() *dictionary {
+	return &dictionary{m: make(map[string]*Value)}
+}
+
+// set adds or replaces the entry for key, preserving the order in which keys were first set.
+func (d *dictionary) set(key string, value *Value) {
+	if d.index == nil {
+		d.index = make(map[string]int, len(d.keys))
+		for i, k := range d.keys {
+			d.index[k] = i
+		}
+	}
+	if i, ok := d.index[key]; ok {
+		d.values[i] = value
+	} else if _, ok := d.m[key]; !ok {
+		d.index[key] = len(d.keys)
+		d.keys = append(d.keys, key)
+		d.values = append(d.values, value)
+		d.count++
+	}
+	d.m[key] = value
+}
+
+// setParsed adds an entry read from a document. As in CoreFoundation, a key that appears more than once takes
+// the last of its values, in the position where it first appeared; if disallowDuplicates is set, it's an error instead.
+func (d *dictionary) setParsed(key string, value *Value, disallowDuplicates bool) {
+	if _, ok := d.m[key]; ok && disallowDuplicates {
+		panic(fmt.Errorf("duplicate key %q in dictionary", key))
+	}
+	d.set(key, value)
 }
 
 func (d *dictionary) Len() int {
@@ -86,6 +200,9 @@ func (d *dictionary) Less(i, j int) bool {
 func (d *dictionary) Swap(i, j int) {
 	d.keys.Swap(i, j)
 	d.values[i], d.values[j] = d.values[j], d.values[i]
+	if d.index != nil {
+		d.index[d.keys[i]], d.index[d.keys[j]] = i, j
+	}
 }
 
 func (d *dictionary) populateArrays() {
@@ -95,8 +212,9 @@ func (d *dictionary) populateArrays() {
 
 	l := len(d.m)
 	d.count = l
+	d.index = nil
 	d.keys = make([]string, l)
-	d.values = make([]*plistValue, l)
+	d.values = make([]*Value, l)
 	i := 0
 	for k, v := range d.m {
 		d.keys[i] = k
@@ -106,12 +224,174 @@ func (d *dictionary) populateArrays() {
 	sort.Sort(d)
 }
 
-type unknownTypeError struct {
-	typ reflect.Type
+// panicError returns the error carried by a panic that the package recovers from. Panics raised by the package carry
+// errors, but those raised by other code, such as the methods of a Marshaler or Unmarshaler, may carry anything.
+func panicError(r interface{}) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// uidDictionaryKey is the key under which formats without a native UID type store its value.
+const uidDictionaryKey = "CF$UID"
+
+func uidToDictionary(u UID) *Value {
+	return &Value{Dictionary, &dictionary{m: map[string]*Value{
+		uidDictionaryKey: &Value{Integer, signedInt{uint64(u), false}},
+	}}}
+}
+
+// uidFromDictionary recognizes a dictionary that contains only a CF$UID integer.
+// The parsers leave such dictionaries as they are; they are only taken for UIDs where a UID could be wanted.
+func uidFromDictionary(m map[string]*Value) *Value {
+	if len(m) != 1 {
+		return nil
+	}
+	if v, ok := m[uidDictionaryKey]; ok && v != nil && v.kind == Integer {
+		if n, ok := v.value.(signedInt); ok && !n.signed {
+			return &Value{CFUID, UID(n.value)}
+		}
+	}
+	return nil
+}
+
+// defaultMaxDepth is the deepest nesting of arrays and dictionaries a Decoder accepts unless told otherwise.
+const defaultMaxDepth = 10000
+
+// depthTracker limits how deeply a parser may nest containers, so that malicious input can't exhaust the stack.
+type depthTracker struct {
+	depth, maxDepth int
+}
+
+func (d *depthTracker) enter() {
+	d.depth++
+	if d.depth > d.maxDepth {
+		panic(fmt.Errorf("containers nested more than %d deep", d.maxDepth))
+	}
+}
+
+func (d *depthTracker) leave() {
+	d.depth--
+}
+
+// cancelCheckInterval is the number of values a parser reads between checks of its context.
+const cancelCheckInterval = 1024
+
+// cancelChecker lets a parser abandon a document once its context, if it has one, is cancelled.
+type cancelChecker struct {
+	ctx   context.Context
+	count int
+}
+
+// contextError carries the error of a cancelled context out of a parser, which returns it as it is.
+type contextError struct {
+	error
+}
+
+// checkCancel is called for each value read; every cancelCheckInterval values, it panics if the context is done.
+func (c *cancelChecker) checkCancel() {
+	if c.ctx == nil {
+		return
+	}
+	c.count++
+	if c.count < cancelCheckInterval {
+		return
+	}
+	c.count = 0
+	if err := c.ctx.Err(); err != nil {
+		panic(contextError{err})
+	}
+}
+
+// An UnsupportedTypeError is returned by Marshal when attempting to encode a value of a type
+// that has no property list representation.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "plist: can't marshal value of type " + e.Type.String()
+}
+
+// An UnsupportedValueError is returned by Marshal when attempting to encode a value that
+// cannot be represented, such as a data structure that contains itself.
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "plist: unsupported value: " + e.Str
+}
+
+// An UnsupportedVersionError is returned when decoding a binary property list whose header names a version of the
+// format this package cannot read: any version but "bplist00", such as the "bplist15" and "bplist16" formats used by
+// newer Apple software.
+type UnsupportedVersionError struct {
+	// Version is the two-character version that follows "bplist" in the header.
+	Version string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("plist: unsupported binary property list version %q", e.Version)
+}
+
+// A TypeMismatchError is returned by Unmarshal when a property list object cannot be decoded into a Go value
+// of the type found in its place, such as a dictionary where the destination is a slice.
+type TypeMismatchError struct {
+	// Expected is the type of the Go value that the object was to be decoded into.
+	Expected reflect.Type
+	// Got is the kind of the property list object.
+	Got Kind
+	// KeyPath locates the object in the document, in the form accepted by Get; it is empty for the top-level object.
+	KeyPath string
 }
 
-func (u *unknownTypeError) Error() string {
-	return "plist: can't marshal value of type " + u.typ.String()
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("plist: type mismatch: tried to decode %v into value of type %v at %s", e.Got, e.Expected, pathLocation(e.KeyPath))
+}
+
+// An UnmarshalError records where in a document Unmarshal was when it failed. Errors raised while decoding an object
+// below the top level are wrapped in one, except for a TypeMismatchError, which carries its own key path.
+type UnmarshalError struct {
+	// KeyPath locates the object in the document, in the form accepted by Get.
+	KeyPath string
+	Err     error
+}
+
+func (e *UnmarshalError) Error() string {
+	return e.Err.Error() + " at " + e.KeyPath
+}
+
+// Unwrap returns the underlying error.
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// An InvalidUnmarshalError describes an invalid argument passed to Unmarshal or Decode.
+// (The argument must be a non-nil pointer.)
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "plist: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "plist: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "plist: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// checkDecodeTarget returns an InvalidUnmarshalError unless v is a non-nil pointer that values can be decoded into.
+func checkDecodeTarget(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	return nil
 }
 
 type invalidPlistError struct {
@@ -127,15 +407,36 @@ func (e invalidPlistError) Error() string {
 	return s
 }
 
-type plistParseError struct {
+func (e invalidPlistError) Unwrap() error {
+	return e.err
+}
+
+// A SyntaxError describes a malformed property list.
+type SyntaxError struct {
+	// Offset is the number of bytes of input that had been read when the error was detected.
+	Offset int64
+
+	// Line and Column locate Offset within XML property lists. They count from 1, and are zero for other formats.
+	Line, Column int
+
 	format string
 	err    error
 }
 
-func (e plistParseError) Error() string {
-	s := "plist: error parsing " + e.format + " property list"
+func (e *SyntaxError) Error() string {
+	var s string
+	if e.Line > 0 {
+		s = fmt.Sprintf("plist: error parsing %s property list at line %d, column %d", e.format, e.Line, e.Column)
+	} else {
+		s = fmt.Sprintf("plist: error parsing %s property list at offset %d", e.format, e.Offset)
+	}
 	if e.err != nil {
 		s += ": " + e.err.Error()
 	}
 	return s
 }
+
+// Unwrap returns the underlying cause of the error, if any.
+func (e *SyntaxError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,171 @@
+package plist
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathElement is a single step in a key path: a dictionary key or an array index.
+type pathElement struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (e pathElement) String() string {
+	if e.isIndex {
+		return "[" + strconv.Itoa(e.index) + "]"
+	}
+	if e.key == "" || strings.ContainsAny(e.key, ".[") {
+		return "[" + strconv.Quote(e.key) + "]"
+	}
+	return "." + e.key
+}
+
+// Get parses the property list in data and returns the value at the given key path,
+// as it would be decoded into an interface{}.
+//
+// A key path is a sequence of dictionary keys separated by dots, each of which may be followed by array indices
+// in square brackets, as in "PayloadContent[0].URL". The empty path addresses the top-level value.
+// Keys that contain dots or brackets may be given as quoted strings inside brackets: `Settings["com.example.app"]`.
+//
+// Get returns an error if the path is malformed, if a key is missing or an index is out of range, or if a key or
+// index is applied to a value that is not a dictionary or an array respectively.
+func Get(data []byte, path string) (interface{}, error) {
+	elements, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	pval, err := dec.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	pval, err = lookupPath(pval, elements)
+	if err != nil {
+		return nil, err
+	}
+	return dec.valueInterface(pval), nil
+}
+
+// lookupPath returns the value the key path elements lead to from pval.
+func lookupPath(pval *Value, elements []pathElement) (*Value, error) {
+	var location strings.Builder
+	for _, e := range elements {
+		if pval == nil {
+			return nil, fmt.Errorf("plist: no value at %s", pathLocation(location.String()))
+		}
+
+		switch {
+		case e.isIndex && (pval.kind == Array || pval.kind == CFSet):
+			values := pval.value.([]*Value)
+			if e.index >= len(values) {
+				return nil, fmt.Errorf("plist: index %d out of range for array of length %d at %s", e.index, len(values), pathLocation(location.String()))
+			}
+			pval = values[e.index]
+		case !e.isIndex && pval.kind == Dictionary:
+			subval, ok := pval.value.(*dictionary).m[e.key]
+			if !ok {
+				return nil, fmt.Errorf("plist: key %q not found in dictionary at %s", e.key, pathLocation(location.String()))
+			}
+			pval = subval
+		default:
+			return nil, fmt.Errorf("plist: cannot look up %s in %s at %s", e, plistKindNames[pval.kind], pathLocation(location.String()))
+		}
+		location.WriteString(e.String())
+	}
+
+	if pval == nil {
+		return nil, fmt.Errorf("plist: no value at %s", pathLocation(location.String()))
+	}
+	return pval, nil
+}
+
+// pathLocation describes the position reached by a key path for error messages.
+func pathLocation(location string) string {
+	if location == "" {
+		return "the top level"
+	}
+	return strings.TrimPrefix(location, ".")
+}
+
+// keyPath renders key path elements in the form parsePath accepts.
+func keyPath(elements []pathElement) string {
+	var b strings.Builder
+	for _, e := range elements {
+		b.WriteString(e.String())
+	}
+	return strings.TrimPrefix(b.String(), ".")
+}
+
+// parsePath splits a key path into its keys and indices.
+func parsePath(path string) ([]pathElement, error) {
+	var elements []pathElement
+	for i := 0; i < len(path); {
+		switch {
+		case path[i] == '[':
+			e, n, err := parseBracket(path[i:])
+			if err != nil {
+				return nil, fmt.Errorf("plist: invalid key path %q: %v", path, err)
+			}
+			elements = append(elements, e)
+			i += n
+		case path[i] == '.' && len(elements) == 0, path[i] == '.' && i == len(path)-1:
+			return nil, fmt.Errorf("plist: invalid key path %q: empty key", path)
+		default:
+			if path[i] == '.' {
+				i++
+			} else if len(elements) > 0 {
+				return nil, fmt.Errorf("plist: invalid key path %q: expected . or [ before %q", path, path[i:])
+			}
+			end := strings.IndexAny(path[i:], ".[")
+			if end == -1 {
+				end = len(path) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("plist: invalid key path %q: empty key", path)
+			}
+			elements = append(elements, pathElement{key: path[i : i+end]})
+			i += end
+		}
+	}
+	return elements, nil
+}
+
+// parseBracket parses an index or quoted key in square brackets at the start of s,
+// and returns it along with the number of bytes it occupies.
+func parseBracket(s string) (pathElement, int, error) {
+	if len(s) > 1 && s[1] == '"' {
+		// Find the closing quote, skipping escaped characters.
+		for i := 2; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				key, err := strconv.Unquote(s[1 : i+1])
+				if err != nil {
+					return pathElement{}, 0, err
+				}
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return pathElement{}, 0, fmt.Errorf("expected ] after %s", s[1:i+1])
+				}
+				return pathElement{key: key}, i + 2, nil
+			}
+		}
+		return pathElement{}, 0, fmt.Errorf("unterminated quoted key %s", s[1:])
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end == -1 {
+		return pathElement{}, 0, fmt.Errorf("unterminated index %s", s)
+	}
+	index, err := strconv.ParseUint(s[1:end], 10, 31)
+	if err != nil {
+		return pathElement{}, 0, fmt.Errorf("invalid index %s", s[:end+1])
+	}
+	return pathElement{index: int(index), isIndex: true}, end + 1, nil
+}
@@ -0,0 +1,95 @@
+package plist
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const queryPlist = `<plist><dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>URL</key><string>https://example.com/</string>
+			<key>Ports</key><array><integer>80</integer><integer>443</integer></array>
+		</dict>
+	</array>
+	<key>Settings</key>
+	<dict>
+		<key>com.example.app</key><dict><key>Enabled</key><true/></dict>
+		<key>Name</key><string>settings</string>
+	</dict>
+</dict></plist>`
+
+func TestGet(t *testing.T) {
+	data, err := Marshal(mustGeneric(t, queryPlist), BinaryFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected interface{}
+	}{
+		{"PayloadContent[0].URL", "https://example.com/"},
+		{"PayloadContent[0].Ports[1]", uint64(443)},
+		{"PayloadContent[0].Ports", []interface{}{uint64(80), uint64(443)}},
+		{"Settings.Name", "settings"},
+		{`Settings["com.example.app"].Enabled`, true},
+		{`["Settings"]["Name"]`, "settings"},
+	}
+	for _, doc := range [][]byte{[]byte(queryPlist), data} {
+		for _, test := range tests {
+			v, err := Get(doc, test.path)
+			if err != nil {
+				t.Errorf("%s: %v", test.path, err)
+				continue
+			}
+			if !reflect.DeepEqual(v, test.expected) {
+				t.Errorf("%s: expected %#v, received %#v", test.path, test.expected, v)
+			}
+		}
+
+		if v, err := Get(doc, ""); err != nil || len(v.(map[string]interface{})) != 2 {
+			t.Errorf("Expected the empty path to return the whole document, received %v (%v)", v, err)
+		}
+	}
+
+	// Each failure names the position at which it occurred.
+	failures := map[string]string{
+		"Missing":                       `key "Missing" not found in dictionary at the top level`,
+		"Settings.Missing":              `key "Missing" not found in dictionary at Settings`,
+		"PayloadContent[1]":             `index 1 out of range for array of length 1 at PayloadContent`,
+		"PayloadContent[0].Ports[2]":    `index 2 out of range for array of length 2 at PayloadContent[0].Ports`,
+		"PayloadContent.URL":            `cannot look up .URL in array at PayloadContent`,
+		"Settings[0]":                   `cannot look up [0] in dictionary at Settings`,
+		"Settings.Name.First":           `cannot look up .First in string at Settings.Name`,
+		`Settings["com.example.app"].x`: `key "x" not found in dictionary at Settings["com.example.app"]`,
+		".Settings":                     "empty key",
+		"Settings.":                     "empty key",
+		"Settings..Name":                "empty key",
+		"PayloadContent[x]":             "invalid index",
+		"PayloadContent[-1]":            "invalid index",
+		"PayloadContent[0":              "unterminated index",
+		"PayloadContent[0]URL":          "expected . or [",
+		`Settings["Name`:                "unterminated quoted key",
+	}
+	for path, message := range failures {
+		_, err := Get([]byte(queryPlist), path)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected an error containing %q, received %v", path, message, err)
+		}
+	}
+
+	if _, err := Get([]byte("<plist><dict>"), "a"); err == nil {
+		t.Error("Expected an error querying a malformed property list")
+	}
+}
+
+func mustGeneric(t *testing.T, plist string) interface{} {
+	var v interface{}
+	if _, err := Unmarshal([]byte(plist), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package plist
+
+import (
+	"io"
+	"reflect"
+	"runtime"
+)
+
+// A StreamDecoder decodes the elements of a property list's top-level array one at a time,
+// so that the whole array need not be held in memory.
+type StreamDecoder struct {
+	dec    *Decoder
+	parser parser
+	lax    bool
+
+	// the element read ahead by More
+	next    *Value
+	nextErr error
+	peeked  bool
+	// the index of the next element
+	index int
+}
+
+// Stream reads the beginning of a property list whose top-level value is an array, and returns a StreamDecoder
+// that decodes its elements one at a time. The Decoder's Format field is set as it would be by Decode.
+//
+// XML property lists are read from the stream as their elements are decoded. Binary property lists are read
+// element by element from the underlying reader, which must be buffered in its entirety for a Decoder created by
+// NewDecoderReader. OpenStep and GNUStep property lists are parsed completely before the first element is returned.
+func (p *Decoder) Stream() (*StreamDecoder, error) {
+	p.Format = InvalidFormat
+
+	// As in Decode, lax mode enabled for OpenStep property lists belongs to the StreamDecoder alone.
+	lax := p.lax
+	defer func() {
+		p.lax = lax
+	}()
+
+	var streamParser parser
+	err := p.openDocument(func(parser parser) error {
+		streamParser = parser
+		return parser.startArray()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &StreamDecoder{dec: p, parser: streamParser, lax: p.lax}, nil
+}
+
+func (s *StreamDecoder) peek() {
+	if !s.peeked {
+		s.next, s.nextErr = s.parser.nextElement()
+		s.peeked = true
+	}
+}
+
+// More reports whether there is another element in the array to decode.
+func (s *StreamDecoder) More() bool {
+	s.peek()
+	return s.nextErr != io.EOF
+}
+
+// Decode decodes the next element of the array into the value pointed to by v, as Unmarshal would.
+// Once every element has been decoded, Decode returns io.EOF.
+// The key paths of decoding errors begin with the index of the element in the array.
+func (s *StreamDecoder) Decode(v interface{}) (err error) {
+	// Leave the element for the next call if it can't be decoded into v.
+	if err := checkDecodeTarget(v); err != nil {
+		return err
+	}
+
+	s.peek()
+	if s.nextErr != nil {
+		// Parse errors stick: the parser can't recover from them.
+		return s.nextErr
+	}
+	pval := s.next
+	s.next, s.peeked = nil, false
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = s.dec.annotateError(panicError(r))
+		}
+	}()
+	s.dec.path = append(s.dec.path[:0], pathElement{index: s.index, isIndex: true})
+	s.index++
+
+	lax := s.dec.lax
+	s.dec.lax = s.lax
+	defer func() {
+		s.dec.lax = lax
+	}()
+
+	s.dec.unmarshal(pval, reflect.ValueOf(v))
+	return
+}
@@ -0,0 +1,193 @@
+package plist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type streamRecord struct {
+	Name  string
+	Index int
+	Tags  []string
+}
+
+func streamRecords(n int) []streamRecord {
+	records := make([]streamRecord, n)
+	for i := range records {
+		records[i] = streamRecord{
+			Name:  fmt.Sprintf("record %d", i),
+			Index: i,
+			Tags:  []string{"common", fmt.Sprintf("tag %d", i%7)},
+		}
+	}
+	return records
+}
+
+func TestStreamDecoder(t *testing.T) {
+	records := streamRecords(100)
+	for _, format := range []int{XMLFormat, BinaryFormat, GNUStepFormat} {
+		data, err := Marshal(records, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, dec := range []*Decoder{NewDecoder(bytes.NewReader(data)), NewDecoderReader(bytes.NewBuffer(data))} {
+			stream, err := dec.Stream()
+			if err != nil {
+				t.Fatalf("%s: %v", FormatNames[format], err)
+			}
+			if dec.Format != format {
+				t.Errorf("%s: expected Format to be set, received %s", FormatNames[format], FormatNames[dec.Format])
+			}
+
+			var decoded []streamRecord
+			for stream.More() {
+				var record streamRecord
+				if err := stream.Decode(&record); err != nil {
+					t.Fatalf("%s: %v", FormatNames[format], err)
+				}
+				decoded = append(decoded, record)
+			}
+			if !reflect.DeepEqual(records, decoded) {
+				t.Errorf("%s: expected %d records, received %v", FormatNames[format], len(records), decoded)
+			}
+
+			var record streamRecord
+			if err := stream.Decode(&record); err != io.EOF {
+				t.Errorf("%s: expected io.EOF after the last element, received %v", FormatNames[format], err)
+			}
+		}
+	}
+}
+
+func TestStreamDecoderOpenStep(t *testing.T) {
+	stream, err := NewDecoder(strings.NewReader(`(1, "two", (3))`)).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	var s string
+	var a []int
+	for i, v := range []interface{}{&n, &s, &a} {
+		if err := stream.Decode(v); err != nil {
+			t.Fatalf("element %d: %v", i, err)
+		}
+	}
+	if n != 1 || s != "two" || !reflect.DeepEqual(a, []int{3}) {
+		t.Errorf("Expected 1, two, [3], received %v, %v, %v", n, s, a)
+	}
+	if stream.More() {
+		t.Error("Expected no more elements")
+	}
+}
+
+func TestStreamDecoderErrors(t *testing.T) {
+	notArrays := []string{
+		`<plist><dict/></plist>`,
+		`<plist></plist>`,
+		`<string>a</string>`,
+		`{a=b;}`,
+		`<abab>`,
+	}
+	binaryDict, _ := Marshal(map[string]string{"a": "b"}, BinaryFormat)
+	notArrays = append(notArrays, string(binaryDict))
+
+	for _, plist := range notArrays {
+		if _, err := NewDecoder(strings.NewReader(plist)).Stream(); err == nil {
+			t.Errorf("%q: expected an error streaming a non-array", plist)
+		}
+	}
+
+	stream, err := NewDecoder(strings.NewReader(`<plist><array><integer>1</integer><integer>one</integer></array></plist>`)).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := stream.Decode(&n); err != nil || n != 1 {
+		t.Errorf("Expected 1, received %v (%v)", n, err)
+	}
+	if !stream.More() {
+		t.Error("Expected More to report the malformed element")
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := stream.Decode(&n).(*SyntaxError); !ok {
+			t.Error("Expected a *SyntaxError for the malformed element")
+		}
+	}
+
+	// Elements of the wrong type don't stop the stream.
+	stream, _ = NewDecoder(strings.NewReader(`<plist><array><string>a</string><integer>2</integer></array></plist>`)).Stream()
+	if err := stream.Decode(&n); err == nil {
+		t.Error("Expected an error decoding a string into an int")
+	}
+	if err := stream.Decode(&n); err != nil || n != 2 {
+		t.Errorf("Expected 2, received %v (%v)", n, err)
+	}
+}
+
+// streamXML writes an XML property list containing an array of n records, without building it in memory first.
+type streamXML struct {
+	n, i int
+	buf  bytes.Buffer
+}
+
+func (r *streamXML) Read(b []byte) (int, error) {
+	for r.buf.Len() < len(b) && r.i <= r.n {
+		switch {
+		case r.i == 0:
+			r.buf.WriteString(xmlPreamble + "<plist><array>\n")
+		case r.i == r.n:
+			r.buf.WriteString("</array></plist>\n")
+		default:
+			fmt.Fprintf(&r.buf, "<dict><key>Name</key><string>record %d</string><key>Index</key><integer>%d</integer></dict>\n", r.i, r.i)
+		}
+		r.i++
+	}
+	if r.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.buf.Read(b)
+}
+
+func TestStreamDecoderMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory measurement in short mode")
+	}
+
+	peakHeap := func(n int) uint64 {
+		stream, err := NewDecoderReader(&streamXML{n: n}).Stream()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stats runtime.MemStats
+		var high uint64
+		for i := 0; stream.More(); i++ {
+			var record streamRecord
+			if err := stream.Decode(&record); err != nil {
+				t.Fatal(err)
+			}
+			if i%(n/10) == 0 {
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > high {
+					high = stats.HeapAlloc
+				}
+			}
+		}
+		return high
+	}
+
+	peakHeap(1000) // warm up
+	small, large := peakHeap(10000), peakHeap(100000)
+	t.Logf("Peak heap: %d bytes for 10000 records, %d bytes for 100000 records", small, large)
+	if large > small+4<<20 {
+		t.Errorf("Expected memory use not to grow with the number of records, grew from %d to %d bytes", small, large)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"io"
+	"math/big"
 	"runtime"
 	"strconv"
 	"strings"
@@ -28,7 +29,7 @@ var (
 	padding             = "0000"
 )
 
-func (p *textPlistGenerator) generateDocument(pval *plistValue) {
+func (p *textPlistGenerator) generateDocument(pval *Value) {
 	p.writePlistValue(pval)
 }
 
@@ -103,7 +104,7 @@ func (p *textPlistGenerator) writeIndent() {
 	}
 }
 
-func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
+func (p *textPlistGenerator) writePlistValue(pval *Value) {
 	if pval == nil {
 		return
 	}
@@ -115,6 +116,10 @@ func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
 		dict := pval.value.(*dictionary)
 		dict.populateArrays()
 		for i, k := range dict.keys {
+			if dict.values[i].kind == CFNull {
+				// Only binary property lists can store null objects.
+				continue
+			}
 			p.writeIndent()
 			io.WriteString(p.writer, p.plistQuotedString(k))
 			p.writer.Write(p.dictKvDelimiter)
@@ -124,11 +129,15 @@ func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
 		p.deltaIndent(-1)
 		p.writeIndent()
 		p.writer.Write([]byte(`}`))
-	case Array:
+	case Array, CFSet:
+		// Sets are written as arrays, as in XML property lists.
 		p.writer.Write([]byte(`(`))
 		p.deltaIndent(1)
-		values := pval.value.([]*plistValue)
+		values := pval.value.([]*Value)
 		for _, v := range values {
+			if v == nil || v.kind == CFNull {
+				continue
+			}
 			p.writeIndent()
 			p.writePlistValue(v)
 			p.writer.Write(p.arrayDelimiter)
@@ -142,7 +151,9 @@ func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
 		if p.format == GNUStepFormat {
 			p.writer.Write([]byte(`<*I`))
 		}
-		if pval.value.(signedInt).signed {
+		if n, ok := pval.value.(*big.Int); ok {
+			io.WriteString(p.writer, n.String())
+		} else if pval.value.(signedInt).signed {
 			io.WriteString(p.writer, strconv.FormatInt(int64(pval.value.(signedInt).value), 10))
 		} else {
 			io.WriteString(p.writer, strconv.FormatUint(pval.value.(signedInt).value, 10))
@@ -154,7 +165,8 @@ func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
 		if p.format == GNUStepFormat {
 			p.writer.Write([]byte(`<*R`))
 		}
-		io.WriteString(p.writer, strconv.FormatFloat(pval.value.(sizedFloat).value, 'g', -1, 64))
+		f := pval.value.(sizedFloat)
+		io.WriteString(p.writer, strconv.FormatFloat(f.value, 'g', -1, f.bits))
 		if p.format == GNUStepFormat {
 			p.writer.Write([]byte(`>`))
 		}
@@ -203,6 +215,8 @@ func (p *textPlistGenerator) writePlistValue(pval *plistValue) {
 		} else {
 			io.WriteString(p.writer, p.plistQuotedString(pval.value.(time.Time).In(time.UTC).Format(textPlistTimeLayout)))
 		}
+	case CFUID:
+		p.writePlistValue(uidToDictionary(pval.value.(UID)))
 	}
 }
 
@@ -238,45 +252,141 @@ type byteReader interface {
 	ReadBytes(delim byte) ([]byte, error)
 }
 
+// offsetReader counts the bytes consumed from a byteReader so that errors can report where they occurred.
+type offsetReader struct {
+	byteReader
+	offset int64
+}
+
+func (r *offsetReader) Read(b []byte) (int, error) {
+	n, err := r.byteReader.Read(b)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *offsetReader) ReadByte() (byte, error) {
+	c, err := r.byteReader.ReadByte()
+	if err == nil {
+		r.offset++
+	}
+	return c, err
+}
+
+func (r *offsetReader) UnreadByte() error {
+	err := r.byteReader.UnreadByte()
+	if err == nil {
+		r.offset--
+	}
+	return err
+}
+
+func (r *offsetReader) ReadBytes(delim byte) ([]byte, error) {
+	b, err := r.byteReader.ReadBytes(delim)
+	r.offset += int64(len(b))
+	return b, err
+}
+
 type textPlistParser struct {
-	reader             byteReader
+	depthTracker
+	cancelChecker
+
+	reader             *offsetReader
 	whitespaceReplacer *strings.Replacer
 	format             int
+
+	// disallowDuplicateKeys makes a key that appears twice in a dictionary an error.
+	disallowDuplicateKeys bool
+
+	// The elements of the top-level array, when streaming.
+	elements []*Value
 }
 
-func (p *textPlistParser) parseDocument() (pval *plistValue, parseError error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			if _, ok := r.(invalidPlistError); ok {
-				parseError = r.(error)
-			} else {
-				// Wrap all non-invalid-plist errors.
-				parseError = plistParseError{"text", r.(error)}
-			}
+// recoverError turns a panic raised while parsing into an error; it must be deferred.
+func (p *textPlistParser) recoverError(parseError *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); ok {
+			panic(r)
 		}
-	}()
+		if err, ok := r.(contextError); ok {
+			*parseError = err.error
+			return
+		}
+		if _, ok := r.(invalidPlistError); ok {
+			*parseError = panicError(r)
+		} else {
+			// Wrap all non-invalid-plist errors.
+			*parseError = &SyntaxError{Offset: p.reader.offset, format: "text", err: panicError(r)}
+		}
+	}
+}
+
+func (p *textPlistParser) parseDocument() (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
 	pval = p.parsePlistValue()
 	return
 }
 
+// startArray parses the document, which must be an array, and holds on to its elements.
+// Text property lists are not parsed incrementally.
+func (p *textPlistParser) startArray() (parseError error) {
+	defer p.recoverError(&parseError)
+
+	pval := p.parsePlistValue()
+	if pval == nil || pval.kind != Array {
+		panic(errors.New("top-level value is not an array"))
+	}
+	p.elements = pval.value.([]*Value)
+	return
+}
+
+// parseKey parses the document, which must be a dictionary, and returns the value of key.
+func (p *textPlistParser) parseKey(key string) (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
+	doc := p.parsePlistValue()
+	if doc == nil || doc.kind != Dictionary {
+		panic(errors.New("top-level value is not a dictionary"))
+	}
+	pval = doc.value.(*dictionary).m[key]
+	return
+}
+
+// nextElement returns the next element of the top-level array, or io.EOF after the last one.
+func (p *textPlistParser) nextElement() (*Value, error) {
+	if len(p.elements) == 0 {
+		return nil, io.EOF
+	}
+	pval := p.elements[0]
+	p.elements = p.elements[1:]
+	return pval, nil
+}
+
 func (p *textPlistParser) chugWhitespace() {
 ws:
 	for {
 		c, err := p.reader.ReadByte()
-		if err != nil && err != io.EOF {
+		if err == io.EOF {
+			// Nothing left to chug; the caller decides whether EOF is acceptable.
+			break
+		} else if err != nil {
 			panic(err)
 		}
 		if whitespace[c/64]&(1<<(c%64)) == 0 {
-			if c == '/' && err != io.EOF {
-				// A / at the end of the file is not the begining of a comment.
-				cs, err := p.reader.Peek(1)
+			if c == '/' {
+				// Put it back so that the peek can see both characters: once we peek,
+				// we can no longer unread.
+				p.reader.UnreadByte()
+				cs, err := p.reader.Peek(2)
 				if err != nil && err != io.EOF {
 					panic(err)
 				}
-				c = cs[0]
+				if len(cs) < 2 || (cs[1] != '/' && cs[1] != '*') {
+					break ws // Not the beginning of a // or /* comment
+				}
+				// Peek returned both values here, so it is safe to read them.
+				_, _ = p.reader.ReadByte()
+				c, _ = p.reader.ReadByte()
 				switch c {
 				case '/':
 					for {
@@ -292,8 +402,6 @@ ws:
 						}
 					}
 				case '*':
-					// Peek returned a value here, so it is safe to read.
-					_, _ = p.reader.ReadByte()
 					star := false
 					for {
 						c, err = p.reader.ReadByte()
@@ -308,9 +416,6 @@ ws:
 							star = false
 						}
 					}
-				default:
-					p.reader.UnreadByte() // Not the beginning of a // or /* comment
-					break ws
 				}
 				continue
 			}
@@ -320,9 +425,10 @@ ws:
 	}
 }
 
-func (p *textPlistParser) parseQuotedString() *plistValue {
+func (p *textPlistParser) parseQuotedString() *Value {
 	escaping := false
-	s := ""
+	// Collect bytes rather than runes: unescaped characters are passed through as-is, so UTF-8 survives intact.
+	var s []byte
 	for {
 		byt, err := p.reader.ReadByte()
 		// EOF here is an error: we're inside a quoted string!
@@ -337,47 +443,53 @@ func (p *textPlistParser) parseQuotedString() *plistValue {
 				escaping = true
 				continue
 			}
-		} else {
-			escaping = false
-			// Everything that is not listed here passes through unharmed.
-			switch c {
-			case 'a':
-				c = '\a'
-			case 'b':
-				c = '\b'
-			case 'v':
-				c = '\v'
-			case 'f':
-				c = '\f'
-			case 't':
-				c = '\t'
-			case 'r':
-				c = '\r'
-			case 'n':
-				c = '\n'
-			case 'x', 'u', 'U': // hex and unicode
-				l := 4
-				if c == 'x' {
-					l = 2
-				}
-				hex := make([]byte, l)
-				p.reader.Read(hex)
-				newc := mustParseInt(string(hex), 16, 16)
-				c = rune(newc)
-			case '0', '1', '2', '3', '4', '5', '6', '7': // octal!
-				oct := make([]byte, 3)
-				oct[0] = uint8(c)
-				p.reader.Read(oct[1:])
-				newc := mustParseInt(string(oct), 8, 16)
-				c = rune(newc)
+			s = append(s, byt)
+			continue
+		}
+
+		escaping = false
+		// Everything that is not listed here passes through unharmed.
+		switch c {
+		case 'a':
+			c = '\a'
+		case 'b':
+			c = '\b'
+		case 'v':
+			c = '\v'
+		case 'f':
+			c = '\f'
+		case 't':
+			c = '\t'
+		case 'r':
+			c = '\r'
+		case 'n':
+			c = '\n'
+		case 'x', 'u', 'U': // hex and unicode
+			l := 4
+			if c == 'x' {
+				l = 2
+			}
+			hex := make([]byte, l)
+			if _, err := io.ReadFull(p.reader, hex); err != nil {
+				panic(err)
 			}
+			newc := mustParseUint(string(hex), 16, 16)
+			c = rune(newc)
+		case '0', '1', '2', '3', '4', '5', '6', '7': // octal!
+			oct := make([]byte, 3)
+			oct[0] = uint8(c)
+			if _, err := io.ReadFull(p.reader, oct[1:]); err != nil {
+				panic(err)
+			}
+			newc := mustParseUint(string(oct), 8, 16)
+			c = rune(newc)
 		}
-		s += string(c)
+		s = append(s, string(c)...)
 	}
-	return &plistValue{String, s}
+	return &Value{String, string(s)}
 }
 
-func (p *textPlistParser) parseUnquotedString() *plistValue {
+func (p *textPlistParser) parseUnquotedString() *Value {
 	s := ""
 	for {
 		c, err := p.reader.ReadByte()
@@ -395,12 +507,15 @@ func (p *textPlistParser) parseUnquotedString() *plistValue {
 		}
 		s += string(c)
 	}
-	return &plistValue{String, s}
+	return &Value{String, s}
 }
 
-func (p *textPlistParser) parseDictionary() *plistValue {
-	var keypv *plistValue
-	subval := make(map[string]*plistValue)
+func (p *textPlistParser) parseDictionary() *Value {
+	p.enter()
+	defer p.leave()
+
+	var keypv *Value
+	dict := newDictionary()
 	for {
 		p.chugWhitespace()
 
@@ -446,14 +561,19 @@ func (p *textPlistParser) parseDictionary() *plistValue {
 			panic(errors.New("missing ; in dictionary"))
 		}
 
-		subval[keypv.value.(string)] = val
+		dict.setParsed(keypv.value.(string), val, p.disallowDuplicateKeys)
 	}
-	return &plistValue{Dictionary, &dictionary{m: subval}}
+	return &Value{Dictionary, dict}
 }
 
-func (p *textPlistParser) parseArray() *plistValue {
-	subval := make([]*plistValue, 0, 10)
+func (p *textPlistParser) parseArray() *Value {
+	p.enter()
+	defer p.leave()
+
+	subval := make([]*Value, 0, 10)
 	for {
+		p.chugWhitespace()
+
 		c, err := p.reader.ReadByte()
 		// EOF here is an error: we're inside an array!
 		if err != nil {
@@ -468,15 +588,16 @@ func (p *textPlistParser) parseArray() *plistValue {
 
 		p.reader.UnreadByte()
 		pval := p.parsePlistValue()
-		if pval.kind == String && pval.value.(string) == "" {
-			continue
+		if c != '"' && pval.kind == String && pval.value.(string) == "" {
+			// Nothing was consumed; only a quoted string may be empty.
+			panic(errors.New("invalid character in array"))
 		}
 		subval = append(subval, pval)
 	}
-	return &plistValue{Array, subval}
+	return &Value{Array, subval}
 }
 
-func (p *textPlistParser) parseGNUStepValue(v []byte) *plistValue {
+func (p *textPlistParser) parseGNUStepValue(v []byte) *Value {
 	if len(v) < 3 {
 		panic(errors.New("invalid GNUStep extended value"))
 	}
@@ -486,30 +607,35 @@ func (p *textPlistParser) parseGNUStepValue(v []byte) *plistValue {
 	case 'I':
 		if v[0] == '-' {
 			n := mustParseInt(string(v), 10, 64)
-			return &plistValue{Integer, signedInt{uint64(n), true}}
+			return &Value{Integer, signedInt{uint64(n), true}}
 		} else {
 			n := mustParseUint(string(v), 10, 64)
-			return &plistValue{Integer, signedInt{n, false}}
+			return &Value{Integer, signedInt{n, false}}
 		}
 	case 'R':
 		n := mustParseFloat(string(v), 64)
-		return &plistValue{Real, sizedFloat{n, 64}}
+		return &Value{Real, sizedFloat{n, 64}}
 	case 'B':
-		b := v[0] == 'Y'
-		return &plistValue{Boolean, b}
+		switch v[0] {
+		case 'Y':
+			return &Value{Boolean, true}
+		case 'N':
+			return &Value{Boolean, false}
+		}
+		panic(errors.New("invalid GNUStep boolean " + string(v)))
 	case 'D':
 		t, err := time.Parse(textPlistTimeLayout, string(v))
 		if err != nil {
 			panic(err)
 		}
 
-		return &plistValue{Date, t.In(time.UTC)}
+		return &Value{Date, t.In(time.UTC)}
 	}
 	panic(errors.New("invalid GNUStep type " + string(typ)))
-	return nil
 }
 
-func (p *textPlistParser) parsePlistValue() *plistValue {
+func (p *textPlistParser) parsePlistValue() *Value {
+	p.checkCancel()
 	for {
 		p.chugWhitespace()
 
@@ -525,11 +651,7 @@ func (p *textPlistParser) parsePlistValue() *plistValue {
 			}
 			bytes = bytes[:len(bytes)-1]
 
-			if len(bytes) == 0 {
-				panic(errors.New("invalid empty angle-bracketed element"))
-			}
-
-			if bytes[0] == '*' {
+			if len(bytes) > 0 && bytes[0] == '*' {
 				p.format = GNUStepFormat
 				return p.parseGNUStepValue(bytes)
 			} else {
@@ -538,7 +660,11 @@ func (p *textPlistParser) parsePlistValue() *plistValue {
 				if err != nil {
 					panic(err)
 				}
-				return &plistValue{Data, data}
+				// <> is empty data, which must not be confused with absent data.
+				if data == nil {
+					data = []byte{}
+				}
+				return &Value{Data, data}
 			}
 		case '"':
 			return p.parseQuotedString()
@@ -551,7 +677,6 @@ func (p *textPlistParser) parsePlistValue() *plistValue {
 			return p.parseUnquotedString()
 		}
 	}
-	return nil
 }
 
 func newTextPlistParser(r io.Reader) *textPlistParser {
@@ -562,7 +687,8 @@ func newTextPlistParser(r io.Reader) *textPlistParser {
 		reader = bufio.NewReader(r)
 	}
 	return &textPlistParser{
-		reader:             reader,
+		depthTracker:       depthTracker{maxDepth: defaultMaxDepth},
+		reader:             &offsetReader{byteReader: reader},
 		whitespaceReplacer: strings.NewReplacer("\t", "", "\n", "", " ", "", "\r", ""),
 		format:             OpenStepFormat,
 	}
@@ -3,7 +3,9 @@ package plist
 import (
 	"bytes"
 	"io/ioutil"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func BenchmarkOpenStepGenerate(b *testing.B) {
@@ -65,3 +67,188 @@ func TestTextCommentDecode(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTextDecode(t *testing.T) {
+	var testData = `{
+		dict = {
+			nested = { key = value; "quoted key" = "quoted value"; };
+			empty = {};
+		};
+		array = (a, "b c", (d, e), { f = g; }, <0fbd77 1c2735ae>);
+		escapes = "\a\b\v\f\t\r\n \"quoted\" back\\slash \x41 \101 \U4e16\U754c \Uffe5 \351";
+		utf8 = "Hello, 世界";
+	}`
+	expected := map[string]interface{}{
+		"dict": map[string]interface{}{
+			"nested": map[string]interface{}{
+				"key":        "value",
+				"quoted key": "quoted value",
+			},
+			"empty": map[string]interface{}{},
+		},
+		"array": []interface{}{
+			"a",
+			"b c",
+			[]interface{}{"d", "e"},
+			map[string]interface{}{"f": "g"},
+			[]byte{0x0f, 0xbd, 0x77, 0x1c, 0x27, 0x35, 0xae},
+		},
+		"escapes": "\a\b\v\f\t\r\n \"quoted\" back\\slash A A 世界 ￥ é",
+		"utf8":    "Hello, 世界",
+	}
+
+	var parsed interface{}
+	buf := bytes.NewReader([]byte(testData))
+	decoder := NewDecoder(buf)
+	err := decoder.Decode(&parsed)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if decoder.Format != OpenStepFormat {
+		t.Errorf("Wanted %s, received %s.", FormatNames[OpenStepFormat], FormatNames[decoder.Format])
+	}
+
+	if !reflect.DeepEqual(expected, parsed) {
+		t.Logf("Expected: %#v", expected)
+		t.Logf("Received: %#v", parsed)
+		t.Fail()
+	}
+}
+
+func TestVariousIllegalTextPlists(t *testing.T) {
+	plists := []string{
+		`{a=b`,
+		`{a=b;`,
+		`{a}`,
+		`{a=b,}`,
+		`(a,b`,
+		`"unterminated`,
+		`"bad escape \x4"`,
+		`"bad escape \Uzzzz"`,
+		`<abcz>`,
+		`<*Q3>`,
+		`<*I>`,
+		`(a;b)`,
+		`<*BX>`,
+		`<*Dyesterday>`,
+	}
+
+	for _, plist := range plists {
+		buf := bytes.NewReader([]byte(plist))
+		d := newTextPlistParser(buf)
+		_, err := d.parseDocument()
+		t.Logf("Error: %v", err)
+		if err == nil {
+			t.Errorf("Expected error parsing %s, received nothing.", plist)
+		}
+	}
+}
+
+type textRoundTripData struct {
+	Name     string
+	Quoted   string
+	Unicode  string
+	Count    int
+	Negative int64
+	Ratio    float64
+	Enabled  bool
+	Blob     []byte
+	When     time.Time
+	Tags     []string
+	Nested   map[string]string
+}
+
+func TestOpenStepRoundTrip(t *testing.T) {
+	original := textRoundTripData{
+		Name:     "Dustin",
+		Quoted:   "needs \"quotes\"; and = signs",
+		Unicode:  "Hello, 世界",
+		Count:    42,
+		Negative: -7,
+		Ratio:    0.25,
+		Enabled:  true,
+		Blob:     []byte{0xde, 0xad, 0xbe, 0xef, 0x01},
+		When:     time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC),
+		Tags:     []string{"a", "b c", ""},
+		Nested:   map[string]string{"key": "value", "other key": "other value"},
+	}
+
+	encoded, err := Marshal(original, OpenStepFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded textRoundTripData
+	format, err := Unmarshal(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format != OpenStepFormat {
+		t.Errorf("Wanted %s, received %s.", FormatNames[OpenStepFormat], FormatNames[format])
+	}
+
+	if !reflect.DeepEqual(original, decoded) {
+		t.Logf("Encoded: %s", encoded)
+		t.Logf("Expected: %#v", original)
+		t.Logf("Received: %#v", decoded)
+		t.Fail()
+	}
+}
+
+func TestGNUStepTypedLiterals(t *testing.T) {
+	literals := []struct {
+		text  string
+		value interface{}
+	}{
+		{`<*I42>`, uint64(42)},
+		{`<*I-42>`, int64(-42)},
+		{`<*I18446744073709551615>`, uint64(18446744073709551615)},
+		{`<*R3.25>`, float64(3.25)},
+		{`<*R-1e+100>`, float64(-1e+100)},
+		{`<*BY>`, true},
+		{`<*BN>`, false},
+		{`<*D2013-11-27 00:34:00 +0000>`, time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)},
+	}
+
+	for _, literal := range literals {
+		var decoded interface{}
+		format, err := Unmarshal([]byte(literal.text), &decoded)
+		if err != nil {
+			t.Errorf("%s: %v", literal.text, err)
+			continue
+		}
+
+		if format != GNUStepFormat {
+			t.Errorf("%s: wanted %s, received %s.", literal.text, FormatNames[GNUStepFormat], FormatNames[format])
+		}
+
+		if !reflect.DeepEqual(literal.value, decoded) {
+			t.Errorf("%s: expected %#v, received %#v", literal.text, literal.value, decoded)
+		}
+
+		encoded, err := Marshal(decoded, GNUStepFormat)
+		if err != nil {
+			t.Errorf("%s: %v", literal.text, err)
+			continue
+		}
+
+		if string(encoded) != literal.text {
+			t.Errorf("%s: re-encoded as %s", literal.text, encoded)
+		}
+	}
+}
+
+func TestGNUStepDateTimeZone(t *testing.T) {
+	var decoded time.Time
+	_, err := Unmarshal([]byte(`<*D2013-11-26 19:34:00 -0500>`), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2013, 11, 27, 0, 34, 0, 0, time.UTC)
+	if !decoded.Equal(expected) || decoded.Location() != time.UTC {
+		t.Errorf("Expected %v, received %v", expected, decoded)
+	}
+}
@@ -9,6 +9,8 @@ import (
 // typeInfo holds details for the plist representation of a type.
 type typeInfo struct {
 	fields []fieldInfo
+	// positional is set for structs represented as arrays of their fields, in order, rather than as dictionaries.
+	positional bool
 }
 
 // fieldInfo holds details for the plist representation of a single field.
@@ -16,84 +18,148 @@ type fieldInfo struct {
 	idx       []int
 	name      string
 	omitEmpty bool
+	tagged    bool
 }
 
-var tinfoMap = make(map[reflect.Type]*typeInfo)
+// defaultTagKey is the struct tag key consulted for field names and flags unless another has been chosen.
+const defaultTagKey = "plist"
+
+// tinfoKey identifies the typeInfo for a type read with a particular struct tag key.
+type tinfoKey struct {
+	typ    reflect.Type
+	tagKey string
+}
+
+var tinfoMap = make(map[tinfoKey]*typeInfo)
 var tinfoLock sync.RWMutex
 
+// fieldTag returns f's tag under tagKey, falling back to its plist tag if it has none.
+func fieldTag(f *reflect.StructField, tagKey string) string {
+	if tag, ok := f.Tag.Lookup(tagKey); ok {
+		return tag
+	}
+	return f.Tag.Get(defaultTagKey)
+}
+
 // getTypeInfo returns the typeInfo structure with details necessary
-// for marshalling and unmarshalling typ.
-func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
+// for marshalling and unmarshalling typ, reading struct tags under tagKey.
+// An empty tagKey reads plist tags.
+func getTypeInfo(typ reflect.Type, tagKey string) (*typeInfo, error) {
+	if tagKey == "" {
+		tagKey = defaultTagKey
+	}
+	key := tinfoKey{typ, tagKey}
+
 	tinfoLock.RLock()
-	tinfo, ok := tinfoMap[typ]
+	tinfo, ok := tinfoMap[key]
 	tinfoLock.RUnlock()
 	if ok {
 		return tinfo, nil
 	}
 	tinfo = &typeInfo{}
 	if typ.Kind() == reflect.Struct {
+		var fields []fieldInfo
 		n := typ.NumField()
 		for i := 0; i < n; i++ {
 			f := typ.Field(i)
-			if f.PkgPath != "" || f.Tag.Get("plist") == "-" {
-				continue // Private field
+			tag := fieldTag(&f, tagKey)
+			if tag == "-" {
+				continue // Ignored field
+			}
+
+			// A blank field tagged ",positional" marks the whole struct as positional.
+			if f.Name == "_" {
+				for _, flag := range strings.Split(tag, ",")[1:] {
+					if flag == "positional" {
+						tinfo.positional = true
+					}
+				}
+				continue
 			}
 
-			// For embedded structs, embed its fields.
-			if f.Anonymous {
+			// For untagged embedded structs, embed their fields.
+			if f.Anonymous && strings.Split(tag, ",")[0] == "" {
 				t := f.Type
 				if t.Kind() == reflect.Ptr {
 					t = t.Elem()
 				}
 				if t.Kind() == reflect.Struct {
-					inner, err := getTypeInfo(t)
+					if f.PkgPath != "" && f.Type.Kind() == reflect.Ptr {
+						continue // We couldn't allocate an unexported embedded pointer on decode
+					}
+					inner, err := getTypeInfo(t, tagKey)
 					if err != nil {
 						return nil, err
 					}
 					for _, finfo := range inner.fields {
 						finfo.idx = append([]int{i}, finfo.idx...)
-						if err := addFieldInfo(typ, tinfo, &finfo); err != nil {
-							return nil, err
-						}
+						fields = append(fields, finfo)
 					}
 					continue
 				}
 			}
 
-			finfo, err := structFieldInfo(typ, &f)
-			if err != nil {
-				return nil, err
+			if f.PkgPath != "" {
+				continue // Private field
 			}
 
-			// Add the field if it doesn't conflict with other fields.
-			if err := addFieldInfo(typ, tinfo, finfo); err != nil {
+			finfo, err := structFieldInfo(typ, &f, tag)
+			if err != nil {
 				return nil, err
 			}
+			fields = append(fields, *finfo)
 		}
+		tinfo.fields = dominantFields(fields)
 	}
 	tinfoLock.Lock()
-	tinfoMap[typ] = tinfo
+	tinfoMap[key] = tinfo
 	tinfoLock.Unlock()
 	return tinfo, nil
 }
 
-// structFieldInfo builds and returns a fieldInfo for f.
-func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, error) {
-	finfo := &fieldInfo{idx: f.Index}
+// withKeyNamer returns tinfo with the keys of its untagged fields replaced by those namer gives for their names.
+// The fields are those that won under Go's visibility rules; namer is applied afterwards.
+func (tinfo *typeInfo) withKeyNamer(namer func(string) string) *typeInfo {
+	if namer == nil {
+		return tinfo
+	}
+	renamed := &typeInfo{fields: append([]fieldInfo(nil), tinfo.fields...), positional: tinfo.positional}
+	for i := range renamed.fields {
+		if !renamed.fields[i].tagged {
+			renamed.fields[i].name = namer(renamed.fields[i].name)
+		}
+	}
+	return renamed
+}
 
-	// Split the tag from the xml namespace if necessary.
-	tag := f.Tag.Get("plist")
+// renamedTypeInfo returns tinfo.withKeyNamer(namer), which it keeps in *cache under key so that namer is called
+// only once for each field of a type. The cache belongs to an Encoder or Decoder, and is dropped when its namer changes.
+func renamedTypeInfo(cache *map[tinfoKey]*typeInfo, key tinfoKey, tinfo *typeInfo, namer func(string) string) *typeInfo {
+	if namer == nil || tinfo == nil {
+		return tinfo
+	}
+	if renamed, ok := (*cache)[key]; ok {
+		return renamed
+	}
+	if *cache == nil {
+		*cache = make(map[tinfoKey]*typeInfo)
+	}
+	renamed := tinfo.withKeyNamer(namer)
+	(*cache)[key] = renamed
+	return renamed
+}
+
+// structFieldInfo builds and returns a fieldInfo for f, which bears the struct tag tag.
+func structFieldInfo(typ reflect.Type, f *reflect.StructField, tag string) (*fieldInfo, error) {
+	finfo := &fieldInfo{idx: f.Index}
 
 	// Parse flags.
 	tokens := strings.Split(tag, ",")
 	tag = tokens[0]
-	if len(tokens) > 1 {
-		tag = tokens[0]
-		for _, flag := range tokens[1:] {
-			switch flag {
-			case "omitempty":
-				finfo.omitEmpty = true
-			}
+	for _, flag := range tokens[1:] {
+		switch flag {
+		case "omitempty":
+			finfo.omitEmpty = true
 		}
 	}
 
@@ -105,49 +171,74 @@ func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, erro
 	}
 
 	finfo.name = tag
+	finfo.tagged = true
 	return finfo, nil
 }
 
-// addFieldInfo adds finfo to tinfo.fields if there are no
-// conflicts, or if conflicts arise from previous fields that were
-// obtained from deeper embedded structures than finfo. In the latter
-// case, the conflicting entries are dropped.
-// A conflict occurs when the path (parent + name) to a field is
-// itself a prefix of another path, or when two paths match exactly.
-// It is okay for field paths to share a common, shorter prefix.
-func addFieldInfo(typ reflect.Type, tinfo *typeInfo, newf *fieldInfo) error {
-	var conflicts []int
-	// First, figure all conflicts. Most working code will have none.
-	for i := range tinfo.fields {
-		oldf := &tinfo.fields[i]
-		if newf.name == oldf.name {
-			conflicts = append(conflicts, i)
+// dominantFields resolves conflicts between fields that share a name, following Go's rules
+// for embedded fields: the shallowest field wins. Among fields at the same depth, a single
+// tagged field wins; any other tie is ambiguous, and all of the fields involved are dropped.
+// The surviving fields are returned in their original order.
+func dominantFields(fields []fieldInfo) []fieldInfo {
+	byName := make(map[string][]int, len(fields))
+	for i := range fields {
+		byName[fields[i].name] = append(byName[fields[i].name], i)
+	}
+
+	dominant := fields[:0:0]
+	for i := range fields {
+		candidates := byName[fields[i].name]
+		if candidates[0] != i {
+			// Only consider each name once, when we encounter its first field.
+			continue
+		}
+		if winner, ok := dominantField(fields, candidates); ok {
+			dominant = append(dominant, fields[winner])
 		}
 	}
+	return dominant
+}
 
-	// Without conflicts, add the new field and return.
-	if conflicts == nil {
-		tinfo.fields = append(tinfo.fields, *newf)
-		return nil
+func dominantField(fields []fieldInfo, candidates []int) (int, bool) {
+	depth := len(fields[candidates[0]].idx)
+	for _, i := range candidates[1:] {
+		if len(fields[i].idx) < depth {
+			depth = len(fields[i].idx)
+		}
 	}
 
-	// If any conflict is shallower, ignore the new field.
-	// This matches the Go field resolution on embedding.
-	for _, i := range conflicts {
-		if len(tinfo.fields[i].idx) < len(newf.idx) {
-			return nil
+	var shallowest, tagged []int
+	for _, i := range candidates {
+		if len(fields[i].idx) == depth {
+			shallowest = append(shallowest, i)
+			if fields[i].tagged {
+				tagged = append(tagged, i)
+			}
 		}
 	}
 
-	// Otherwise, the new field is shallower, and thus takes precedence,
-	// so drop the conflicting fields from tinfo and append the new one.
-	for c := len(conflicts) - 1; c >= 0; c-- {
-		i := conflicts[c]
-		copy(tinfo.fields[i:], tinfo.fields[i+1:])
-		tinfo.fields = tinfo.fields[:len(tinfo.fields)-1]
+	switch {
+	case len(shallowest) == 1:
+		return shallowest[0], true
+	case len(tagged) == 1:
+		return tagged[0], true
 	}
-	tinfo.fields = append(tinfo.fields, *newf)
-	return nil
+	return -1, false
+}
+
+// existingValue returns v's field value corresponding to finfo, or the zero Value
+// if reaching it would require dereferencing a nil pointer.
+func (finfo *fieldInfo) existingValue(v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
 }
 
 // value returns v's field value corresponding to finfo.
@@ -1,48 +1,236 @@
 package plist
 
 import (
+	"bytes"
 	"encoding"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/url"
 	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
-type incompatibleDecodeTypeError struct {
+type unknownFieldError struct {
+	typ  reflect.Type
+	keys []string
+}
+
+func (u *unknownFieldError) Error() string {
+	quoted := make([]string, len(u.keys))
+	for i, k := range u.keys {
+		quoted[i] = strconv.Quote(k)
+	}
+	return fmt.Sprintf("plist: unknown key(s) %v for type %v", strings.Join(quoted, ", "), u.typ)
+}
+
+type integerOverflowError struct {
+	value string
+	typ   reflect.Type
+}
+
+func (e *integerOverflowError) Error() string {
+	return fmt.Sprintf("plist: integer %s overflows value of type %v", e.value, e.typ)
+}
+
+type floatOverflowError struct {
+	value float64
 	typ   reflect.Type
-	pKind plistKind
 }
 
-func (u *incompatibleDecodeTypeError) Error() string {
-	return fmt.Sprintf("plist: type mismatch: tried to decode %v into value of type %v", plistKindNames[u.pKind], u.typ)
+func (e *floatOverflowError) Error() string {
+	return fmt.Sprintf("plist: real %v overflows value of type %v", e.value, e.typ)
 }
 
 var (
-	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil)).Elem()
+	maxUint64  = new(big.Int).SetUint64(math.MaxUint64)
+	maxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
 )
 
+// bigIntValue returns the value of an Integer as a big.Int, whatever its size.
+func bigIntValue(pval *Value) *big.Int {
+	if b, ok := pval.value.(*big.Int); ok {
+		return new(big.Int).Set(b)
+	}
+	i := pval.value.(signedInt)
+	if i.signed {
+		return big.NewInt(int64(i.value))
+	}
+	return new(big.Int).SetUint64(i.value)
+}
+
+var (
+	plistUnmarshalerType  = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// annotateError records the key path of the object being unmarshaled in an error that doesn't yet have one.
+// Errors that passed through an Unmarshaler's callback already know where they happened.
+func (p *Decoder) annotateError(err error) error {
+	var located *UnmarshalError
+	var mismatch *TypeMismatchError
+	switch {
+	case errors.As(err, &located):
+	case errors.As(err, &mismatch):
+		if mismatch.KeyPath == "" {
+			mismatch.KeyPath = keyPath(p.path)
+		}
+	case len(p.path) > 0:
+		return &UnmarshalError{KeyPath: keyPath(p.path), Err: err}
+	}
+	return err
+}
+
+// unmarshalAt unmarshals pval, found at elem within the object being unmarshaled, into val.
+// The path is not popped if unmarshaling panics, so that it leads to the object that failed.
+func (p *Decoder) unmarshalAt(elem pathElement, pval *Value, val reflect.Value) {
+	p.path = append(p.path, elem)
+	p.unmarshal(pval, val)
+	p.path = p.path[:len(p.path)-1]
+}
+
 func isEmptyInterface(v reflect.Value) bool {
 	return v.Kind() == reflect.Interface && v.NumMethod() == 0
 }
 
-func (p *Decoder) unmarshalTextInterface(pval *plistValue, unmarshalable encoding.TextUnmarshaler) {
+func (p *Decoder) unmarshalPlistInterface(pval *Value, unmarshalable Unmarshaler) {
+	depth := len(p.path)
+	err := unmarshalable.UnmarshalPlist(func(i interface{}) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(runtime.Error); ok {
+					panic(r)
+				}
+				err = p.annotateError(panicError(r))
+				p.path = p.path[:depth]
+			}
+		}()
+		if err := checkDecodeTarget(i); err != nil {
+			return err
+		}
+		p.unmarshal(pval, reflect.ValueOf(i))
+		return
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (p *Decoder) unmarshalTextInterface(pval *Value, unmarshalable encoding.TextUnmarshaler) {
 	err := unmarshalable.UnmarshalText([]byte(pval.value.(string)))
 	if err != nil {
 		panic(err)
 	}
 }
 
-func (p *Decoder) unmarshalTime(pval *plistValue, val reflect.Value) {
+func (p *Decoder) unmarshalBinaryInterface(pval *Value, unmarshalable encoding.BinaryUnmarshaler) {
+	err := unmarshalable.UnmarshalBinary(dataBytes(pval))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// dataBytes returns the contents of a data object, reading them from the document if the parser left them there.
+func dataBytes(pval *Value) []byte {
+	if lazy, ok := pval.value.(lazyData); ok {
+		b, err := ioutil.ReadAll(lazy.open())
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+	return pval.value.([]byte)
+}
+
+// openData returns a reader over the contents of a data object.
+func openData(pval *Value) io.Reader {
+	if lazy, ok := pval.value.(lazyData); ok {
+		return lazy.open()
+	}
+	return bytes.NewReader(pval.value.([]byte))
+}
+
+// containsType reports whether values of type typ can hold a value of type target, directly or through
+// pointers, slices, arrays, maps and struct fields. seen records the struct types already being examined.
+func containsType(typ, target reflect.Type, seen map[reflect.Type]bool) bool {
+	if typ == nil {
+		return false
+	}
+	if typ == target {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return containsType(typ.Elem(), target, seen)
+	case reflect.Struct:
+		if seen[typ] {
+			return false
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[typ] = true
+		for i := 0; i < typ.NumField(); i++ {
+			if containsType(typ.Field(i).Type, target, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Decoder) unmarshalTime(pval *Value, val reflect.Value) {
 	val.Set(reflect.ValueOf(pval.value.(time.Time)))
 }
 
+// unmarshalDuration parses a duration written as by time.Duration.String. In lax mode, where every
+// value may be a string, a plain integer is taken as a number of nanoseconds.
+func (p *Decoder) unmarshalDuration(s string, val reflect.Value) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		if p.lax {
+			p.unmarshalLaxString(s, val)
+			return
+		}
+		panic(err)
+	}
+	val.SetInt(int64(d))
+}
+
+func (p *Decoder) unmarshalURL(s string, val reflect.Value) {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	val.Set(reflect.ValueOf(*u))
+}
+
 func (p *Decoder) unmarshalLaxString(s string, val reflect.Value) {
 	switch val.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		i := mustParseInt(s, 10, 64)
+		// Accept hexadecimal, as <integer> does.
+		var i int64
+		if len(s) > 0 && s[0] == '-' {
+			s, base := unsignedGetBase(s[1:])
+			i = mustParseInt("-"+s, base, 64)
+		} else {
+			s, base := unsignedGetBase(s)
+			i = mustParseInt(s, base, 64)
+		}
 		val.SetInt(i)
 		return
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		i := mustParseUint(s, 10, 64)
+		s, base := unsignedGetBase(s)
+		i := mustParseUint(s, base, 64)
 		val.SetUint(i)
 		return
 	case reflect.Float32, reflect.Float64:
@@ -50,8 +238,15 @@ func (p *Decoder) unmarshalLaxString(s string, val reflect.Value) {
 		val.SetFloat(f)
 		return
 	case reflect.Bool:
-		b := mustParseBool(s)
-		val.SetBool(b)
+		// Property lists spell booleans YES and NO as often as true and false.
+		switch {
+		case strings.EqualFold(s, "YES"):
+			val.SetBool(true)
+		case strings.EqualFold(s, "NO"):
+			val.SetBool(false)
+		default:
+			val.SetBool(mustParseBool(s))
+		}
 		return
 	case reflect.Struct:
 		if val.Type() == timeType {
@@ -64,29 +259,156 @@ func (p *Decoder) unmarshalLaxString(s string, val reflect.Value) {
 		}
 		fallthrough
 	default:
-		panic(&incompatibleDecodeTypeError{val.Type(), String})
+		panic(&TypeMismatchError{Expected: val.Type(), Got: String})
 	}
 }
 
-func (p *Decoder) unmarshal(pval *plistValue, val reflect.Value) {
+// unmarshalLaxDate decodes a date into a number of seconds since the Unix epoch.
+func (p *Decoder) unmarshalLaxDate(t time.Time, val reflect.Value) {
+	// Unix rounds down, for dates before 1970 as well as after.
+	secs := t.Unix()
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if val.OverflowInt(secs) {
+			panic(&integerOverflowError{strconv.FormatInt(secs, 10), val.Type()})
+		}
+		val.SetInt(secs)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if secs < 0 || val.OverflowUint(uint64(secs)) {
+			panic(&integerOverflowError{strconv.FormatInt(secs, 10), val.Type()})
+		}
+		val.SetUint(uint64(secs))
+	case reflect.Float32, reflect.Float64:
+		val.SetFloat(float64(secs) + float64(t.Nanosecond())/1e9)
+	default:
+		panic(&TypeMismatchError{Expected: val.Type(), Got: Date})
+	}
+}
+
+func (p *Decoder) unmarshalInteger(pval *Value, val reflect.Value) {
+	if val.Type() == bigIntType {
+		val.Set(reflect.ValueOf(*bigIntValue(pval)))
+		return
+	}
+
+	if b, ok := pval.value.(*big.Int); ok {
+		if p.bigIntegers {
+			if (val.Kind() == reflect.Float32 || val.Kind() == reflect.Float64) && p.lax {
+				f, _ := new(big.Float).SetInt(b).Float64()
+				val.SetFloat(f)
+				return
+			}
+			panic(&integerOverflowError{b.String(), val.Type()})
+		}
+		// Without big integer support, keep only the low 64 bits.
+		pval = &Value{Integer, signedInt{new(big.Int).And(b, maxUint64).Uint64(), false}}
+	}
+
+	i := pval.value.(signedInt)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !p.lax && ((!i.signed && i.value > math.MaxInt64) || val.OverflowInt(int64(i.value))) {
+			panic(&integerOverflowError{bigIntValue(pval).String(), val.Type()})
+		}
+		val.SetInt(int64(i.value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !p.lax && ((i.signed && int64(i.value) < 0) || val.OverflowUint(i.value)) {
+			panic(&integerOverflowError{bigIntValue(pval).String(), val.Type()})
+		}
+		val.SetUint(i.value)
+	case reflect.Float32, reflect.Float64:
+		if !p.lax {
+			panic(&TypeMismatchError{Expected: val.Type(), Got: pval.kind})
+		}
+		if i.signed {
+			val.SetFloat(float64(int64(i.value)))
+		} else {
+			val.SetFloat(float64(i.value))
+		}
+	case reflect.Bool:
+		// Some property lists store booleans as the integers 0 and 1.
+		if !p.lax || i.value > 1 {
+			panic(&TypeMismatchError{Expected: val.Type(), Got: pval.kind})
+		}
+		val.SetBool(i.value == 1)
+	default:
+		panic(&TypeMismatchError{Expected: val.Type(), Got: pval.kind})
+	}
+}
+
+func (p *Decoder) unmarshal(pval *Value, val reflect.Value) {
 	if pval == nil {
 		return
 	}
 
-	if val.Kind() == reflect.Ptr {
+	if pval.kind == CFNull {
+		p.unmarshalNull(val)
+		return
+	}
+
+	// Follow any number of pointers, allocating those that are nil, down to the value they point to.
+	for val.Kind() == reflect.Ptr {
 		if val.IsNil() {
 			val.Set(reflect.New(val.Type().Elem()))
 		}
 		val = val.Elem()
 	}
 
+	if val.Type() == valueType {
+		val.Set(reflect.ValueOf(*pval))
+		return
+	}
+
+	if val.Type() == dataReaderType && pval.kind == Data {
+		val.Set(reflect.ValueOf(DataReader{openData(pval)}))
+		return
+	}
+
+	if codec, ok := registeredCodec(val.Type()); ok && codec.dec != nil {
+		p.unmarshalCodec(pval, val, codec.dec)
+		return
+	}
+
+	if val.Type() == numberType {
+		p.unmarshalNumber(pval, val)
+		return
+	}
+
 	if isEmptyInterface(val) {
 		v := p.valueInterface(pval)
 		val.Set(reflect.ValueOf(v))
 		return
 	}
 
-	incompatibleTypeError := &incompatibleDecodeTypeError{val.Type(), pval.kind}
+	if val.Kind() == reflect.Interface && val.IsNil() {
+		if typ, ok := p.registeredType(val.Type()); ok {
+			concrete := reflect.New(typ).Elem()
+			p.unmarshal(pval, concrete)
+			val.Set(concrete)
+			return
+		}
+	}
+
+	if val.CanInterface() && val.Type().Implements(plistUnmarshalerType) {
+		p.unmarshalPlistInterface(pval, val.Interface().(Unmarshaler))
+		return
+	}
+
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(plistUnmarshalerType) {
+			p.unmarshalPlistInterface(pval, pv.Interface().(Unmarshaler))
+			return
+		}
+	}
+
+	if val.Type() == uidType && pval.kind == Dictionary {
+		if uid := uidFromDictionary(pval.value.(*dictionary).m); uid != nil {
+			pval = uid
+		}
+	}
+
+	incompatibleTypeError := &TypeMismatchError{Expected: val.Type(), Got: pval.kind}
 
 	// time.Time implements TextMarshaler, but we need to parse it as RFC3339
 	if pval.kind == Date {
@@ -94,20 +416,51 @@ func (p *Decoder) unmarshal(pval *plistValue, val reflect.Value) {
 			p.unmarshalTime(pval, val)
 			return
 		}
+		if p.lax {
+			p.unmarshalLaxDate(pval.value.(time.Time), val)
+			return
+		}
 		panic(incompatibleTypeError)
 	}
 
-	if val.CanInterface() && val.Type().Implements(textUnmarshalerType) && val.Type() != timeType {
-		p.unmarshalTextInterface(pval, val.Interface().(encoding.TextUnmarshaler))
-		return
+	// Only strings can be handed to a TextUnmarshaler; everything else goes through the usual type checks.
+	if pval.kind == String {
+		if val.Type() == durationType {
+			p.unmarshalDuration(pval.value.(string), val)
+			return
+		}
+		if val.Type() == urlType {
+			p.unmarshalURL(pval.value.(string), val)
+			return
+		}
+		if val.CanInterface() && val.Type().Implements(textUnmarshalerType) && val.Type() != timeType {
+			p.unmarshalTextInterface(pval, val.Interface().(encoding.TextUnmarshaler))
+			return
+		}
+
+		if val.CanAddr() {
+			pv := val.Addr()
+			if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) && val.Type() != timeType {
+				p.unmarshalTextInterface(pval, pv.Interface().(encoding.TextUnmarshaler))
+				return
+			}
+		}
 	}
 
-	if val.CanAddr() {
-		pv := val.Addr()
-		if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) && val.Type() != timeType {
-			p.unmarshalTextInterface(pval, pv.Interface().(encoding.TextUnmarshaler))
+	// Likewise, only data can be handed to a BinaryUnmarshaler.
+	if pval.kind == Data {
+		if val.CanInterface() && val.Type().Implements(binaryUnmarshalerType) && val.Type() != timeType {
+			p.unmarshalBinaryInterface(pval, val.Interface().(encoding.BinaryUnmarshaler))
 			return
 		}
+
+		if val.CanAddr() {
+			pv := val.Addr()
+			if pv.CanInterface() && pv.Type().Implements(binaryUnmarshalerType) && val.Type() != timeType {
+				p.unmarshalBinaryInterface(pval, pv.Interface().(encoding.BinaryUnmarshaler))
+				return
+			}
+		}
 	}
 
 	typ := val.Type()
@@ -125,17 +478,15 @@ func (p *Decoder) unmarshal(pval *plistValue, val reflect.Value) {
 
 		panic(incompatibleTypeError)
 	case Integer:
-		switch val.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			val.SetInt(int64(pval.value.(signedInt).value))
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-			val.SetUint(pval.value.(signedInt).value)
-		default:
-			panic(incompatibleTypeError)
-		}
+		p.unmarshalInteger(pval, val)
 	case Real:
 		if val.Kind() == reflect.Float32 || val.Kind() == reflect.Float64 {
-			val.SetFloat(pval.value.(sizedFloat).value)
+			f := pval.value.(sizedFloat).value
+			// A value just above math.MaxFloat32 may still round down to it, so check for overflow after narrowing.
+			if val.Kind() == reflect.Float32 && math.IsInf(float64(float32(f)), 0) && !math.IsInf(f, 0) && !p.lax {
+				panic(&floatOverflowError{f, typ})
+			}
+			val.SetFloat(f)
 		} else {
 			panic(incompatibleTypeError)
 		}
@@ -147,19 +498,55 @@ func (p *Decoder) unmarshal(pval *plistValue, val reflect.Value) {
 		}
 	case Data:
 		if val.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
-			val.SetBytes(pval.value.([]byte))
+			val.SetBytes(dataBytes(pval))
+		} else if val.Kind() == reflect.Array && typ.Elem().Kind() == reflect.Uint8 {
+			b := dataBytes(pval)
+			if len(b) != val.Len() && !p.lax {
+				panic(fmt.Errorf("plist: attempted to unmarshal %d bytes of data into an array of size %d", len(b), val.Len()))
+			}
+			n := reflect.Copy(val, reflect.ValueOf(b))
+			zero := reflect.Zero(typ.Elem())
+			for i := n; i < val.Len(); i++ {
+				val.Index(i).Set(zero)
+			}
 		} else {
 			panic(incompatibleTypeError)
 		}
-	case Array:
+	case CFUID:
+		switch val.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val.SetInt(int64(pval.value.(UID)))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			val.SetUint(uint64(pval.value.(UID)))
+		default:
+			panic(incompatibleTypeError)
+		}
+	case Array, CFSet:
 		p.unmarshalArray(pval, val)
 	case Dictionary:
 		p.unmarshalDictionary(pval, val)
 	}
 }
 
-func (p *Decoder) unmarshalArray(pval *plistValue, val reflect.Value) {
-	subvalues := pval.value.([]*plistValue)
+// typeInfo returns the details needed to decode into typ with the Decoder's settings.
+func (p *Decoder) typeInfo(typ reflect.Type) *typeInfo {
+	tinfo, err := getTypeInfo(typ, p.tagKey)
+	if err != nil {
+		panic(err)
+	}
+	return renamedTypeInfo(&p.renamed, tinfoKey{typ, p.tagKey}, tinfo, p.keyNamer)
+}
+
+func (p *Decoder) unmarshalArray(pval *Value, val reflect.Value) {
+	subvalues := pval.value.([]*Value)
+
+	if val.Kind() == reflect.Struct {
+		tinfo := p.typeInfo(val.Type())
+		if tinfo.positional {
+			p.unmarshalPositional(subvalues, tinfo, val)
+			return
+		}
+	}
 
 	var n int
 	if val.Kind() == reflect.Slice {
@@ -178,63 +565,220 @@ func (p *Decoder) unmarshalArray(pval *plistValue, val reflect.Value) {
 		n = val.Len()
 		val.SetLen(cnt)
 	} else if val.Kind() == reflect.Array {
-		if len(subvalues) > val.Cap() {
-			panic(fmt.Errorf("plist: attempted to unmarshal %d values into an array of size %d", len(subvalues), val.Cap()))
+		if len(subvalues) != val.Len() {
+			if !p.lax {
+				panic(fmt.Errorf("plist: attempted to unmarshal %d values into an array of size %d", len(subvalues), val.Len()))
+			}
+			// In lax mode, drop any extra values and zero any elements left over.
+			if len(subvalues) > val.Len() {
+				subvalues = subvalues[:val.Len()]
+			}
+			zero := reflect.Zero(val.Type().Elem())
+			for i := len(subvalues); i < val.Len(); i++ {
+				val.Index(i).Set(zero)
+			}
 		}
 	} else {
-		panic(&incompatibleDecodeTypeError{val.Type(), pval.kind})
+		panic(&TypeMismatchError{Expected: val.Type(), Got: pval.kind})
 	}
 
 	// Recur to read element into slice.
-	for _, sval := range subvalues {
-		p.unmarshal(sval, val.Index(n))
+	for i, sval := range subvalues {
+		p.unmarshalAt(pathElement{index: i, isIndex: true}, sval, val.Index(n))
 		n++
 	}
 	return
 }
 
-func (p *Decoder) unmarshalDictionary(pval *plistValue, val reflect.Value) {
+// unmarshalPositional decodes the members of an array into the fields of a positional struct, in order.
+// In lax mode, extra members are dropped and fields left over are unchanged.
+func (p *Decoder) unmarshalPositional(subvalues []*Value, tinfo *typeInfo, val reflect.Value) {
+	if len(subvalues) != len(tinfo.fields) {
+		if !p.lax {
+			panic(fmt.Errorf("plist: attempted to unmarshal %d values into positional struct %v of %d fields", len(subvalues), val.Type(), len(tinfo.fields)))
+		}
+		if len(subvalues) > len(tinfo.fields) {
+			subvalues = subvalues[:len(tinfo.fields)]
+		}
+	}
+
+	for i, sval := range subvalues {
+		p.unmarshalAt(pathElement{index: i, isIndex: true}, sval, tinfo.fields[i].value(val))
+	}
+}
+
+func (p *Decoder) unmarshalOrderedDict(dict *dictionary, val reflect.Value) {
+	dict.populateArrays()
+	od := OrderedDict{
+		Keys:   make([]string, len(dict.keys)),
+		Values: make(map[string]interface{}, len(dict.keys)),
+	}
+	copy(od.Keys, dict.keys)
+	for i, k := range dict.keys {
+		od.Values[k] = p.valueInterface(dict.values[i])
+	}
+	val.Set(reflect.ValueOf(od))
+}
+
+// fieldKeys returns the keys of dict that are decoded into the fields of tinfo, in the order of tinfo.fields,
+// with an empty string for a field that no key matches. A key equal to the field's name is preferred; failing that,
+// as in encoding/json, the last key that matches it case-insensitively is used, unless that key is the exact name
+// of another field. Unlike encoding/json, which takes whichever matching key comes last, an exact match wins over
+// keys that differ only in case wherever they appear.
+func fieldKeys(dict *dictionary, tinfo *typeInfo) []string {
+	exact := make(map[string]bool, len(tinfo.fields))
+	for i := range tinfo.fields {
+		exact[tinfo.fields[i].name] = true
+	}
+
+	folded := make(map[string]string)
+	dict.populateArrays()
+	for _, k := range dict.keys {
+		if !exact[k] {
+			folded[strings.ToLower(k)] = k
+		}
+	}
+
+	keys := make([]string, len(tinfo.fields))
+	for i := range tinfo.fields {
+		name := tinfo.fields[i].name
+		if _, ok := dict.m[name]; ok {
+			keys[i] = name
+		} else if k, ok := folded[strings.ToLower(name)]; ok {
+			keys[i] = k
+		}
+	}
+	return keys
+}
+
+func (p *Decoder) checkUnknownFields(dict *dictionary, keys []string, typ reflect.Type) {
+	known := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k != "" {
+			known[k] = true
+		}
+	}
+
+	var unknown []string
+	dict.populateArrays()
+	for _, k := range dict.keys {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+
+	if len(unknown) > 0 {
+		panic(&unknownFieldError{typ, unknown})
+	}
+}
+
+func (p *Decoder) unmarshalMapKey(k string, typ reflect.Type) reflect.Value {
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) && typ.Kind() != reflect.String {
+		keyv := reflect.New(typ)
+		if err := keyv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(k)); err != nil {
+			panic(err)
+		}
+		return keyv.Elem()
+	}
+
+	keyv := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		keyv.SetString(k)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(k, 10, typ.Bits())
+		if err != nil {
+			panic(fmt.Errorf("plist: invalid dictionary key %q for map key type %v: %v", k, typ, err))
+		}
+		keyv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(k, 10, typ.Bits())
+		if err != nil {
+			panic(fmt.Errorf("plist: invalid dictionary key %q for map key type %v: %v", k, typ, err))
+		}
+		keyv.SetUint(n)
+	}
+	return keyv
+}
+
+func (p *Decoder) unmarshalDictionary(pval *Value, val reflect.Value) {
 	typ := val.Type()
+	if typ == orderedDictType {
+		p.unmarshalOrderedDict(pval.value.(*dictionary), val)
+		return
+	}
+
 	switch val.Kind() {
 	case reflect.Struct:
-		tinfo, err := getTypeInfo(typ)
-		if err != nil {
-			panic(err)
+		tinfo := p.typeInfo(typ)
+
+		dict := pval.value.(*dictionary)
+		keys := fieldKeys(dict, tinfo)
+		if p.disallowUnknownFields {
+			p.checkUnknownFields(dict, keys, typ)
 		}
 
-		subvalues := pval.value.(*dictionary).m
-		for _, finfo := range tinfo.fields {
-			p.unmarshal(subvalues[finfo.name], finfo.value(val))
+		for i, k := range keys {
+			if k != "" {
+				p.unmarshalAt(pathElement{key: k}, dict.m[k], tinfo.fields[i].value(val))
+			}
 		}
 	case reflect.Map:
 		if val.IsNil() {
 			val.Set(reflect.MakeMap(typ))
 		}
 
+		if !isValidMapKeyType(typ.Key()) && !reflect.PtrTo(typ.Key()).Implements(textUnmarshalerType) {
+			panic(&TypeMismatchError{Expected: typ, Got: pval.kind})
+		}
+
 		subvalues := pval.value.(*dictionary).m
 		for k, sval := range subvalues {
-			keyv := reflect.ValueOf(k).Convert(typ.Key())
+			keyv := p.unmarshalMapKey(k, typ.Key())
 			mapElem := val.MapIndex(keyv)
 			if !mapElem.IsValid() {
 				mapElem = reflect.New(typ.Elem()).Elem()
 			}
 
-			p.unmarshal(sval, mapElem)
+			p.unmarshalAt(pathElement{key: k}, sval, mapElem)
 			val.SetMapIndex(keyv, mapElem)
 		}
 	default:
-		panic(&incompatibleDecodeTypeError{typ, pval.kind})
+		panic(&TypeMismatchError{Expected: typ, Got: pval.kind})
 	}
 }
 
 /* *Interface is modelled after encoding/json */
-func (p *Decoder) valueInterface(pval *plistValue) interface{} {
+// unmarshalNull sets pointers, maps, slices and interfaces to nil, or an empty interface{} to Null.
+// Other values are left unchanged, as there is nothing in them for a null object to represent.
+func (p *Decoder) unmarshalNull(val reflect.Value) {
+	if val.Kind() == reflect.Ptr && !val.CanSet() {
+		val = val.Elem()
+	}
+
+	switch {
+	case isEmptyInterface(val):
+		val.Set(reflect.ValueOf(Null{}))
+	case val.Kind() == reflect.Ptr, val.Kind() == reflect.Map, val.Kind() == reflect.Slice, val.Kind() == reflect.Interface:
+		val.Set(reflect.Zero(val.Type()))
+	}
+}
+
+func (p *Decoder) valueInterface(pval *Value) interface{} {
 	switch pval.kind {
+	case CFNull:
+		return Null{}
 	case String:
 		return pval.value.(string)
 	case Integer:
-		if pval.value.(signedInt).signed {
-			return int64(pval.value.(signedInt).value)
+		if b, ok := pval.value.(*big.Int); ok {
+			if p.bigIntegers {
+				return b
+			}
+			return new(big.Int).And(b, maxUint64).Uint64()
+		}
+		if n := pval.value.(signedInt); n.signed && int64(n.value) < 0 || p.signedIntegers && n.value <= math.MaxInt64 {
+			return int64(n.value)
 		}
 		return pval.value.(signedInt).value
 	case Real:
@@ -248,21 +792,31 @@ func (p *Decoder) valueInterface(pval *plistValue) interface{} {
 	case Boolean:
 		return pval.value.(bool)
 	case Array:
-		return p.arrayInterface(pval.value.([]*plistValue))
+		return p.arrayInterface(pval.value.([]*Value))
+	case CFSet:
+		return Set(p.arrayInterface(pval.value.([]*Value)))
 	case Dictionary:
+		if uid := uidFromDictionary(pval.value.(*dictionary).m); uid != nil {
+			return uid.value.(UID)
+		}
 		return p.dictionaryInterface(pval.value.(*dictionary))
 	case Data:
-		return pval.value.([]byte)
+		return dataBytes(pval)
 	case Date:
 		return pval.value.(time.Time)
+	case CFUID:
+		return pval.value.(UID)
 	}
 	return nil
 }
 
-func (p *Decoder) arrayInterface(subvalues []*plistValue) []interface{} {
-	out := make([]interface{}, len(subvalues))
-	for i, subv := range subvalues {
-		out[i] = p.valueInterface(subv)
+func (p *Decoder) arrayInterface(subvalues []*Value) []interface{} {
+	out := make([]interface{}, 0, len(subvalues))
+	for _, subv := range subvalues {
+		// Marshal leaves nil values in arrays for the generators to skip.
+		if subv != nil {
+			out = append(out, p.valueInterface(subv))
+		}
 	}
 	return out
 }
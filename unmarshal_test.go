@@ -1,6 +1,7 @@
 package plist
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 	"time"
@@ -30,3 +31,47 @@ func BenchmarkInterfaceUnmarshal(b *testing.B) {
 		d.unmarshal(plistValueTree, reflect.ValueOf(&xval))
 	}
 }
+
+type positionalRecord struct {
+	_      struct{} `plist:",positional"`
+	Name   string
+	Age    int
+	Active bool
+}
+
+func TestPositionalStruct(t *testing.T) {
+	expected := positionalRecord{Name: "Ada", Age: 36, Active: true}
+	for _, format := range []int{XMLFormat, BinaryFormat, GNUStepFormat} {
+		data, err := Marshal([]interface{}{"Ada", 36, true}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record positionalRecord
+		if _, err := Unmarshal(data, &record); err != nil || record != expected {
+			t.Errorf("%s: expected %+v, received %+v (%v)", FormatNames[format], expected, record, err)
+		}
+
+		// Positional structs encode as arrays, too.
+		encoded, err := Marshal(expected, format)
+		if err != nil || !bytes.Equal(encoded, data) {
+			t.Errorf("%s: expected %s, received %s (%v)", FormatNames[format], data, encoded, err)
+		}
+	}
+
+	short, _ := Marshal([]interface{}{"Ada", 36}, XMLFormat)
+	var record positionalRecord
+	if _, err := Unmarshal(short, &record); err == nil {
+		t.Errorf("Expected an error decoding a short array, received %+v", record)
+	}
+	record = positionalRecord{Active: true}
+	if err := NewDecoder(bytes.NewReader(short)).Lax(true).Decode(&record); err != nil || record != expected {
+		t.Errorf("Expected lax decoding to leave Active alone, received %+v (%v)", record, err)
+	}
+
+	if _, err := Marshal(struct {
+		_    struct{} `plist:",positional"`
+		Name *string
+	}{}, XMLFormat); err == nil {
+		t.Error("Expected an error encoding a positional struct with a nil field")
+	}
+}
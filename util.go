@@ -1,6 +1,11 @@
 package plist
 
-import "io"
+import (
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
 
 type countedWriter struct {
 	io.Writer
@@ -23,3 +28,61 @@ func unsignedGetBase(s string) (string, int) {
 	}
 	return s, 10
 }
+
+// utf16Reader converts a stream of UTF-16 text in the given byte order into UTF-8.
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+
+	in  []byte // bytes read but not yet converted: an odd byte, or a leading surrogate
+	out []byte // converted bytes not yet returned
+	err error
+}
+
+func newUTF16Reader(r io.Reader, order binary.ByteOrder) *utf16Reader {
+	return &utf16Reader{r: r, order: order}
+}
+
+func (r *utf16Reader) Read(b []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			if r.err == io.EOF && len(r.in) > 0 {
+				// A truncated code unit or lone surrogate at the end of the stream.
+				r.in = r.in[:0]
+				r.out = append(r.out, string(utf8.RuneError)...)
+				break
+			}
+			return 0, r.err
+		}
+
+		buf := make([]byte, len(r.in)+len(b)*2+2)
+		n := copy(buf, r.in)
+		m, err := r.r.Read(buf[n:])
+		r.err = err
+		buf = buf[:n+m]
+
+		var rb [utf8.UTFMax]byte
+		i := 0
+		for ; i+1 < len(buf); i += 2 {
+			c := rune(r.order.Uint16(buf[i:]))
+			if utf16.IsSurrogate(c) && c < 0xDC00 {
+				if i+3 >= len(buf) {
+					// Wait for the trailing surrogate.
+					break
+				}
+				c = utf16.DecodeRune(c, rune(r.order.Uint16(buf[i+2:])))
+				if c != utf8.RuneError {
+					i += 2
+				}
+			} else if utf16.IsSurrogate(c) {
+				c = utf8.RuneError
+			}
+			r.out = append(r.out, rb[:utf8.EncodeRune(rb[:], c)]...)
+		}
+		r.in = append(r.in[:0], buf[i:]...)
+	}
+
+	n := copy(b, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
@@ -0,0 +1,149 @@
+package plist
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// String returns the name of the kind, as used in error messages: "dictionary", "integer" and so on.
+func (k Kind) String() string {
+	if name, ok := plistKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("Kind(%d)", uint(k))
+}
+
+// ValueOf returns the property list object that Marshal would encode for v.
+// A nil v, or one that Marshal would discard, returns a nil Value.
+// ValueOf returns an error wherever Marshal would.
+//
+// Values may be nested in the Go values given to ValueOf, so a tree obtained from Decoder.DecodeValue
+// can be rebuilt with some of its members replaced.
+func ValueOf(v interface{}) (*Value, error) {
+	return marshalValue(v)
+}
+
+// Kind returns the kind of property list object v holds.
+func (v *Value) Kind() Kind {
+	return v.kind
+}
+
+// mustBe panics if v is not of one of the given kinds, in the manner of the reflect package.
+func (v *Value) mustBe(method string, kinds ...Kind) {
+	for _, k := range kinds {
+		if v.kind == k {
+			return
+		}
+	}
+	panic(fmt.Errorf("plist: call of Value.%s on %v value", method, v.kind))
+}
+
+// String returns the string v holds. Like reflect.Value, it does not panic for other kinds of value,
+// but returns a string of the form "<integer Value>".
+func (v *Value) String() string {
+	if v.kind != String {
+		return "<" + v.kind.String() + " Value>"
+	}
+	return v.value.(string)
+}
+
+// Int returns the integer v holds as an int64. Unsigned integers above math.MaxInt64 wrap around,
+// and integers that need more than 64 bits are truncated; use BigInt to retrieve them faithfully.
+// It panics if v is not an integer.
+func (v *Value) Int() int64 {
+	return int64(v.Uint())
+}
+
+// Uint returns the integer v holds as a uint64. Negative integers wrap around,
+// and integers that need more than 64 bits are truncated. It panics if v is not an integer.
+func (v *Value) Uint() uint64 {
+	v.mustBe("Uint", Integer)
+	if n, ok := v.value.(*big.Int); ok {
+		return new(big.Int).And(n, maxUint64).Uint64()
+	}
+	return v.value.(signedInt).value
+}
+
+// BigInt returns the exact value of the integer v holds. It panics if v is not an integer.
+func (v *Value) BigInt() *big.Int {
+	v.mustBe("BigInt", Integer)
+	return new(big.Int).Set(integerBigValue(v.value))
+}
+
+// Float returns the real v holds. It panics if v is not a real.
+func (v *Value) Float() float64 {
+	v.mustBe("Float", Real)
+	return v.value.(sizedFloat).value
+}
+
+// FloatBits returns the precision of the real v holds: 32 or 64 bits. It panics if v is not a real.
+func (v *Value) FloatBits() int {
+	v.mustBe("FloatBits", Real)
+	return v.value.(sizedFloat).bits
+}
+
+// Bool returns the boolean v holds. It panics if v is not a boolean.
+func (v *Value) Bool() bool {
+	v.mustBe("Bool", Boolean)
+	return v.value.(bool)
+}
+
+// Bytes returns the data v holds. It panics if v is not data.
+func (v *Value) Bytes() []byte {
+	v.mustBe("Bytes", Data)
+	return v.value.([]byte)
+}
+
+// Time returns the date v holds. It panics if v is not a date.
+func (v *Value) Time() time.Time {
+	v.mustBe("Time", Date)
+	return v.value.(time.Time)
+}
+
+// UID returns the UID v holds. It panics if v is not a UID.
+func (v *Value) UID() UID {
+	v.mustBe("UID", CFUID)
+	return v.value.(UID)
+}
+
+// Len returns the number of members of the array, set or dictionary v holds.
+// It panics if v is none of these.
+func (v *Value) Len() int {
+	v.mustBe("Len", Array, CFSet, Dictionary)
+	if v.kind == Dictionary {
+		return len(v.value.(*dictionary).m)
+	}
+	return len(v.value.([]*Value))
+}
+
+// Index returns the i'th member of the array or set v holds. It panics if v is neither, or if i is out of range.
+// Arrays built by ValueOf may contain nil members, which are not encoded.
+func (v *Value) Index(i int) *Value {
+	v.mustBe("Index", Array, CFSet)
+	return v.value.([]*Value)[i]
+}
+
+// Keys returns the keys of the dictionary v holds, in the order they appear in the property list.
+// The keys of dictionaries built by ValueOf are sorted, as they will be encoded. It panics if v is not a dictionary.
+func (v *Value) Keys() []string {
+	v.mustBe("Keys", Dictionary)
+	d := v.value.(*dictionary)
+	d.populateArrays()
+	keys := make([]string, 0, len(d.m))
+	seen := make(map[string]bool, len(d.m))
+	for _, k := range d.keys {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// MapIndex returns the value stored under key in the dictionary v holds, or nil if there is none.
+// It panics if v is not a dictionary.
+func (v *Value) MapIndex(key string) *Value {
+	v.mustBe("MapIndex", Dictionary)
+	return v.value.(*dictionary).m[key]
+}
@@ -0,0 +1,210 @@
+package plist
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValueRoundTrip(t *testing.T) {
+	date := time.Date(2019, 8, 7, 6, 5, 4, 0, time.UTC)
+	doc := map[string]interface{}{
+		"Integer":  3,
+		"Real":     3.0,
+		"Real32":   float32(0.5),
+		"Negative": -7,
+		"String":   "3",
+		"Bool":     true,
+		"Data":     []byte{1, 2},
+		"Date":     date,
+		"Array":    []interface{}{1, 1.0, "1"},
+	}
+
+	for _, format := range []int{XMLFormat, BinaryFormat} {
+		data, err := Marshal(doc, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pval, err := NewDecoder(bytes.NewReader(data)).DecodeValue()
+		if err != nil {
+			t.Fatalf("%s: %v", FormatNames[format], err)
+		}
+		if pval.Kind() != Dictionary || pval.Len() != len(doc) {
+			t.Fatalf("%s: expected a dictionary of %d entries, received %v", FormatNames[format], len(doc), pval.Kind())
+		}
+		if keys := pval.Keys(); !reflect.DeepEqual(keys, []string{"Array", "Bool", "Data", "Date", "Integer", "Negative", "Real", "Real32", "String"}) {
+			t.Errorf("%s: expected keys in document order, received %v", FormatNames[format], keys)
+		}
+
+		kinds := map[string]Kind{"Integer": Integer, "Real": Real, "Real32": Real, "Negative": Integer, "String": String, "Bool": Boolean, "Data": Data, "Date": Date, "Array": Array}
+		for key, kind := range kinds {
+			if k := pval.MapIndex(key).Kind(); k != kind {
+				t.Errorf("%s: expected %s to be %v, received %v", FormatNames[format], key, kind, k)
+			}
+		}
+		if i := pval.MapIndex("Integer").Int(); i != 3 {
+			t.Errorf("%s: expected 3, received %d", FormatNames[format], i)
+		}
+		if i := pval.MapIndex("Negative").BigInt(); i.Cmp(big.NewInt(-7)) != 0 {
+			t.Errorf("%s: expected -7, received %v", FormatNames[format], i)
+		}
+		if f := pval.MapIndex("Real").Float(); f != 3 {
+			t.Errorf("%s: expected 3.0, received %v", FormatNames[format], f)
+		}
+		if format == BinaryFormat && pval.MapIndex("Real32").FloatBits() != 32 {
+			t.Errorf("%s: expected a 32-bit real", FormatNames[format])
+		}
+		if s := pval.MapIndex("String").String(); s != "3" {
+			t.Errorf("%s: expected \"3\", received %q", FormatNames[format], s)
+		}
+		if !pval.MapIndex("Bool").Bool() || !bytes.Equal(pval.MapIndex("Data").Bytes(), []byte{1, 2}) || !pval.MapIndex("Date").Time().Equal(date) {
+			t.Errorf("%s: unexpected boolean, data or date", FormatNames[format])
+		}
+		array := pval.MapIndex("Array")
+		if array.Len() != 3 || array.Index(0).Kind() != Integer || array.Index(1).Kind() != Real || array.Index(2).Kind() != String {
+			t.Errorf("%s: expected an integer, a real and a string, received %v, %v and %v", FormatNames[format], array.Index(0).Kind(), array.Index(1).Kind(), array.Index(2).Kind())
+		}
+		if pval.MapIndex("Missing") != nil {
+			t.Errorf("%s: expected no value for a missing key", FormatNames[format])
+		}
+
+		// Encoding the tree, in either format, reproduces the document.
+		for _, outFormat := range []int{XMLFormat, BinaryFormat} {
+			buf := &bytes.Buffer{}
+			if err := NewEncoderForFormat(buf, outFormat).EncodeValue(pval); err != nil {
+				t.Fatalf("%s to %s: %v", FormatNames[format], FormatNames[outFormat], err)
+			}
+			reencoded, err := NewDecoder(bytes.NewReader(buf.Bytes())).DecodeValue()
+			if err != nil {
+				t.Fatalf("%s to %s: %v", FormatNames[format], FormatNames[outFormat], err)
+			}
+			if !plistValuesEqual(pval, reencoded) {
+				t.Errorf("%s to %s: expected the tree to survive encoding, received %s", FormatNames[format], FormatNames[outFormat], buf.Bytes())
+			}
+		}
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	inner, err := ValueOf([]interface{}{1, 2.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values can be nested inside other Go values, and decoded into.
+	outer, err := ValueOf(map[string]interface{}{"Inner": inner, "Name": "outer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Marshal(outer, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<key>Inner</key><array><integer>1</integer><real>2.5</real></array>") {
+		t.Errorf("Expected the inner array to be encoded, received %s", data)
+	}
+
+	var decoded struct {
+		Inner *Value
+		Name  Value
+	}
+	if _, err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Inner == nil || decoded.Inner.Kind() != Array || decoded.Inner.Index(0).Kind() != Integer {
+		t.Errorf("Expected Inner to hold the array, received %v", decoded.Inner)
+	}
+	if decoded.Name.String() != "outer" {
+		t.Errorf("Expected Name to hold \"outer\", received %v", decoded.Name.String())
+	}
+
+	if pval, err := ValueOf(nil); pval != nil || err != nil {
+		t.Errorf("Expected a nil Value for nil, received %v (%v)", pval, err)
+	}
+	if _, err := Marshal(&Value{}, XMLFormat); err == nil {
+		t.Error("Expected an error encoding the zero Value")
+	}
+	if s := inner.String(); s != "<array Value>" {
+		t.Errorf("Expected <array Value>, received %s", s)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Int to panic on an array")
+		}
+	}()
+	inner.Int()
+}
+
+type panickingMarshaler struct{}
+
+func (panickingMarshaler) MarshalPlist() (interface{}, error) {
+	v, _ := ValueOf([]int{1})
+	return v.Int(), nil
+}
+
+type panickingUnmarshaler struct{}
+
+func (*panickingUnmarshaler) UnmarshalPlist(unmarshal func(interface{}) error) error {
+	panic("not an error")
+}
+
+func TestNonErrorPanics(t *testing.T) {
+	// Panics that don't carry errors are reported as errors rather than crashing the recovery.
+	if _, err := Marshal(panickingMarshaler{}, XMLFormat); err == nil || !strings.Contains(err.Error(), "call of Value.Uint on array value") {
+		t.Errorf("Expected an error from Value.Int on an array, received %v", err)
+	}
+	if _, err := ValueOf(map[string]interface{}{"a": panickingMarshaler{}}); err == nil {
+		t.Error("Expected an error from ValueOf")
+	}
+
+	var u panickingUnmarshaler
+	if _, err := Unmarshal([]byte(`<string>a</string>`), &u); err == nil || err.Error() != "not an error" {
+		t.Errorf("Expected the panic to be returned as an error, received %v", err)
+	}
+	var m map[string]panickingUnmarshaler
+	if err := NewDecoder(strings.NewReader(`<dict><key>a</key><string>a</string></dict>`)).DecodeKey("a", &u); err == nil {
+		t.Error("Expected DecodeKey to return an error")
+	}
+	if _, err := Unmarshal([]byte(`<dict><key>a</key><string>a</string></dict>`), &m); err == nil || err.Error() != "not an error at a" {
+		t.Errorf("Expected the panic to be returned as an error at a, received %v", err)
+	}
+}
+
+func TestDictionarySet(t *testing.T) {
+	str := func(s string) *Value { return &Value{String, s} }
+	check := func(name string, d *dictionary, keys, values []string) {
+		t.Helper()
+		received := make([]string, len(d.values))
+		for i, v := range d.values {
+			received[i] = v.String()
+		}
+		if !reflect.DeepEqual([]string(d.keys), keys) || !reflect.DeepEqual(received, values) || d.Len() != len(keys) {
+			t.Errorf("%s: expected %v = %v, received %v = %v", name, keys, values, d.keys, received)
+		}
+		for i, k := range keys {
+			if d.m[k].String() != values[i] {
+				t.Errorf("%s: expected %s = %s in the map, received %v", name, k, values[i], d.m[k])
+			}
+		}
+	}
+
+	d := newDictionary()
+	d.set("b", str("1"))
+	d.set("a", str("2"))
+	d.set("c", str("3"))
+	d.set("a", str("4"))
+	check("set", d, []string{"b", "a", "c"}, []string{"1", "4", "3"})
+
+	// Sorting moves the entries, and later sets have to find them where they went.
+	d = &dictionary{m: map[string]*Value{"b": str("1"), "a": str("2"), "c": str("3")}}
+	d.populateArrays()
+	d.set("c", str("4"))
+	d.set("a", str("5"))
+	d.set("d", str("6"))
+	check("sorted", d, []string{"a", "b", "c", "d"}, []string{"5", "1", "4", "6"})
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -18,34 +21,75 @@ const xmlDOCTYPE = `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http:/
 type xmlPlistGenerator struct {
 	writer     io.Writer
 	xmlEncoder *xml.Encoder
-}
 
-func (p *xmlPlistGenerator) generateDocument(pval *plistValue) {
-	io.WriteString(p.writer, xml.Header)
-	io.WriteString(p.writer, xmlDOCTYPE)
-
-	plistStartElement := xml.StartElement{
-		Name: xml.Name{
-			Space: "",
-			Local: "plist",
-		},
-		Attr: []xml.Attr{{
-			Name: xml.Name{
-				Space: "",
-				Local: "version"},
-			Value: "1.0"},
-		},
-	}
+	// compact drops the line breaks after the XML declaration and DOCTYPE.
+	compact bool
+
+	// omitDoctype drops the DOCTYPE altogether.
+	omitDoctype bool
 
-	p.xmlEncoder.EncodeToken(plistStartElement)
+	// dataWrap is the number of base64 characters written per line in <data>, or zero for no wrapping.
+	dataWrap int
 
+	// dateLayout, if set, replaces RFC 3339 as the layout of <date> elements.
+	dateLayout string
+
+	indent string
+	depth  int
+}
+
+func (p *xmlPlistGenerator) generateDocument(pval *Value) {
+	p.openDocument()
 	p.writePlistValue(pval)
+	p.closeDocument()
+}
 
-	p.xmlEncoder.EncodeToken(plistStartElement.End())
+// openDocument writes everything that comes before the top-level value.
+// As in the output of plutil, the <plist> element is not indented around the top-level value,
+// which begins at the start of its own line, so it is written here rather than by the XML encoder.
+func (p *xmlPlistGenerator) openDocument() {
+	header, doctype := xml.Header, xmlDOCTYPE
+	if p.omitDoctype {
+		doctype = ""
+	}
+	if p.compact {
+		header, doctype = strings.TrimSuffix(header, "\n"), strings.TrimSuffix(doctype, "\n")
+	}
+	io.WriteString(p.writer, header)
+	io.WriteString(p.writer, doctype)
+	io.WriteString(p.writer, `<plist version="1.0">`)
+	if p.indent != "" {
+		io.WriteString(p.writer, "\n")
+	}
+}
+
+// closeDocument writes everything that comes after the top-level value.
+func (p *xmlPlistGenerator) closeDocument() {
 	p.xmlEncoder.Flush()
+	if p.indent != "" {
+		io.WriteString(p.writer, "\n")
+	}
+	io.WriteString(p.writer, "</plist>")
+}
+
+// openContainer writes the start tag of a dict or array element, whose contents are written next.
+func (p *xmlPlistGenerator) openContainer(name string) {
+	p.xmlEncoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}})
+	p.depth++
+}
+
+// closeContainer writes the end tag of a dict or array element opened by openContainer.
+func (p *xmlPlistGenerator) closeContainer(name string) {
+	p.depth--
+	p.xmlEncoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
 }
 
-func (p *xmlPlistGenerator) writePlistValue(pval *plistValue) {
+// writeKey writes the key of the next value in a dictionary.
+func (p *xmlPlistGenerator) writeKey(key string) {
+	p.xmlEncoder.EncodeElement(key, xml.StartElement{Name: xml.Name{Local: "key"}})
+}
+
+func (p *xmlPlistGenerator) writePlistValue(pval *Value) {
 	if pval == nil {
 		return
 	}
@@ -56,35 +100,45 @@ func (p *xmlPlistGenerator) writePlistValue(pval *plistValue) {
 	encodedValue := pval.value
 	switch pval.kind {
 	case Dictionary:
-		startElement := xml.StartElement{Name: xml.Name{Local: "dict"}}
-		p.xmlEncoder.EncodeToken(startElement)
+		p.openContainer("dict")
 		dict := encodedValue.(*dictionary)
 		dict.populateArrays()
 		for i, k := range dict.keys {
-			p.xmlEncoder.EncodeElement(k, xml.StartElement{Name: xml.Name{Local: "key"}})
+			if dict.values[i].kind == CFNull {
+				// Only binary property lists can store null objects.
+				continue
+			}
+			p.writeKey(k)
 			p.writePlistValue(dict.values[i])
 		}
-		p.xmlEncoder.EncodeToken(startElement.End())
-	case Array:
-		startElement := xml.StartElement{Name: xml.Name{Local: "array"}}
-		p.xmlEncoder.EncodeToken(startElement)
-		values := encodedValue.([]*plistValue)
+		p.closeContainer("dict")
+	case Array, CFSet:
+		// XML property lists have no sets; they store them as arrays.
+		p.openContainer("array")
+		values := encodedValue.([]*Value)
 		for _, v := range values {
+			if v == nil || v.kind == CFNull {
+				continue
+			}
 			p.writePlistValue(v)
 		}
-		p.xmlEncoder.EncodeToken(startElement.End())
+		p.closeContainer("array")
 	case String:
 		key = "string"
 	case Integer:
 		key = "integer"
-		if pval.value.(signedInt).signed {
+		if n, ok := pval.value.(*big.Int); ok {
+			encodedValue = n.String()
+		} else if pval.value.(signedInt).signed {
 			encodedValue = int64(pval.value.(signedInt).value)
 		} else {
 			encodedValue = pval.value.(signedInt).value
 		}
 	case Real:
 		key = "real"
-		encodedValue = pval.value.(sizedFloat).value
+		// Format the value at its own precision, so that a float32 is written as the shortest decimal that round-trips to it.
+		f := pval.value.(sizedFloat)
+		encodedValue = strconv.FormatFloat(f.value, 'g', -1, f.bits)
 		switch {
 		case math.IsInf(pval.value.(sizedFloat).value, 1):
 			encodedValue = "inf"
@@ -101,11 +155,22 @@ func (p *xmlPlistGenerator) writePlistValue(pval *plistValue) {
 		}
 		encodedValue = ""
 	case Data:
+		encoded := base64.StdEncoding.EncodeToString(pval.value.([]byte))
+		if p.dataWrap > 0 && len(encoded) > p.dataWrap {
+			p.writeWrappedData(encoded)
+			break
+		}
 		key = "data"
-		encodedValue = xml.CharData(base64.StdEncoding.EncodeToString(pval.value.([]byte)))
+		encodedValue = xml.CharData(encoded)
 	case Date:
 		key = "date"
-		encodedValue = pval.value.(time.Time).In(time.UTC).Format(time.RFC3339)
+		layout := time.RFC3339
+		if p.dateLayout != "" {
+			layout = p.dateLayout
+		}
+		encodedValue = pval.value.(time.Time).In(time.UTC).Format(layout)
+	case CFUID:
+		p.writePlistValue(uidToDictionary(pval.value.(UID)))
 	}
 	if key != "" {
 		err := p.xmlEncoder.EncodeElement(encodedValue, xml.StartElement{Name: xml.Name{Local: key}})
@@ -115,36 +180,130 @@ func (p *xmlPlistGenerator) writePlistValue(pval *plistValue) {
 	}
 }
 
+// writeWrappedData writes a <data> element whose base64 content is split into lines of dataWrap characters.
+// When indenting, the lines are set apart from the tags and indented to the same depth, as CoreFoundation does.
+func (p *xmlPlistGenerator) writeWrappedData(encoded string) {
+	startElement := xml.StartElement{Name: xml.Name{Local: "data"}}
+	p.xmlEncoder.EncodeToken(startElement)
+	// The encoder would escape the tabs in the indentation, so the content is written around it.
+	p.xmlEncoder.Flush()
+
+	separator := "\n"
+	if p.indent != "" {
+		separator += strings.Repeat(p.indent, p.depth)
+		io.WriteString(p.writer, separator)
+	}
+	for len(encoded) > p.dataWrap {
+		io.WriteString(p.writer, encoded[:p.dataWrap])
+		io.WriteString(p.writer, separator)
+		encoded = encoded[p.dataWrap:]
+	}
+	io.WriteString(p.writer, encoded)
+	if p.indent != "" {
+		io.WriteString(p.writer, separator)
+	}
+
+	p.xmlEncoder.EncodeToken(startElement.End())
+}
+
 func (p *xmlPlistGenerator) Indent(i string) {
+	p.indent = i
 	p.xmlEncoder.Indent("", i)
 }
 
 func newXMLPlistGenerator(w io.Writer) *xmlPlistGenerator {
 	mw := mustWriter{w}
-	return &xmlPlistGenerator{mw, xml.NewEncoder(mw)}
+	return &xmlPlistGenerator{writer: mw, xmlEncoder: xml.NewEncoder(mw)}
+}
+
+// lineWindow is how far behind the end of its input a lineReader can map offsets to positions.
+// It comfortably exceeds the amount of input the XML decoder buffers ahead of its InputOffset.
+const lineWindow = 64 * 1024
+
+// lineReader records where each line of its input begins, so that offsets can be reported as lines and columns.
+// Only lines in the last lineWindow bytes are remembered, so that long documents can be streamed.
+type lineReader struct {
+	io.Reader
+	offset int64
+	lines  []int64 // the offset of the first byte of each line after the first
+
+	discarded      int   // the number of lines dropped from the front of lines
+	discardedStart int64 // the start of the last line dropped
+}
+
+func (r *lineReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	for i, c := range b[:n] {
+		if c == '\n' {
+			r.lines = append(r.lines, r.offset+int64(i)+1)
+		}
+	}
+	r.offset += int64(n)
+
+	if len(r.lines) > 1024 && r.lines[0] < r.offset-lineWindow {
+		cut := sort.Search(len(r.lines), func(i int) bool { return r.lines[i] >= r.offset-lineWindow })
+		r.discarded += cut
+		r.discardedStart = r.lines[cut-1]
+		r.lines = append(r.lines[:0], r.lines[cut:]...)
+	}
+	return n, err
+}
+
+func (r *lineReader) position(offset int64) (line, column int) {
+	i := sort.Search(len(r.lines), func(i int) bool { return r.lines[i] > offset })
+	start := r.discardedStart
+	if i > 0 {
+		start = r.lines[i-1]
+	}
+	return r.discarded + i + 1, int(offset-start) + 1
 }
 
 type xmlPlistParser struct {
-	reader             io.Reader
+	depthTracker
+	cancelChecker
+
+	reader             *lineReader
 	xmlDecoder         *xml.Decoder
 	whitespaceReplacer *strings.Replacer
 	ntags              int
+
+	// lazyData, if set, leaves the contents of data elements base64-encoded, as base64Data.
+	lazyData bool
+	// disallowDuplicateKeys makes a key that appears twice in a dictionary an error.
+	disallowDuplicateKeys bool
+	// dateLayout, if set, is tried before RFC 3339 when parsing <date> elements.
+	dateLayout string
+
+	// streamDone is set once the end of the top-level array has been read, when streaming.
+	streamDone bool
+	// streamInPlist records whether the top-level array is wrapped in a <plist> element, when streaming.
+	streamInPlist bool
 }
 
-func (p *xmlPlistParser) parseDocument() (pval *plistValue, parseError error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			if _, ok := r.(invalidPlistError); ok {
-				parseError = r.(error)
-			} else {
-				// Wrap all non-invalid-plist errors.
-				parseError = plistParseError{"XML", r.(error)}
-			}
+// recoverError turns a panic raised while parsing into an error; it must be deferred.
+func (p *xmlPlistParser) recoverError(parseError *error) {
+	if r := recover(); r != nil {
+		if _, ok := r.(runtime.Error); ok {
+			panic(r)
+		}
+		if err, ok := r.(contextError); ok {
+			*parseError = err.error
+			return
+		}
+		if _, ok := r.(invalidPlistError); ok {
+			*parseError = panicError(r)
+		} else {
+			// Wrap all non-invalid-plist errors.
+			offset := p.xmlDecoder.InputOffset()
+			line, column := p.reader.position(offset)
+			*parseError = &SyntaxError{offset, line, column, "XML", panicError(r)}
 		}
-	}()
+	}
+}
+
+func (p *xmlPlistParser) parseDocument() (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
 	for {
 		if token, err := p.xmlDecoder.Token(); err == nil {
 			if element, ok := token.(xml.StartElement); ok {
@@ -152,6 +311,8 @@ func (p *xmlPlistParser) parseDocument() (pval *plistValue, parseError error) {
 				if p.ntags == 0 {
 					panic(invalidPlistError{"XML", errors.New("no elements encountered")})
 				}
+				// An empty <plist> element has already been read to its end.
+				p.parseEnd(element.Name.Local == "plist" && pval != nil)
 				return
 			}
 		} else {
@@ -162,7 +323,167 @@ func (p *xmlPlistParser) parseDocument() (pval *plistValue, parseError error) {
 	}
 }
 
-func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
+// startArray reads up to the start of the top-level array, leaving the parser positioned at its first element.
+func (p *xmlPlistParser) startArray() (parseError error) {
+	defer p.recoverError(&parseError)
+
+	var element xml.StartElement
+	for {
+		token, err := p.xmlDecoder.Token()
+		if err != nil {
+			// As in parseDocument, this is not an XML property list at all.
+			panic(invalidPlistError{"XML", err})
+		}
+		if el, ok := token.(xml.StartElement); ok {
+			element = el
+			break
+		}
+	}
+
+	if element.Name.Local == "plist" {
+		p.ntags++
+		p.streamInPlist = true
+		element = p.nextStartElement("plist")
+	}
+
+	if element.Name.Local != "array" {
+		if element.Name.Local != "" {
+			// Parse the value anyway, so that documents that aren't XML property lists are reported as such.
+			p.parseXMLElement(element)
+		}
+		panic(errors.New("top-level value is not an array"))
+	}
+	p.ntags++
+	p.enter()
+	return
+}
+
+// parseKey reads up to the top-level dictionary, then skips its entries until it finds key, and parses that key's value.
+func (p *xmlPlistParser) parseKey(key string) (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
+	var element xml.StartElement
+	for {
+		token, err := p.xmlDecoder.Token()
+		if err != nil {
+			// As in parseDocument, this is not an XML property list at all.
+			panic(invalidPlistError{"XML", err})
+		}
+		if el, ok := token.(xml.StartElement); ok {
+			element = el
+			break
+		}
+	}
+
+	if element.Name.Local == "plist" {
+		p.ntags++
+		element = p.nextStartElement("plist")
+	}
+
+	if element.Name.Local != "dict" {
+		if element.Name.Local != "" {
+			// Parse the value anyway, so that documents that aren't XML property lists are reported as such.
+			p.parseXMLElement(element)
+		}
+		panic(errors.New("top-level value is not a dictionary"))
+	}
+	p.ntags++
+	p.enter()
+	defer p.leave()
+
+	for {
+		keyElement := p.nextStartElement("dict")
+		if keyElement.Name.Local == "" {
+			return nil, nil
+		}
+		if keyElement.Name.Local != "key" {
+			panic(errors.New("missing key in dictionary"))
+		}
+		var k string
+		if err := p.xmlDecoder.DecodeElement(&k, &keyElement); err != nil {
+			panic(err)
+		}
+
+		valueElement := p.nextStartElement("dict")
+		if valueElement.Name.Local == "" || valueElement.Name.Local == "key" {
+			panic(fmt.Errorf("missing value for key %q in dictionary", k))
+		}
+		if k == key {
+			pval = p.parseXMLElement(valueElement)
+			return
+		}
+		if err := p.xmlDecoder.Skip(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// nextElement returns the next element of the top-level array, or io.EOF after the last one.
+func (p *xmlPlistParser) nextElement() (pval *Value, parseError error) {
+	defer p.recoverError(&parseError)
+
+	if p.streamDone {
+		return nil, io.EOF
+	}
+
+	element := p.nextStartElement("array")
+	if element.Name.Local == "" {
+		p.streamDone = true
+		p.parseEnd(p.streamInPlist)
+		return nil, io.EOF
+	}
+	pval = p.parseXMLElement(element)
+	return
+}
+
+// nextStartElement returns the next element inside the named element, or a zero StartElement when it ends.
+func (p *xmlPlistParser) nextStartElement(parent string) xml.StartElement {
+	for {
+		token, err := p.xmlDecoder.Token()
+		if err != nil {
+			panic(err)
+		}
+
+		if el, ok := token.(xml.EndElement); ok && el.Name.Local == parent {
+			return xml.StartElement{}
+		}
+
+		if el, ok := token.(xml.StartElement); ok {
+			return el
+		}
+	}
+}
+
+// parseEnd reads the rest of the document after the top-level value, and the end of its <plist> element if inPlist is set.
+// Only whitespace, comments and processing instructions may follow it.
+func (p *xmlPlistParser) parseEnd(inPlist bool) {
+	for {
+		token, err := p.xmlDecoder.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+
+		switch token := token.(type) {
+		case xml.EndElement:
+			if inPlist && token.Name.Local == "plist" {
+				inPlist = false
+				continue
+			}
+			panic(fmt.Errorf("unexpected </%s> after the top-level value", token.Name.Local))
+		case xml.StartElement:
+			panic(fmt.Errorf("unexpected <%s> after the top-level value", token.Name.Local))
+		case xml.CharData:
+			if text := strings.TrimSpace(string(token)); text != "" {
+				panic(fmt.Errorf("unexpected text %q after the top-level value", text))
+			}
+		}
+	}
+}
+
+func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *Value {
+	p.checkCancel()
 	var charData xml.CharData
 	switch element.Name.Local {
 	case "plist":
@@ -189,7 +510,7 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 			panic(err)
 		}
 
-		return &plistValue{String, string(charData)}
+		return &Value{String, string(charData)}
 	case "integer":
 		p.ntags++
 		err := p.xmlDecoder.DecodeElement(&charData, &element)
@@ -205,11 +526,11 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 		if s[0] == '-' {
 			s, base := unsignedGetBase(s[1:])
 			n := mustParseInt("-"+s, base, 64)
-			return &plistValue{Integer, signedInt{uint64(n), true}}
+			return &Value{Integer, signedInt{uint64(n), true}}
 		} else {
 			s, base := unsignedGetBase(s)
 			n := mustParseUint(s, base, 64)
-			return &plistValue{Integer, signedInt{n, false}}
+			return &Value{Integer, signedInt{n, false}}
 		}
 	case "real":
 		p.ntags++
@@ -219,13 +540,13 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 		}
 
 		n := mustParseFloat(string(charData), 64)
-		return &plistValue{Real, sizedFloat{n, 64}}
+		return &Value{Real, sizedFloat{n, 64}}
 	case "true", "false":
 		p.ntags++
 		p.xmlDecoder.Skip()
 
 		b := element.Name.Local == "true"
-		return &plistValue{Boolean, b}
+		return &Value{Boolean, b}
 	case "date":
 		p.ntags++
 		err := p.xmlDecoder.DecodeElement(&charData, &element)
@@ -233,12 +554,18 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 			panic(err)
 		}
 
-		t, err := time.ParseInLocation(time.RFC3339, string(charData), time.UTC)
+		var t time.Time
+		if p.dateLayout != "" {
+			t, err = time.ParseInLocation(p.dateLayout, string(charData), time.UTC)
+		}
+		if p.dateLayout == "" || err != nil {
+			t, err = time.ParseInLocation(time.RFC3339, string(charData), time.UTC)
+		}
 		if err != nil {
 			panic(err)
 		}
 
-		return &plistValue{Date, t}
+		return &Value{Date, t}
 	case "data":
 		p.ntags++
 		err := p.xmlDecoder.DecodeElement(&charData, &element)
@@ -247,6 +574,9 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 		}
 
 		str := p.whitespaceReplacer.Replace(string(charData))
+		if p.lazyData {
+			return &Value{Data, base64Data(str)}
+		}
 
 		l := base64.StdEncoding.DecodedLen(len(str))
 		bytes := make([]uint8, l)
@@ -255,11 +585,14 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 			panic(err)
 		}
 
-		return &plistValue{Data, bytes[:l]}
+		return &Value{Data, bytes[:l]}
 	case "dict":
 		p.ntags++
+		p.enter()
+		defer p.leave()
+
 		var key *string
-		var subvalues map[string]*plistValue = make(map[string]*plistValue)
+		dict := newDictionary()
 		for {
 			token, err := p.xmlDecoder.Token()
 			if err != nil {
@@ -268,29 +601,37 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 
 			if el, ok := token.(xml.EndElement); ok && el.Name.Local == "dict" {
 				if key != nil {
-					panic(errors.New("missing value in dictionary"))
+					panic(fmt.Errorf("missing value for key %q in dictionary", *key))
 				}
 				break
 			}
 
 			if el, ok := token.(xml.StartElement); ok {
 				if el.Name.Local == "key" {
+					if key != nil {
+						panic(fmt.Errorf("missing value for key %q in dictionary", *key))
+					}
 					var k string
-					p.xmlDecoder.DecodeElement(&k, &el)
+					if err := p.xmlDecoder.DecodeElement(&k, &el); err != nil {
+						panic(err)
+					}
 					key = &k
 				} else {
 					if key == nil {
 						panic(errors.New("missing key in dictionary"))
 					}
-					subvalues[*key] = p.parseXMLElement(el)
+					dict.setParsed(*key, p.parseXMLElement(el), p.disallowDuplicateKeys)
 					key = nil
 				}
 			}
 		}
-		return &plistValue{Dictionary, &dictionary{m: subvalues}}
+		return &Value{Dictionary, dict}
 	case "array":
 		p.ntags++
-		var subvalues []*plistValue = make([]*plistValue, 0, 10)
+		p.enter()
+		defer p.leave()
+
+		var subvalues []*Value = make([]*Value, 0, 10)
 		for {
 			token, err := p.xmlDecoder.Token()
 			if err != nil {
@@ -305,7 +646,7 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 				subvalues = append(subvalues, p.parseXMLElement(el))
 			}
 		}
-		return &plistValue{Array, subvalues}
+		return &Value{Array, subvalues}
 	}
 	err := fmt.Errorf("encountered unknown element %s", element.Name.Local)
 	if p.ntags == 0 {
@@ -316,5 +657,24 @@ func (p *xmlPlistParser) parseXMLElement(element xml.StartElement) *plistValue {
 }
 
 func newXMLPlistParser(r io.Reader) *xmlPlistParser {
-	return &xmlPlistParser{r, xml.NewDecoder(r), strings.NewReplacer("\t", "", "\n", "", " ", "", "\r", ""), 0}
+	lr := &lineReader{Reader: r}
+	xmlDecoder := xml.NewDecoder(lr)
+	xmlDecoder.CharsetReader = xmlCharsetReader
+	return &xmlPlistParser{
+		depthTracker:       depthTracker{maxDepth: defaultMaxDepth},
+		reader:             lr,
+		xmlDecoder:         xmlDecoder,
+		whitespaceReplacer: strings.NewReplacer("\t", "", "\n", "", " ", "", "\r", ""),
+	}
+}
+
+// xmlCharsetReader lets the XML decoder accept documents that declare an encoding other than UTF-8.
+// The Decoder converts UTF-16 documents to UTF-8 as it reads them, so by the time their declaration is seen
+// (and for documents that are mislabeled), no further conversion is needed.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-16", "utf-16le", "utf-16be", "us-ascii", "ascii":
+		return input, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", charset)
 }
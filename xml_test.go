@@ -2,8 +2,14 @@ package plist
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
 )
 
 func BenchmarkXMLGenerate(b *testing.B) {
@@ -40,6 +46,8 @@ func TestVariousIllegalXMLPlists(t *testing.T) {
 		"<plist><string>10</plist>",
 		"<plist><dict>10</plist>",
 		"<plist><dict><key>10</plist>",
+		"<plist><dict><key>a&bogus;</key><string></string></dict></plist>",
+		"<plist><string>&#xZZ;</string></plist>",
 		"<plist>",
 		"<plist><data>",
 		"<plist><date>",
@@ -58,3 +66,350 @@ func TestVariousIllegalXMLPlists(t *testing.T) {
 		}
 	}
 }
+
+func TestXMLSyntaxErrorPosition(t *testing.T) {
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>valid</key>
+	<integer>1</integer>
+	<key>invalid</key>
+	<integer>one</integer>
+</dict>
+</plist>`
+
+	buf := bytes.NewReader([]byte(plist))
+	d := newXMLPlistParser(buf)
+	_, err := d.parseDocument()
+	t.Logf("Error: %v", err)
+
+	syntaxError, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Expected a *SyntaxError, received %#v", err)
+	}
+
+	if syntaxError.Line != 7 {
+		t.Errorf("Expected the error on line 7, received line %d", syntaxError.Line)
+	}
+
+	if !strings.Contains(err.Error(), "line 7") {
+		t.Errorf("Expected the error message to mention line 7, received %v", err)
+	}
+}
+
+func TestXMLCharacterReferences(t *testing.T) {
+	plist := `<plist><dict><key>a &amp; b</key><string>caf&#233; &lt;&#x3e; &quot;&apos;</string><key>data</key><data>&#83;GVsbG8=</data></dict></plist>`
+
+	var decoded map[string]interface{}
+	if _, err := Unmarshal([]byte(plist), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if s := decoded["a & b"]; s != `café <> "'` {
+		t.Errorf("Expected character references to be resolved, received %#v", decoded)
+	}
+	if d, ok := decoded["data"].([]byte); !ok || string(d) != "Hello" {
+		t.Errorf("Expected data to decode to Hello, received %#v", decoded["data"])
+	}
+
+	original := map[string]string{
+		"<key> & </key>": "Fish & Chips <b>café</b> ]]> &#233;",
+	}
+	data, err := Marshal(original, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "<b>") || strings.Contains(string(data), "& ") {
+		t.Errorf("Expected markup characters to be escaped, received %s", data)
+	}
+
+	var roundTripped map[string]string
+	if _, err := Unmarshal(data, &roundTripped); err != nil || roundTripped["<key> & </key>"] != original["<key> & </key>"] {
+		t.Errorf("Expected %#v, received %#v (%v)", original, roundTripped, err)
+	}
+}
+
+func TestXMLCDATA(t *testing.T) {
+	plist := `<plist><dict><key><![CDATA[<key>]]></key><string>plain &amp; <![CDATA[<b>bold</b> & ]]>plain again<![CDATA[]]]]><![CDATA[>]]></string><key>data</key><data><![CDATA[SGVs]]>bG8=</data></dict></plist>`
+
+	var decoded map[string]interface{}
+	if _, err := Unmarshal([]byte(plist), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if s := decoded["<key>"]; s != "plain & <b>bold</b> & plain again]]>" {
+		t.Errorf("Expected CDATA and character data to be concatenated in order, received %#v", decoded)
+	}
+	if d, ok := decoded["data"].([]byte); !ok || string(d) != "Hello" {
+		t.Errorf("Expected data to decode to Hello, received %#v", decoded["data"])
+	}
+}
+
+func TestXMLTrailingData(t *testing.T) {
+	valid := []string{
+		`<plist><string>a</string></plist>`,
+		`<string>a</string>`,
+		"<plist><string>a</string></plist>\n<!-- comment -->\n<?pi?>\n",
+		`<plist></plist>`,
+	}
+	for _, plist := range valid {
+		var s string
+		if _, err := Unmarshal([]byte(plist), &s); err != nil {
+			t.Errorf("%q: %v", plist, err)
+		}
+	}
+
+	trailing := []string{
+		`<plist><string>a</string></plist><plist><string>b</string></plist>`,
+		`<plist><string>a</string><string>b</string></plist>`,
+		`<plist><string>a</string></plist>garbage`,
+		`<string>a</string><string>b</string>`,
+		`<plist><string>a</string>`,
+		`<plist><array/></plist></array>`,
+	}
+	for _, plist := range trailing {
+		var s interface{}
+		if _, err := Unmarshal([]byte(plist), &s); err == nil {
+			t.Errorf("%q: expected an error, decoded %v", plist, s)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%q: expected a *SyntaxError, received %v", plist, err)
+		}
+	}
+
+	stream, err := NewDecoder(strings.NewReader(`<plist><array><integer>1</integer></array><string>b</string></plist>`)).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := stream.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stream.Decode(&n).(*SyntaxError); !ok {
+		t.Error("Expected a *SyntaxError for data after a streamed array")
+	}
+}
+
+func TestXMLDataWrapping(t *testing.T) {
+	data := make([]byte, 150) // 200 base64 characters
+	for i := range data {
+		data[i] = byte(i)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	plain, err := Marshal(data, XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(plain), "<data>"+encoded[:76]+"\n"+encoded[76:152]+"\n"+encoded[152:]+"</data>") {
+		t.Errorf("Expected data wrapped at 76 characters, received %s", plain)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("\t")
+	enc.WrapData(100)
+	if err := enc.Encode(map[string][]byte{"d": data}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\n\t<data>\n\t"+encoded[:100]+"\n\t"+encoded[100:]+"\n\t</data>\n") {
+		t.Errorf("Expected indented data wrapped at 100 characters, received %s", buf.String())
+	}
+
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.WrapData(0)
+	if err := enc.Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<data>"+encoded+"</data>") {
+		t.Errorf("Expected unwrapped data, received %s", buf.String())
+	}
+
+	for _, plist := range [][]byte{plain, buf.Bytes(), []byte("<plist><data>\r\n\t" + encoded[:50] + "\r\n  \t" + encoded[50:] + " \n</data></plist>")} {
+		var decoded []byte
+		if _, err := Unmarshal(plist, &decoded); err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("Expected %d bytes of data, received %d (%v) from %s", len(data), len(decoded), err, plist)
+		}
+	}
+}
+
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) []byte {
+	if bom {
+		s = "\uFEFF" + s
+	}
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		order.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+func TestXMLUTF16(t *testing.T) {
+	plist := `<?xml version="1.0" encoding="UTF-16"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict><key>greeting</key><string>Hello, 世界 🌍</string><key>n</key><integer>42</integer></dict></plist>`
+	expected := map[string]interface{}{"greeting": "Hello, 世界 🌍", "n": uint64(42)}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, bom := range []bool{true, false} {
+			data := encodeUTF16(plist, order, bom)
+			for _, dec := range []*Decoder{NewDecoder(bytes.NewReader(data)), NewDecoderReader(bytes.NewReader(data))} {
+				var decoded map[string]interface{}
+				if err := dec.Decode(&decoded); err != nil || dec.Format != XMLFormat || !reflect.DeepEqual(decoded, expected) {
+					t.Errorf("%v (BOM %v): expected %v, received %s %v (%v)", order, bom, expected, FormatNames[dec.Format], decoded, err)
+				}
+			}
+		}
+	}
+
+	// A document split so that a surrogate pair straddles two reads.
+	data := encodeUTF16(`<plist><string>🌍🌍🌍</string></plist>`, binary.LittleEndian, true)
+	r := newUTF16Reader(bytes.NewReader(data), binary.LittleEndian)
+	var out []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(out) != "\uFEFF<plist><string>🌍🌍🌍</string></plist>" {
+		t.Errorf("Expected the document to be converted intact, received %q", out)
+	}
+}
+
+func TestXMLLeadingMatter(t *testing.T) {
+	body := xmlPreamble + `<plist version="1.0"><dict><key>a</key><string>b</string></dict></plist>`
+	plists := map[string]string{
+		"comment":     "<!-- generated by a tool -->\n" + body,
+		"blank lines": "\n\n  \t\n" + body,
+		"shebang":     "#!/usr/bin/env plutil\n" + body,
+	}
+
+	for name, plist := range plists {
+		documents := map[string][]byte{
+			"UTF-8":    []byte(plist),
+			"UTF-16LE": encodeUTF16(strings.Replace(plist, "UTF-8", "UTF-16", 1), binary.LittleEndian, false),
+			"UTF-16BE": encodeUTF16(strings.Replace(plist, "UTF-8", "UTF-16", 1), binary.BigEndian, false),
+		}
+		for encoding, data := range documents {
+			for _, dec := range []*Decoder{NewDecoder(bytes.NewReader(data)), NewDecoderReader(bytes.NewReader(data))} {
+				var m map[string]interface{}
+				if err := dec.Decode(&m); err != nil || dec.Format != XMLFormat || m["a"] != "b" {
+					t.Errorf("%s (%s): expected XML with a=b, received %s %v (%v)", name, encoding, FormatNames[dec.Format], m, err)
+				}
+			}
+		}
+	}
+
+	// Documents too short to sniff are still read.
+	var n int
+	if _, err := Unmarshal([]byte("1"), &n); err != nil || n != 1 {
+		t.Errorf("Expected 1, received %d (%v)", n, err)
+	}
+}
+
+func TestXMLMissingDictionaryValue(t *testing.T) {
+	corrupt := map[string]string{
+		"dangling final key":  `<plist><dict><key>A</key><string>a</string><key>B</key></dict></plist>`,
+		"key followed by key": `<plist><dict><key>B</key><key>A</key><string>a</string></dict></plist>`,
+		"nested dangling key": `<plist><dict><key>A</key><dict><key>B</key></dict><key>C</key><string>c</string></dict></plist>`,
+	}
+	for name, plist := range corrupt {
+		var v interface{}
+		_, err := Unmarshal([]byte(plist), &v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected a *SyntaxError, received %v", name, err)
+		} else if !strings.Contains(err.Error(), `missing value for key "B"`) {
+			t.Errorf("%s: expected the error to name key B, received %v", name, err)
+		}
+
+		// DecodeKey skips the values before the key it's looking for without parsing them.
+		if name == "nested dangling key" {
+			continue
+		}
+		var s string
+		err = NewDecoder(strings.NewReader(plist)).DecodeKey("C", &s)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected DecodeKey to return a *SyntaxError, received %v", name, err)
+		}
+	}
+}
+
+func TestXMLSelfClosingElements(t *testing.T) {
+	var s string
+	if _, err := Unmarshal([]byte(`<plist><string/></plist>`), &s); err != nil || s != "" {
+		t.Errorf("Expected an empty string, received %q (%v)", s, err)
+	}
+
+	var b []byte
+	if _, err := Unmarshal([]byte(`<plist><data/></plist>`), &b); err != nil || b == nil || len(b) != 0 {
+		t.Errorf("Expected empty, non-nil data, received %#v (%v)", b, err)
+	}
+
+	var a []string
+	if _, err := Unmarshal([]byte(`<plist><array/></plist>`), &a); err != nil || a == nil || len(a) != 0 {
+		t.Errorf("Expected an empty, non-nil slice, received %#v (%v)", a, err)
+	}
+
+	var m map[string]string
+	if _, err := Unmarshal([]byte(`<plist><dict/></plist>`), &m); err != nil || m == nil || len(m) != 0 {
+		t.Errorf("Expected an empty, non-nil map, received %#v (%v)", m, err)
+	}
+
+	var generic interface{}
+	plist := `<plist><dict><key/><string/><key>Data</key><data/><key>Array</key><array><array/><dict/></array><key>True</key><true/></dict></plist>`
+	if _, err := Unmarshal([]byte(plist), &generic); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"":      "",
+		"Data":  []byte{},
+		"Array": []interface{}{[]interface{}{}, map[string]interface{}{}},
+		"True":  true,
+	}
+	if !reflect.DeepEqual(generic, expected) {
+		t.Errorf("Expected %#v, received %#v", expected, generic)
+	}
+
+	// Elements that can't be empty remain errors.
+	for _, plist := range []string{`<plist><integer/></plist>`, `<plist><real/></plist>`, `<plist><date/></plist>`} {
+		if _, err := Unmarshal([]byte(plist), &generic); err == nil {
+			t.Errorf("%s: expected an error", plist)
+		}
+	}
+}
+
+func TestXMLDateLayout(t *testing.T) {
+	const layout = "2006-01-02 15:04:05"
+	date := time.Date(2020, 2, 29, 13, 14, 15, 0, time.UTC)
+
+	buf := &bytes.Buffer{}
+	enc := NewEncoderForFormat(buf, XMLFormat)
+	enc.DateLayout(layout)
+	if err := enc.Encode(map[string]time.Time{"When": date}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<date>2020-02-29 13:14:15</date>") {
+		t.Errorf("Expected the date in the custom layout, received %s", buf.String())
+	}
+
+	var decoded map[string]time.Time
+	if err := NewDecoder(bytes.NewReader(buf.Bytes())).DateLayout(layout).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded["When"].Equal(date) {
+		t.Errorf("Expected %v, received %v", date, decoded["When"])
+	}
+
+	// The default decoder only accepts RFC 3339.
+	if _, err := Unmarshal(buf.Bytes(), &decoded); err == nil {
+		t.Error("Expected an error decoding a non-standard date without a layout")
+	}
+
+	// RFC 3339 dates are still accepted with a custom layout.
+	var d time.Time
+	if err := NewDecoder(strings.NewReader(`<date>2020-02-29T13:14:15Z</date>`)).DateLayout(layout).Decode(&d); err != nil || !d.Equal(date) {
+		t.Errorf("Expected %v, received %v (%v)", date, d, err)
+	}
+}